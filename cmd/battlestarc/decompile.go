@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/xyproto/battlestar/lib"
+)
+
+// decompileCommand turns a DOS .com file into Battlestar source code, like: battlestarc decompile [-o file.bts] file.com
+// Without -o, the source code is written to stdout.
+func decompileCommand(args []string) {
+	flags := flag.NewFlagSet("decompile", flag.ExitOnError)
+	outputArg := flags.String("o", "", "Output filename")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatalln("Abort: one .com filename is needed")
+	}
+	comfile := flags.Arg(0)
+	data, err := ioutil.ReadFile(comfile)
+	if err != nil {
+		log.Fatalln("Error: Could not read " + comfile)
+	}
+
+	// The log messages from compiling the instructions, to check them, are not shown
+	log.SetOutput(ioutil.Discard)
+	source, err := lib.Decompile(data)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+
+	if *outputArg == "" {
+		fmt.Print(source)
+		return
+	}
+	if err := ioutil.WriteFile(*outputArg, []byte(source), 0644); err != nil {
+		log.Fatalln("Error: Could not write " + *outputArg)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/battlestar/lib"
+)
+
+// fmtCommand formats Battlestar source code, like: battlestarc fmt [-l] [-d] [-w] [file or directory ...]
+// Without files, the source code is read from stdin and the formatted source code is written to stdout.
+func fmtCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	listArg := flags.Bool("l", false, "List the files that are not formatted")
+	diffArg := flags.Bool("d", false, "Show the changes that formatting would make")
+	writeArg := flags.Bool("w", false, "Write the formatted source code back to the files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalln("Error: Could not read from stdin")
+		}
+		fmt.Print(lib.Format(string(data)))
+		return
+	}
+
+	unformatted := false
+	for _, filename := range sourceFiles(flags.Args()) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.Fatalln("Error: Could not read " + filename)
+		}
+		source := string(data)
+		formatted := lib.Format(source)
+		if source != formatted {
+			unformatted = true
+		}
+		switch {
+		case *listArg || *diffArg:
+			if source == formatted {
+				continue
+			}
+			if *listArg {
+				fmt.Println(filename)
+			}
+			if *diffArg {
+				fmt.Print(unifiedDiff(filename, source, formatted))
+			}
+		case *writeArg:
+			if source == formatted {
+				continue
+			}
+			if err := ioutil.WriteFile(filename, []byte(formatted), 0644); err != nil {
+				log.Fatalln("Error: Could not write " + filename)
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	// Let CI fail when there are files that are not formatted
+	if unformatted && (*listArg || *diffArg) {
+		os.Exit(1)
+	}
+}
+
+// sourceFiles returns the given files, and the .bts files in the given directories
+func sourceFiles(paths []string) []string {
+	var filenames []string
+	for _, path := range paths {
+		if fi, err := os.Stat(path); (err != nil) || !fi.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		filepath.Walk(path, func(filename string, fi os.FileInfo, err error) error {
+			if (err == nil) && !fi.IsDir() && strings.HasSuffix(filename, ".bts") {
+				filenames = append(filenames, filename)
+			}
+			return nil
+		})
+	}
+	return filenames
+}
+
+// unifiedDiff returns the differences between two versions of a file, in the unified diff format
+func unifiedDiff(filename, a, b string) string {
+	const context = 3
+	x, y := strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	if x[len(x)-1] == "" {
+		x = x[:len(x)-1]
+	}
+	if y[len(y)-1] == "" {
+		y = y[:len(y)-1]
+	}
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	// Each edit is a line prefixed with " ", "-" or "+"
+	var edits []string
+	i, j := 0, 0
+	for (i < len(x)) || (j < len(y)) {
+		switch {
+		case (i < len(x)) && (j < len(y)) && (x[i] == y[j]):
+			edits = append(edits, " "+x[i])
+			i++
+			j++
+		case (j == len(y)) || ((i < len(x)) && (lcs[i+1][j] >= lcs[i][j+1])):
+			edits = append(edits, "-"+x[i])
+			i++
+		default:
+			edits = append(edits, "+"+y[j])
+			j++
+		}
+	}
+	// Group the edits into hunks, with some unchanged lines around the changes
+	var sb strings.Builder
+	sb.WriteString("--- " + filename + ".orig\n+++ " + filename + "\n")
+	lineA, lineB := 1, 1
+	for start := 0; start < len(edits); {
+		if edits[start][0] == ' ' {
+			start++
+			lineA++
+			lineB++
+			continue
+		}
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		// The hunk ends when there are more than two times the context of unchanged lines
+		end, unchanged := start, 0
+		for ; (end < len(edits)) && (unchanged <= 2*context); end++ {
+			if edits[end][0] == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		if unchanged > context {
+			end -= unchanged - context
+		}
+		countA, countB := 0, 0
+		for _, edit := range edits[from:end] {
+			if edit[0] != '+' {
+				countA++
+			}
+			if edit[0] != '-' {
+				countB++
+			}
+		}
+		startA, startB := lineA-(start-from), lineB-(start-from)
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB)
+		for _, edit := range edits[from:end] {
+			sb.WriteString(edit)
+			if !strings.HasSuffix(edit, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		lineA, lineB = startA+countA, startB+countB
+		start = end
+	}
+	return sb.String()
+}
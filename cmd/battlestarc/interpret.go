@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/xyproto/battlestar/lib"
+)
+
+// interpretCommand compiles a program for 32-bit or 64-bit Linux and interprets the generated assembly,
+// like: battlestarc interpret [-bits=64] [-I dir] file.bts. Neither an assembler nor a linker is needed.
+func interpretCommand(args []string) {
+	flags := flag.NewFlagSet("interpret", flag.ExitOnError)
+	bitsArg := flags.Int("bits", 64, "Interpret the program as 64-bit or 32-bit")
+	var includeDirs stringList
+	flags.Var(&includeDirs, "I", "Directory to search for modules (can be given several times)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatalln("Abort: one source filename is needed")
+	}
+	btsfile := flags.Arg(0)
+	data, err := ioutil.ReadFile(btsfile)
+	if err != nil {
+		log.Fatalln("Error: Could not read " + btsfile)
+	}
+	// The log messages from the compiler are not shown, so that they are not mixed with the output of the program
+	log.SetOutput(ioutil.Discard)
+	exitCode, err := lib.InterpretProgram(string(data), btsfile, *bitsArg, lib.ModuleSearchPath(includeDirs), os.Stdin, os.Stdout, os.Stderr)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		var compileError *lib.CompileError
+		if errors.As(err, &compileError) && (compileError.Filename == btsfile) && (compileError.Line > 0) {
+			// Start the error message with the line number
+			log.SetPrefix("line " + strconv.Itoa(compileError.Line) + ": ")
+		}
+		log.Fatalln("Error:", err)
+	}
+	os.Exit(exitCode)
+}
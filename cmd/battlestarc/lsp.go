@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/battlestar/lib"
+)
+
+// The kinds of completion items and symbols, as numbered by the Language Server Protocol
+var (
+	completionDetails = map[string]string{"keywords": "keyword", "builtins": "built-in function", "registers": "register", "reserved": "reserved"}
+	completionKinds   = map[string]int{"keywords": 14, "builtins": 3, "registers": 6, "reserved": 6, "fun": 3, "const": 21, "var": 6, "macro": 3, "struct": 22}
+	symbolKinds       = map[string]int{"fun": 12, "const": 14, "var": 13, "macro": 12, "struct": 23}
+)
+
+type (
+	// lspMessage is a request, a response or a notification
+	lspMessage struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id,omitempty"`
+		Method  string           `json:"method,omitempty"`
+		Params  json.RawMessage  `json:"params,omitempty"`
+		Result  interface{}      `json:"result,omitempty"`
+		Error   *lspError        `json:"error,omitempty"`
+	}
+
+	lspError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+
+	lspLocation struct {
+		URI   string   `json:"uri"`
+		Range lspRange `json:"range"`
+	}
+
+	// lspParams has the fields of the parameters that are used, for all the handled methods
+	lspParams struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		Position lspPosition `json:"position"`
+	}
+
+	// lspServer keeps track of the open documents and how to compile them
+	lspServer struct {
+		out          io.Writer
+		platformBits int
+		documents    map[string]*lspDocument
+	}
+
+	// lspDocument is an open document, and the assembly for each line from the last time it compiled
+	lspDocument struct {
+		text     string
+		assembly map[int]string
+	}
+)
+
+// lspCommand runs a language server that communicates over stdin and stdout, like: battlestarc lsp [-bits=32]
+func lspCommand(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	platformBitsArg := flags.Int("bits", 64, "Compile for 64-bit, 32-bit or 16-bit x86, for the diagnostics and hover")
+	flags.Parse(args)
+
+	server := &lspServer{os.Stdout, *platformBitsArg, make(map[string]*lspDocument)}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		msg, err := readMessage(r)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Fatalln("Error:", err)
+		}
+		server.handle(msg)
+	}
+}
+
+// readMessage reads one message, that has a Content-Length header
+func readMessage(r *bufio.Reader) (*lspMessage, error) {
+	length := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):])); err != nil {
+				return nil, fmt.Errorf("invalid header: %s", line)
+			}
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// send writes a message, with a Content-Length header
+func (s *lspServer) send(msg *lspMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// handle handles a request or a notification from the editor
+func (s *lspServer) handle(msg *lspMessage) {
+	var params lspParams
+	json.Unmarshal(msg.Params, &params)
+	uri := params.TextDocument.URI
+	var result interface{}
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // the full text is sent for every change
+				"completionProvider":     map[string]interface{}{},
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": name, "version": version},
+		}
+	case "shutdown":
+	case "exit":
+		os.Exit(0)
+	case "textDocument/didOpen":
+		s.documents[uri] = &lspDocument{text: params.TextDocument.Text}
+		s.compile(uri)
+	case "textDocument/didChange":
+		if doc, ok := s.documents[uri]; ok && (len(params.ContentChanges) > 0) {
+			doc.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+			s.compile(uri)
+		}
+	case "textDocument/didSave":
+		s.compile(uri)
+	case "textDocument/didClose":
+		delete(s.documents, uri)
+		s.publishDiagnostics(uri, []interface{}{})
+	case "textDocument/completion":
+		result = s.completion(uri)
+	case "textDocument/hover":
+		result = s.hover(uri, params.Position)
+	case "textDocument/definition":
+		result = s.definition(uri, params.Position)
+	case "textDocument/documentSymbol":
+		result = s.documentSymbols(uri)
+	default:
+		if msg.ID != nil {
+			s.send(&lspMessage{ID: msg.ID, Error: &lspError{-32601, "Method not found: " + msg.Method}})
+		}
+		return
+	}
+	if msg.ID != nil {
+		if result == nil {
+			// A response without a result, like for shutdown or a hover over nothing
+			result = json.RawMessage("null")
+		}
+		s.send(&lspMessage{ID: msg.ID, Result: result})
+	}
+}
+
+// compile compiles the given document, then publishes the errors and keeps the assembly for hover
+func (s *lspServer) compile(uri string) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return
+	}
+	config, err := lib.NewTargetConfig(s.platformBits, false, false)
+	if err != nil {
+		return
+	}
+	config.LineMarkers = true
+	// The compiler logs what it does, which is not needed for every change in the editor
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	// The modules that the document uses are found next to the document
+	filename := uriPath(uri)
+	asmcode, _, _, _, err := config.Compile(doc.text, filename, lib.ModuleSearchPath(nil), false, lib.NewProgramState())
+	if err == nil {
+		doc.assembly = lib.AssemblyByLine(asmcode)
+		s.publishDiagnostics(uri, []interface{}{})
+		return
+	}
+	// Place the error on the line it is about, or on the first line if it is about another file
+	message := err.Error()
+	line := 0
+	var compileError *lib.CompileError
+	if errors.As(err, &compileError) && (compileError.Line > 0) {
+		if compileError.Filename == filename {
+			line = compileError.Line - 1
+		} else {
+			message = filepath.Base(compileError.Filename) + ":" + strconv.Itoa(compileError.Line) + ": " + message
+		}
+	}
+	lines := strings.Split(doc.text, "\n")
+	if line >= len(lines) {
+		line = 0
+	}
+	s.publishDiagnostics(uri, []interface{}{map[string]interface{}{
+		"range":    lspRange{lspPosition{line, 0}, lspPosition{line, len(lines[line])}},
+		"severity": 1,
+		"source":   "battlestarc",
+		"message":  message,
+	}})
+}
+
+// publishDiagnostics sends the given diagnostics for a document to the editor
+func (s *lspServer) publishDiagnostics(uri string, diagnostics []interface{}) {
+	params, _ := json.Marshal(map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+	s.send(&lspMessage{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// completion returns the keywords, built-in functions, registers and reserved names,
+// and the names that are defined in the document
+func (s *lspServer) completion(uri string) []map[string]interface{} {
+	var items []map[string]interface{}
+	words := lib.LanguageWords()
+	for _, category := range []string{"keywords", "builtins", "registers", "reserved"} {
+		for _, word := range words[category] {
+			items = append(items, map[string]interface{}{"label": word, "kind": completionKinds[category], "detail": completionDetails[category]})
+		}
+	}
+	if doc, ok := s.documents[uri]; ok {
+		for _, symbol := range lib.Symbols(doc.text) {
+			items = append(items, map[string]interface{}{"label": symbol.Name, "kind": completionKinds[symbol.Kind], "detail": symbol.Kind})
+		}
+	}
+	return items
+}
+
+// hover returns the assembly that the statement on the given line compiles to
+func (s *lspServer) hover(uri string, pos lspPosition) interface{} {
+	doc, ok := s.documents[uri]
+	if !ok || (doc.assembly == nil) || (doc.assembly[pos.Line] == "") {
+		return nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": "```nasm\n" + doc.assembly[pos.Line] + "```"},
+	}
+}
+
+// definition returns where the function, constant, variable, macro or struct at the given position is defined
+func (s *lspServer) definition(uri string, pos lspPosition) interface{} {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil
+	}
+	word := wordAt(doc.text, pos)
+	for _, symbol := range lib.Symbols(doc.text) {
+		if symbol.Name == word {
+			return lspLocation{uri, symbolRange(symbol)}
+		}
+	}
+	return nil
+}
+
+// documentSymbols returns the functions, constants, variables, macros and structs of a document
+func (s *lspServer) documentSymbols(uri string) []map[string]interface{} {
+	symbols := []map[string]interface{}{}
+	doc, ok := s.documents[uri]
+	if !ok {
+		return symbols
+	}
+	for _, symbol := range lib.Symbols(doc.text) {
+		r := symbolRange(symbol)
+		symbols = append(symbols, map[string]interface{}{"name": symbol.Name, "detail": symbol.Kind, "kind": symbolKinds[symbol.Kind], "range": r, "selectionRange": r})
+	}
+	return symbols
+}
+
+// symbolRange returns the range of the name of a symbol, where it is defined
+func symbolRange(symbol lib.Symbol) lspRange {
+	return lspRange{lspPosition{symbol.Line, symbol.Column}, lspPosition{symbol.Line, symbol.Column + len(symbol.Name)}}
+}
+
+// wordAt returns the name at the given position in the text
+func wordAt(text string, pos lspPosition) string {
+	lines := strings.Split(text, "\n")
+	if (pos.Line < 0) || (pos.Line >= len(lines)) {
+		return ""
+	}
+	line := lines[pos.Line]
+	isNameChar := func(c byte) bool {
+		return (c == '_') || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9'))
+	}
+	start, end := pos.Character, pos.Character
+	if end > len(line) {
+		start, end = len(line), len(line)
+	}
+	for (start > 0) && isNameChar(line[start-1]) {
+		start--
+	}
+	for (end < len(line)) && isNameChar(line[end]) {
+		end++
+	}
+	return line[start:end]
+}
+
+// uriPath returns the filename of a file:// URI
+func uriPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && (u.Scheme == "file") {
+		return u.Path
+	}
+	return uri
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const lspTestURI = "file:///home/user/main.bts"
+
+const lspTestSource = `fun greet
+    rax = 1
+end
+
+fun main
+    greet
+    rbx = 3
+end
+`
+
+// lspRequest sends a request or a notification to the server, and returns the messages it sends back
+func lspRequest(t *testing.T, s *lspServer, id int, method string, params interface{}) []*lspMessage {
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &lspMessage{JSONRPC: "2.0", Method: method, Params: data}
+	if id > 0 {
+		rawID := json.RawMessage(mustMarshal(t, id))
+		msg.ID = &rawID
+	}
+	out := s.out.(*bytes.Buffer)
+	out.Reset()
+	s.handle(msg)
+	var replies []*lspMessage
+	r := bufio.NewReader(out)
+	for {
+		reply, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// lspDecode converts a result or parameters that were read as JSON to the given type
+func lspDecode(t *testing.T, v interface{}, result interface{}) {
+	var data []byte
+	if raw, ok := v.(json.RawMessage); ok {
+		data = raw
+	} else {
+		data = mustMarshal(t, v)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lspDiagnostics opens or changes a document and returns the diagnostics that are published for it
+func lspDiagnostics(t *testing.T, s *lspServer, method string, params interface{}) []struct {
+	Range   lspRange `json:"range"`
+	Message string   `json:"message"`
+} {
+	replies := lspRequest(t, s, 0, method, params)
+	if (len(replies) != 1) || (replies[0].Method != "textDocument/publishDiagnostics") {
+		t.Fatalf("expected the diagnostics to be published after %s, got %v", method, replies)
+	}
+	var diagnostics struct {
+		URI         string `json:"uri"`
+		Diagnostics []struct {
+			Range   lspRange `json:"range"`
+			Message string   `json:"message"`
+		} `json:"diagnostics"`
+	}
+	lspDecode(t, replies[0].Params, &diagnostics)
+	if diagnostics.URI != lspTestURI {
+		t.Errorf("expected the diagnostics for %s, got %s", lspTestURI, diagnostics.URI)
+	}
+	return diagnostics.Diagnostics
+}
+
+func newTestServer() *lspServer {
+	return &lspServer{&bytes.Buffer{}, 64, make(map[string]*lspDocument)}
+}
+
+func openParams(text string) map[string]interface{} {
+	return map[string]interface{}{"textDocument": map[string]string{"uri": lspTestURI, "languageId": "battlestar", "text": text}}
+}
+
+func positionParams(line, character int) map[string]interface{} {
+	return map[string]interface{}{"textDocument": map[string]string{"uri": lspTestURI}, "position": lspPosition{line, character}}
+}
+
+func TestLSPInitialize(t *testing.T) {
+	replies := lspRequest(t, newTestServer(), 1, "initialize", map[string]interface{}{"capabilities": map[string]interface{}{}})
+	if len(replies) != 1 {
+		t.Fatalf("expected one response, got %d", len(replies))
+	}
+	var result struct {
+		Capabilities struct {
+			TextDocumentSync       int  `json:"textDocumentSync"`
+			HoverProvider          bool `json:"hoverProvider"`
+			DefinitionProvider     bool `json:"definitionProvider"`
+			DocumentSymbolProvider bool `json:"documentSymbolProvider"`
+		} `json:"capabilities"`
+		ServerInfo struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	lspDecode(t, replies[0].Result, &result)
+	c := result.Capabilities
+	if (c.TextDocumentSync != 1) || !c.HoverProvider || !c.DefinitionProvider || !c.DocumentSymbolProvider {
+		t.Errorf("expected full text sync, hover, definition and document symbols, got %+v", c)
+	}
+	if result.ServerInfo.Name != name {
+		t.Errorf("expected the server name %q, got %q", name, result.ServerInfo.Name)
+	}
+	replies = lspRequest(t, newTestServer(), 2, "nothing/here", nil)
+	if (len(replies) != 1) || (replies[0].Error == nil) || (replies[0].Error.Code != -32601) {
+		t.Errorf("expected a method not found error, got %v", replies)
+	}
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	s := newTestServer()
+	if diagnostics := lspDiagnostics(t, s, "textDocument/didOpen", openParams(lspTestSource)); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diagnostics)
+	}
+	broken := strings.Replace(lspTestSource, "rbx = 3", "rbx = 3 3", 1)
+	changeParams := map[string]interface{}{
+		"textDocument":   map[string]string{"uri": lspTestURI},
+		"contentChanges": []map[string]string{{"text": broken}},
+	}
+	diagnostics := lspDiagnostics(t, s, "textDocument/didChange", changeParams)
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", diagnostics)
+	}
+	expected := lspRange{lspPosition{6, 0}, lspPosition{6, len("    rbx = 3 3")}}
+	if diagnostics[0].Range != expected {
+		t.Errorf("expected the diagnostic at %v, got %v", expected, diagnostics[0].Range)
+	}
+	if (diagnostics[0].Message == "") || strings.HasPrefix(diagnostics[0].Message, "Error") {
+		t.Errorf("expected the message from the compiler, without a prefix, got %q", diagnostics[0].Message)
+	}
+	if diagnostics := lspDiagnostics(t, s, "textDocument/didClose", positionParams(0, 0)); len(diagnostics) != 0 {
+		t.Errorf("expected the diagnostics to be cleared when the document is closed, got %v", diagnostics)
+	}
+}
+
+func TestLSPHover(t *testing.T) {
+	s := newTestServer()
+	lspDiagnostics(t, s, "textDocument/didOpen", openParams(lspTestSource))
+	replies := lspRequest(t, s, 3, "textDocument/hover", positionParams(6, 6))
+	if len(replies) != 1 {
+		t.Fatalf("expected one response, got %d", len(replies))
+	}
+	var hover struct {
+		Contents struct {
+			Kind  string `json:"kind"`
+			Value string `json:"value"`
+		} `json:"contents"`
+	}
+	lspDecode(t, replies[0].Result, &hover)
+	if (hover.Contents.Kind != "markdown") || !strings.Contains(hover.Contents.Value, "mov rbx, 3") {
+		t.Errorf("expected the assembly for rbx = 3, got %+v", hover.Contents)
+	}
+	// Nothing to show for an empty line
+	replies = lspRequest(t, s, 4, "textDocument/hover", positionParams(3, 0))
+	if (len(replies) != 1) || (replies[0].Result != nil) {
+		t.Errorf("expected a null result for an empty line, got %v", replies)
+	}
+}
+
+func TestLSPDefinition(t *testing.T) {
+	s := newTestServer()
+	lspDiagnostics(t, s, "textDocument/didOpen", openParams(lspTestSource))
+	replies := lspRequest(t, s, 5, "textDocument/definition", positionParams(5, 6))
+	if len(replies) != 1 {
+		t.Fatalf("expected one response, got %d", len(replies))
+	}
+	var location lspLocation
+	lspDecode(t, replies[0].Result, &location)
+	expected := lspLocation{lspTestURI, lspRange{lspPosition{0, 4}, lspPosition{0, 9}}}
+	if location != expected {
+		t.Errorf("expected greet to be defined at %v, got %v", expected, location)
+	}
+	replies = lspRequest(t, s, 6, "textDocument/definition", positionParams(6, 4))
+	if (len(replies) != 1) || (replies[0].Result != nil) {
+		t.Errorf("expected a null result for a register, got %v", replies)
+	}
+}
@@ -3,10 +3,13 @@ package main
 // TODO: Add line numbers to the error messages and make them parseable by editors and IDEs
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,7 +21,37 @@ const (
 	version = "0.7.0"
 )
 
+// stringList is a flag that can be given several times, like -I
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ", ")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			fmtCommand(os.Args[2:])
+			return
+		case "lsp":
+			lspCommand(os.Args[2:])
+			return
+		case "interpret":
+			interpretCommand(os.Args[2:])
+			return
+		case "decompile":
+			decompileCommand(os.Args[2:])
+			return
+		}
+	}
+
 	log.Printf("%s %s\n", name, version)
 
 	ps := lib.NewProgramState()
@@ -34,13 +67,28 @@ func main() {
 	// Assembly output file
 	asmfileArg := flag.String("o", "", "Assembly output file")
 	// C output file
-	cfileArg := flag.String("oc", "", "C output file")
+	cfileArg := flag.String("oc", "", "C output file (the code of other inline languages is written next to it, like x.zig for x.c)")
+	// Compilation and linking flags for C libraries
+	flagsfileArg := flag.String("of", "", "Output file for the flags of the used C libraries and the commands for the inline languages")
 	// Input file
 	btsfileArg := flag.String("f", "", "BTS source file")
 	// Is it not a standalone program, but a component? (just the .o file is needed)
 	componentArg := flag.Bool("c", false, "Component, not a standalone program")
 	// Bootable kernel instead of an executable?
 	bootableArg := flag.Bool("bootable", false, "Bootable kernel instead of an executable")
+	// Executable, bootable kernel or boot sector?
+	targetArg := flag.String("target", "executable", "What to output: executable, kernel (like -bootable) or bootsector (a 16-bit 512-byte boot sector)")
+	// Only expand the macros and output the resulting source code?
+	expandArg := flag.Bool("E", false, "Output the source code with all macros expanded, then exit")
+	// Only list the supported statement forms?
+	rulesArg := flag.Bool("rules", false, "List the supported statement forms for each platform, then exit")
+	// Mark the source line of each statement in the assembly, and start the error messages with the line number?
+	linesArg := flag.Bool("lines", false, "Mark the source line of each statement in the assembly output and in the error messages")
+	// Map the assembly and the inline C to the source lines, for debuggers?
+	debugArg := flag.Bool("g", false, "Add %line directives to the assembly output and #line directives to the C output, for debugging")
+	// Where to look for modules, in addition to $BTSPATH
+	var includeDirs stringList
+	flag.Var(&includeDirs, "I", "Directory to search for modules (can be given several times)")
 
 	flag.Parse()
 
@@ -48,9 +96,42 @@ func main() {
 	macOS := *macOSArg
 	asmfile := *asmfileArg
 	cfile := *cfileArg
+	flagsfile := *flagsfileArg
 	btsfile := *btsfileArg
 	component := *componentArg
 	bootableKernel := *bootableArg
+	expandOnly := *expandArg
+
+	bootSector := false
+	switch *targetArg {
+	case "executable":
+	case "kernel":
+		bootableKernel = true
+	case "bootsector":
+		bootSector = true
+		// A boot sector is always 16-bit, but -bits must not say otherwise
+		flag.Visit(func(f *flag.Flag) {
+			if (f.Name == "bits") && (platformBits != 16) {
+				log.Fatalln("Error: A boot sector must be 16-bit, not", platformBits)
+			}
+		})
+		platformBits = 16
+		if bootableKernel {
+			log.Fatalln("Error: A boot sector can not also be a bootable kernel")
+		}
+		if component {
+			log.Fatalln("Error: A boot sector can not be a component")
+		}
+	default:
+		log.Fatalln("Error: Unknown target:", *targetArg)
+	}
+
+	if *rulesArg {
+		for _, bits := range []int{16, 32, 64} {
+			fmt.Printf("--- %d-bit ---\n%s\n", bits, lib.Rules(bits))
+		}
+		return
+	}
 
 	if flag.Arg(0) != "" {
 		btsfile = flag.Arg(0)
@@ -68,20 +149,39 @@ func main() {
 		cfile = btsfile + ".c"
 	}
 
+	if flagsfile == "" {
+		flagsfile = btsfile + ".flags"
+	}
+
 	// Assembly file contents
 	asmdata := ""
 
 	// C file contents
 	cdata := ""
 
+	// Flags for the used C libraries
+	flagsdata := ""
+
+	// The code of the other inline languages, like Zig, by filename
+	inlineFiles := make(map[string]string)
+
 	// Prepare to parse, tokenize and output code for a specific platform
-	targetConfig, err := lib.NewTargetConfig(platformBits, bootableKernel, macOS)
+	targetConfig, err := lib.NewTargetConfig(platformBits, macOS, bootableKernel)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	targetConfig.BootSector = bootSector
 
 	// Read code from stdin and output 32-bit or 64-bit assembly code
 	bytes, err := ioutil.ReadFile(btsfile)
+	if err == nil && expandOnly {
+		expanded, err := lib.ExpandMacros(string(bytes))
+		if err != nil {
+			log.Fatalln("Error:", err)
+		}
+		fmt.Print(expanded)
+		return
+	}
 	if err == nil {
 		if len(strings.TrimSpace(string(bytes))) == 0 {
 			// Empty program
@@ -91,57 +191,35 @@ func main() {
 		t := time.Now()
 		asmdata += fmt.Sprintf("; Generated with %s %s, at %s\n\n", name, version, t.String()[:16])
 
-		// If "bootable" is the first token
-		bootableFirstToken := false
-		if temptokens := targetConfig.Tokenize(string(bytes), " "); (len(temptokens) > 2) && (temptokens[0].T == lib.KEYWORD) && (temptokens[0].Value == "bootable") && (temptokens[1].T == lib.SEP) {
-			bootableFirstToken = true
-			asmdata += fmt.Sprintf("bits %d\n", targetConfig.PlatformBits)
-		} else {
-			// Header for regular programs
-			asmdata += fmt.Sprintf("bits %d\n", targetConfig.PlatformBits)
-		}
-
-		btsCode := targetConfig.AddExternMainIfMissing(string(bytes))
-		tokens := targetConfig.AddExitTokenIfMissing(targetConfig.Tokenize(btsCode, " "))
-		log.Println("--- Done tokenizing ---")
-		constants, asmcode := targetConfig.TokensToAssembly(tokens, true, false, ps)
-		if constants != "" {
-			asmdata += "section .data\n"
-			asmdata += constants + "\n"
-		}
-		if targetConfig.PlatformBits == 16 {
-			asmdata += "org 0x100\n"
-		}
-		if !bootableFirstToken {
-			asmdata += "\nsection .text\n"
-		}
-		if targetConfig.PlatformBits == 16 {
-			// If there are defined functions, jump over the definitions and start at
-			// the main/_start function. If there is a main function, jump to the
-			// linker start function. If not, just start at the top.
-			// TODO: This is a quick fix. Don't depend on the comment, find a better way.
-			if strings.Count(asmcode, "; name of the function") > 1 && strings.Contains(asmcode, "\nmain:") {
-				asmdata += "jmp " + targetConfig.LinkerStartFunction + "\n"
-			}
-		}
-		if asmcode != "" {
-			if component {
-				asmdata += asmcode + "\n"
-			} else {
-				asmdata += targetConfig.AddStartingPointIfMissing(asmcode, ps) + "\n"
-			}
-			if bootableFirstToken {
-				reg := "esp"
-				if targetConfig.PlatformBits == 64 {
-					reg = "rsp"
-				}
-				asmdata = strings.Replace(asmdata, "; starting point of the program\n", "; starting point of the program\n\tmov "+reg+", stack_top\t; set the "+reg+" register to the top of the stack (special case for bootable kernels)\n", 1)
+		targetConfig.LineMarkers = *linesArg
+		targetConfig.DebugInfo = *debugArg
+		asmcode, ccode, inline, flags, err := targetConfig.Compile(string(bytes), btsfile, lib.ModuleSearchPath(includeDirs), component, ps)
+		if err != nil {
+			var compileError *lib.CompileError
+			if *linesArg && errors.As(err, &compileError) && (compileError.Filename == btsfile) && (compileError.Line > 0) {
+				// Start the error message with the line number
+				log.SetPrefix("line " + strconv.Itoa(compileError.Line) + ": ")
 			}
+			log.Fatalln("Error:", err)
 		}
-		if ccode := lib.ExtractInlineC(strings.TrimSpace(string(bytes)), true); ccode != "" {
+		asmdata += asmcode
+		flagsdata = flags
+		if ccode != "" {
 			cdata += fmt.Sprintf("// Generated with %s %s, at %s\n\n", name, version, t.String()[:16])
 			cdata += ccode
 		}
+		// The code of the other inline languages is written next to the C code, and compiled with the commands in the flags file
+		var languages []*lib.InlineLanguage
+		prefix := strings.TrimSuffix(cfile, ".c")
+		for _, language := range lib.InlineLanguages() {
+			if code, ok := inline[language.Name]; ok {
+				languages = append(languages, language)
+				inlineFiles[prefix+language.Extension] = fmt.Sprintf("%s Generated with %s %s, at %s\n\n", language.Comment, name, version, t.String()[:16]) + code
+			}
+		}
+		if len(languages) > 0 {
+			flagsdata += lib.InlineFlags(languages, prefix, platformBits)
+		}
 	}
 
 	log.Println("--- Finalizing ---")
@@ -160,5 +238,19 @@ func main() {
 		log.Printf("Wrote %s (%d bytes)\n", cfile, len(cdata))
 	}
 
+	for filename, data := range inlineFiles {
+		if ioutil.WriteFile(filename, []byte(data), 0644) != nil {
+			log.Fatalln("Error: Unable to write to", filename)
+		}
+		log.Printf("Wrote %s (%d bytes)\n", filename, len(data))
+	}
+
+	if flagsdata != "" {
+		if ioutil.WriteFile(flagsfile, []byte(flagsdata), 0644) != nil {
+			log.Fatalln("Error: Unable to write to", flagsfile)
+		}
+		log.Printf("Wrote %s (%d bytes)\n", flagsfile, len(flagsdata))
+	}
+
 	log.Println("Done.")
 }
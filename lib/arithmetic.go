@@ -0,0 +1,308 @@
+package lib
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// registerBits returns the size of the given general purpose register, in bits, or 0 if it is not one
+func registerBits(reg string) int {
+	switch reg {
+	case "ah", "al", "bh", "bl", "ch", "cl", "dh", "dl", "sil", "dil", "spl", "bpl":
+		return 8
+	case "ax", "bx", "cx", "dx", "si", "di", "sp", "bp":
+		return 16
+	case "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15":
+		return 64
+	}
+	if is32bit(reg) && reg != "eip" {
+		return 32
+	}
+	if strings.HasPrefix(reg, "r") && is32bit("e"+reg[1:]) && reg != "rip" {
+		return 64
+	}
+	return 0
+}
+
+// regFamily returns the 16-bit name of the register that the given register is a part of,
+// like "ax" for al, ah, ax, eax and rax. r8 to r15 are returned as they are.
+func regFamily(reg string) string {
+	switch reg {
+	case "ah", "al", "bh", "bl", "ch", "cl", "dh", "dl":
+		return string(reg[0]) + "x"
+	case "sil", "dil", "spl", "bpl":
+		return reg[:2]
+	}
+	if registerBits(reg) >= 32 && !has([]string{"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}, reg) {
+		return reg[1:]
+	}
+	return reg
+}
+
+// sizedRegister returns the register in the given register family that has the given size in bits
+func sizedRegister(family string, bits int) string {
+	if strings.HasPrefix(family, "r") {
+		// r8 to r15
+		switch bits {
+		case 8:
+			return family + "b"
+		case 16:
+			return family + "w"
+		case 32:
+			return family + "d"
+		}
+		return family
+	}
+	switch bits {
+	case 8:
+		if strings.HasSuffix(family, "x") {
+			return family[:1] + "l"
+		}
+		return family + "l"
+	case 32:
+		return "e" + family
+	case 64:
+		return "r" + family
+	}
+	return family
+}
+
+// nativeRegister returns the register in the given register family that has the size of the platform
+func (config *TargetConfig) nativeRegister(family string) string {
+	if strings.HasPrefix(family, "r") {
+		return family
+	}
+	return sizedRegister(family, config.PlatformBits)
+}
+
+// sizeQualifier returns the qualifier for a memory operand of the given size in bits
+func sizeQualifier(bits int) string {
+	switch bits {
+	case 8:
+		return "byte"
+	case 16:
+		return "word"
+	case 32:
+		return "dword"
+	}
+	return "qword"
+}
+
+// powerOfTwo returns n if the given value is 2 to the power of n, for values from 2 to 128
+func powerOfTwo(value string) (int, bool) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	for n := 1; n <= 7; n++ {
+		if i == 1<<uint(n) {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// extendedAssignment returns assembly code for assigning a register to a larger register,
+// with sign extension (movsx) if signed is true, or with zero extension (movzx) if not.
+func extendedAssignment(dest, src string, signed bool) string {
+	destBits, srcBits := registerBits(dest), registerBits(src)
+	op := "="
+	if signed {
+		op = "=s"
+	}
+	comment := "\t\t\t; " + dest + " " + op + " " + src
+	switch {
+	case destBits == 0 || srcBits == 0 || destBits == srcBits:
+		return "\tmov " + dest + ", " + src + comment
+	case destBits < srcBits:
+		fatal("Error: Can not assign the", srcBits, "bit register", src, "to the", destBits, "bit register", dest)
+	case signed && srcBits == 32:
+		return "\tmovsxd " + dest + ", " + src + comment
+	case signed:
+		return "\tmovsx " + dest + ", " + src + comment
+	case srcBits == 32:
+		// Writing to a 32-bit register clears the upper half of the 64-bit register
+		return "\tmov " + sizedRegister(regFamily(dest), 32) + ", " + src + comment
+	}
+	return "\tmovzx " + dest + ", " + src + comment
+}
+
+// signedMultiplication returns assembly code for multiplying a register with a signed value
+func (config *TargetConfig) signedMultiplication(dest string, factor Token) string {
+	bits := registerBits(dest)
+	comment := "\t\t\t; " + dest + " *s= " + factor.Value
+	if bits < 16 {
+		fatal("Error: Signed multiplication is only supported for 16, 32 and 64-bit registers, not", dest)
+	}
+	switch factor.T {
+	case VALUE:
+		if n, ok := powerOfTwo(factor.Value); ok {
+			return "\tsal " + dest + ", " + strconv.Itoa(n) + comment
+		}
+		return "\timul " + dest + ", " + dest + ", " + factor.Value + comment
+	case MEMEXP:
+		return "\timul " + dest + ", " + sizeQualifier(bits) + " " + factor.Value + comment
+	case REGISTER:
+		if registerBits(factor.Value) == bits {
+			return "\timul " + dest + ", " + factor.Value + comment
+		}
+		// Sign extend the factor to a scratch register first
+		scratch := "cx"
+		if regFamily(dest) == scratch {
+			scratch = "bx"
+		}
+		asmcode := "\tpush " + config.nativeRegister(scratch) + "\t\t\t; save " + config.nativeRegister(scratch) + "\n"
+		asmcode += extendedAssignment(sizedRegister(scratch, bits), factor.Value, true) + "\n"
+		asmcode += "\timul " + dest + ", " + sizedRegister(scratch, bits) + comment + "\n"
+		asmcode += "\tpop " + config.nativeRegister(scratch) + "\t\t\t; restore " + config.nativeRegister(scratch)
+		return asmcode
+	}
+	fatal("Error: Can not multiply", dest, "with", factor.Value)
+	return ""
+}
+
+// division returns assembly code for dividing a register, a memory location or a value by a register,
+// a memory location or a value, and placing the result in the dest register. The dividend is placed in
+// the a register and extended into the d register (or ah, for 8-bit registers), with cbw, cwd, cdq or cqo
+// if signed is true, or by clearing it if not. If remainder is true, the result is the remainder instead
+// of the quotient. The a and d registers, and the scratch register that is used for the divisor, are
+// saved and restored, unless the result is placed in the a register. Then the remainder is left in the
+// d register and the quotient in the a register, for use afterwards.
+func (config *TargetConfig) division(dest string, dividend, divisor Token, signed, remainder bool) string {
+	var (
+		bits    = registerBits(dest)
+		family  = regFamily(dest)
+		a       = sizedRegister("ax", bits)
+		d       = sizedRegister("dx", bits)
+		op      = "/"
+		instr   = "div"
+		asmcode string
+	)
+	if bits == 0 {
+		fatal("Error: Can not divide", dest+", only general purpose registers can be divided")
+	}
+	if bits == 8 {
+		// The quotient is in al and the remainder in ah
+		d = "ah"
+	}
+	if remainder {
+		op = "%"
+	}
+	if signed {
+		op += "s"
+		instr = "idiv"
+	}
+	result := a
+	if remainder {
+		result = d
+	}
+	signedness := "unsigned"
+	if signed {
+		signedness = "signed"
+	}
+	expression := dest + " " + op + "= " + divisor.Value
+	if dividend.Value != dest {
+		expression = dest + " = " + dividend.Value + " " + op + " " + divisor.Value
+	}
+	if dest == "ah" {
+		// The quotient of an 8-bit division is placed in al, which would be lost
+		fatal("Error: Can not divide into ah, since al is changed by the division:", expression)
+	}
+	asmcode += "\n\t;--- " + signedness + " division: " + expression + " ---\n"
+
+	// Find the operand for the div/idiv instruction and if a scratch register is needed for it
+	operand, scratch := divisor.Value, ""
+	switch divisor.T {
+	case MEMEXP:
+		operand = sizeQualifier(bits) + " " + divisor.Value
+	case REGISTER:
+		divisorBits, divisorFamily := registerBits(divisor.Value), regFamily(divisor.Value)
+		if divisorBits == 0 || divisorBits > bits {
+			fatal("Error: Can not divide the", bits, "bit register", dest, "by", divisor.Value)
+		}
+		if divisorBits != bits || divisorFamily == "ax" || divisorFamily == "dx" {
+			scratch = "?"
+		}
+	default:
+		scratch = "?"
+	}
+	if scratch != "" {
+		// Use a register that is neither the result nor the dividend
+		candidates := []string{"cx", "bx", "si", "di"}
+		if bits == 8 && config.PlatformBits != 64 {
+			candidates = candidates[:2]
+		}
+		for _, candidate := range candidates {
+			if candidate != family && (dividend.T != REGISTER || candidate != regFamily(dividend.Value)) {
+				scratch = candidate
+				break
+			}
+		}
+		if scratch == "?" {
+			fatal("Error: No register is available for the divisor when dividing:", expression)
+		}
+		operand = sizedRegister(scratch, bits)
+	}
+
+	// Save the registers that are changed but are not the result
+	var saved []string
+	save := func(reg string) {
+		asmcode += "\tpush " + reg + "\t\t\t; save " + reg + "\n"
+		saved = append(saved, reg)
+	}
+	if family != "ax" {
+		save(config.nativeRegister("ax"))
+		if bits > 8 && family != "dx" {
+			save(config.nativeRegister("dx"))
+		}
+	}
+	if scratch != "" {
+		save(config.nativeRegister(scratch))
+	}
+
+	// Place the divisor in the scratch register, before a and d are changed
+	if scratch != "" {
+		if divisor.T == REGISTER {
+			asmcode += extendedAssignment(operand, divisor.Value, signed) + "\n"
+		} else {
+			asmcode += "\tmov " + operand + ", " + divisor.Value + "\t\t; divisor, " + operand + " = " + divisor.Value + "\n"
+		}
+	}
+
+	// Place the dividend in the a register and extend it
+	switch {
+	case dividend.Value == a:
+	case dividend.T == REGISTER && registerBits(dividend.Value) > bits:
+		log.Println("Warning: Using", dividend.Value, "as a", bits, "bit register when dividing.")
+		asmcode += "\tmov " + a + ", " + sizedRegister(regFamily(dividend.Value), bits) + "\t\t; dividend, number to be divided\n"
+	case dividend.T == REGISTER && registerBits(dividend.Value) < bits:
+		asmcode += extendedAssignment(a, dividend.Value, signed) + "\n"
+	case dividend.T == MEMEXP:
+		asmcode += "\tmov " + a + ", " + sizeQualifier(bits) + " " + dividend.Value + "\t\t; dividend, number to be divided\n"
+	default:
+		asmcode += "\tmov " + a + ", " + dividend.Value + "\t\t; dividend, number to be divided\n"
+	}
+	switch {
+	case signed && bits == 8:
+		asmcode += "\tcbw\t\t\t; sign extend al into ax\n"
+	case signed && bits == 16:
+		asmcode += "\tcwd\t\t\t; sign extend ax into dx:ax\n"
+	case signed && bits == 32:
+		asmcode += "\tcdq\t\t\t; sign extend eax into edx:eax\n"
+	case signed && bits == 64:
+		asmcode += "\tcqo\t\t\t; sign extend rax into rdx:rax\n"
+	default:
+		asmcode += "\txor " + d + ", " + d + "\t\t; " + d + " = 0\n"
+	}
+	asmcode += "\t" + instr + " " + operand + "\t\t\t; " + a + " = quotient, " + d + " = remainder\n"
+	if dest != result {
+		asmcode += "\tmov " + dest + ", " + result + "\t\t; " + dest + " = " + result + "\n"
+	}
+
+	// Restore the saved registers, in reverse order
+	for i := len(saved) - 1; i >= 0; i-- {
+		asmcode += "\tpop " + saved[i] + "\t\t\t; restore " + saved[i] + "\n"
+	}
+	return asmcode
+}
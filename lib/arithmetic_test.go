@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterpretArithmetic(t *testing.T) {
+	defer quiet()()
+	testExitCodes(t, "", []exitCodeTest{
+		{"unsigned division", "rax = 100\nrax /= 7\nrbx = rax\nexit(rbx)", 14},
+		{"unsigned modulo", "rbx = 100\nrbx %= 7\nexit(rbx)", 2},
+		{"unsigned division by a register", "rcx = 200\nrsi = 9\nrbx = rcx / rsi\nexit(rbx)", 22},
+		{"unsigned modulo by a register", "rcx = 200\nrsi = 9\nrbx = rcx % rsi\nexit(rbx)", 2},
+		{"remainder in d", "rax = 100\nrax /= 7\nexit(rdx)", 2},
+		{"signed division", "rax = -100\nrax /s= 7\nrax += 20\nrbx = rax\nexit(rbx)", 6},
+		{"signed modulo", "rcx = -100\nrcx %s= 7\nrcx += 10\nexit(rcx)", 8},
+		{"signed division by a register", "rcx = -100\nrsi = -7\nrbx = rcx /s rsi\nexit(rbx)", 14},
+		{"signed modulo by a register", "rcx = 100\nrsi = -7\nrbx = rcx %s rsi\nexit(rbx)", 2},
+		{"signed division by a smaller register", "rcx = -100\nbl = -7\nrcx /s= bl\nexit(rcx)", 14},
+		{"signed 8-bit division", "al = -100\nbl = 7\nal /s= bl\nal += 20\nrbx =s al\nexit(rbx)", 6},
+		{"registers are saved", "rbx = 5\nrdx = 3\nrcx = 100\nrcx /= 7\nrcx += rbx\nrcx += rdx\nexit(rcx)", 22},
+		{"signed multiplication", "rcx = -6\nrcx *s= -7\nexit(rcx)", 42},
+		{"signed multiplication by a power of two", "rcx = -3\nrcx *s= 4\nrcx += 20\nexit(rcx)", 8},
+		{"signed multiplication by a smaller register", "rcx = -6\nbl = -7\nrcx *s= bl\nexit(rcx)", 42},
+	})
+}
+
+func TestDivideAh(t *testing.T) {
+	defer quiet()()
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := "fun main\n    ax = 0x1234\n    bl = 3\n    ah /= bl\nend\n"
+	_, _, _, _, err = config.Compile(source, "ah.bts", nil, false, NewProgramState())
+	if (err == nil) || !strings.Contains(err.Error(), "Can not divide into ah") {
+		t.Errorf("expected an error when dividing ah, got %v", err)
+	}
+	// Dividing al is fine, and places the remainder in ah
+	source = strings.Replace(source, "ah /= bl", "al /= bl", 1)
+	if _, _, _, _, err = config.Compile(source, "al.bts", nil, false, NewProgramState()); err != nil {
+		t.Error(err)
+	}
+}
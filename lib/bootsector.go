@@ -0,0 +1,27 @@
+package lib
+
+// bootSectorStart is placed first in a boot sector. The BIOS loads the boot sector to 0x7C00 and jumps there
+// in 16-bit real mode, with the number of the boot drive in dl, but the segment registers may be anything.
+const bootSectorStart = `
+;--- boot sector ---
+	jmp 0:_boot_sector		; some BIOSes jump to 0x07C0:0000 instead of 0x0000:0x7C00, set cs to 0
+_boot_sector:
+	cli				; no interrupts while the segments and the stack are set up
+	xor ax, ax
+	mov ds, ax			; the constants are addressed from segment 0, like the code
+	mov es, ax
+	mov ss, ax
+	mov sp, 0x7C00			; the stack grows down from below the boot sector
+	sti
+	cld				; string instructions count upwards
+
+`
+
+// bootSectorEnd is placed last in a boot sector. The .bss section, if any, ends up after the 512 bytes.
+const bootSectorEnd = `;--- boot sector signature ---
+	times 510-($-$$) db 0		; pad to 510 bytes, the assembler fails if the boot sector is too large
+	dw 0xAA55			; the BIOS only boots a sector that ends with the bytes 0x55, 0xAA
+`
+
+// bootSectorHalt stops the CPU, since a boot sector has no DOS to exit to and nothing to return to
+const bootSectorHalt = "\tcli\t\t\t\t; clear interrupts\n\thlt\t\t\t\t; stop the CPU\n\tjmp $-1\t\t\t\t; halt again after a non-maskable interrupt\n"
@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CompileError is an error in the source code. The compilation stops at the first one.
+type CompileError struct {
+	Message  string
+	Filename string // the main program or the module with the error
+	Line     int    // the source line of the statement with the error, counting from 1, or 0 if not known
+}
+
+func (e *CompileError) Error() string {
+	return e.Message
+}
+
+// fatal stops the compilation with an error in the source code, and takes the same arguments as log.Println.
+// Compile recovers and returns the error.
+func fatal(v ...interface{}) {
+	panic(&CompileError{Message: strings.TrimPrefix(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), "Error: ")})
+}
+
+// fatalf is like fatal, but takes the same arguments as log.Printf
+func fatalf(format string, v ...interface{}) {
+	fatal(strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+}
+
+// inFile sets the filename of a *CompileError that passes by, if it is not already set. Must be deferred.
+func inFile(filename string) {
+	if r := recover(); r != nil {
+		if compileError, ok := r.(*CompileError); ok && (compileError.Filename == "") {
+			compileError.Filename = filename
+		}
+		panic(r)
+	}
+}
+
+// recoverCompileError returns a *CompileError from fatal as an error, in the given error variable,
+// with the given filename if it is not already set. Must be deferred. Any other panic is passed on.
+func recoverCompileError(err *error, filename string) {
+	if r := recover(); r != nil {
+		compileError, ok := r.(*CompileError)
+		if !ok {
+			panic(r)
+		}
+		if compileError.Filename == "" {
+			compileError.Filename = filename
+		}
+		*err = compileError
+	}
+}
+
+// Compile compiles the Battlestar source code in the given file to assembly code, and returns the assembly code,
+// the inline C code, if any, the code of the other inline languages, by the name of the language, and the
+// compilation and linking flags for the used C libraries, if any. searchPath is where modules are looked for,
+// and component is true if the program has no starting point of its own.
+// Errors in the source code are returned as a *CompileError.
+func (config *TargetConfig) Compile(source, filename string, searchPath []string, component bool, ps *ProgramState) (asmcode, ccode string, inline map[string]string, flags string, err error) {
+	defer recoverCompileError(&err, filename)
+	return config.compile(source, filename, searchPath, component, ps)
+}
+
+// compile is like Compile, but errors in the source code cause a panic, by calling fatal
+func (config *TargetConfig) compile(source, filename string, searchPath []string, component bool, ps *ProgramState) (string, string, map[string]string, string, error) {
+	asmdata, flagsdata := "", ""
+
+	// If "bootable" is the first token
+	bootableFirstToken := false
+	if temptokens := config.Tokenize(source, " "); (len(temptokens) > 2) && (temptokens[0].T == KEYWORD) && (temptokens[0].Value == "bootable") && (temptokens[1].T == SEP) {
+		bootableFirstToken = true
+	}
+	if config.BootSector {
+		if config.PlatformBits != 16 {
+			return "", "", nil, "", errors.New("A boot sector must be 16-bit")
+		}
+		if bootableFirstToken {
+			return "", "", nil, "", errors.New("A boot sector can not also be a bootable kernel")
+		}
+	}
+	asmdata += "bits " + strconv.Itoa(config.PlatformBits) + "\n"
+
+	// Load the modules that are pulled in with "use"
+	moduleLoader := NewModuleLoader(config, searchPath)
+	btsCode, err := moduleLoader.Load(source, filename)
+	if err != nil {
+		return "", "", nil, "", err
+	}
+
+	// The definitions in the modules are needed before the main program is compiled,
+	// but the code is placed after the main program. Only the lines of the main program
+	// are marked, since the modules have lines of their own.
+	lineMarkers := config.LineMarkers
+	config.LineMarkers = false
+	constants, moduleAsmcode := "", ""
+	for _, module := range moduleLoader.Modules() {
+		log.Println("Using module", module.Name, "from", module.Filename)
+		func() {
+			defer inFile(module.Filename)
+			config.debugFilename = module.Filename
+			moduleConstants, asmcode := config.TokensToAssembly(config.Tokenize(module.Code, " "), true, false, ps)
+			if moduleConstants != "" {
+				constants += moduleConstants + "\n"
+			}
+			if strings.TrimSpace(asmcode) != "" {
+				moduleAsmcode += "\nsection .text\n;--- module " + module.Name + " ---\n" + asmcode
+			}
+		}()
+	}
+	config.LineMarkers = lineMarkers
+
+	btsCode = config.AddExternMainIfMissing(btsCode)
+	tokens := config.AddExitTokenIfMissing(config.Tokenize(btsCode, " "))
+	if libraries := moduleLoader.Libraries(); len(libraries) > 0 {
+		// Functions from C libraries can be called without declaring them with "extern"
+		tokens = config.AddMissingExterns(tokens, moduleLoader.Defined)
+		flagsdata = LibraryFlags(libraries)
+	}
+	// The C functions in the inline C, and the functions that the other inline languages export,
+	// can be called without declaring them with "extern"
+	cFunctions := CFunctions(strings.Split(source, "\n"))
+	for _, f := range cFunctions {
+		ps.cFunctions[f.Name] = f
+	}
+	for _, name := range inlineExports(strings.Split(source, "\n")) {
+		cFunctions = append(cFunctions, CFunction{Name: name, Defined: true})
+	}
+	tokens = config.AddInlineCExterns(tokens, cFunctions, moduleLoader.Defined)
+	log.Println("--- Done tokenizing ---")
+	config.debugFilename = filename
+	mainConstants, asmcode := config.TokensToAssembly(tokens, true, false, ps)
+	constants = strings.TrimSpace(constants + mainConstants)
+	if (constants != "") && !config.BootSector {
+		asmdata += "section .data\n"
+		asmdata += constants + "\n"
+	}
+	if config.BootSector {
+		asmdata += "org 0x7C00\n"
+	} else if config.PlatformBits == 16 {
+		asmdata += "org 0x100\n"
+	}
+	if !bootableFirstToken {
+		asmdata += "\nsection .text\n"
+	}
+	if config.BootSector {
+		asmdata += bootSectorStart
+	}
+	if config.PlatformBits == 16 {
+		// If there are defined functions, jump over the definitions and start at
+		// the main/_start function. If there is a main function, jump to the
+		// linker start function. If not, just start at the top.
+		// TODO: This is a quick fix. Don't depend on the comment, find a better way.
+		if strings.Count(asmcode, "; name of the function") > 1 && strings.Contains(asmcode, "\nmain:") {
+			asmdata += "jmp " + config.LinkerStartFunction + "\n"
+		}
+	}
+	if asmcode != "" {
+		if component {
+			asmdata += asmcode + "\n"
+		} else {
+			asmdata += config.AddStartingPointIfMissing(asmcode, ps) + "\n"
+		}
+		asmdata += moduleAsmcode
+		if bootableFirstToken {
+			reg := "esp"
+			if config.PlatformBits == 64 {
+				reg = "rsp"
+			}
+			asmdata = strings.Replace(asmdata, "; starting point of the program\n", "; starting point of the program\n\tmov "+reg+", stack_top\t; set the "+reg+" register to the top of the stack (special case for bootable kernels)\n", 1)
+		}
+	}
+	if config.BootSector {
+		// Everything must be within the 512 bytes, so the constants are placed after the code, in the same section
+		asmdata += "\nsection .text\n"
+		if constants != "" {
+			asmdata += ";--- constants ---\n" + constants + "\n\n"
+		}
+		asmdata += bootSectorEnd
+	}
+	ccode := ExtractInlineC(strings.TrimSpace(source), true)
+	if config.DebugInfo {
+		// The lines that are trimmed away at the start are counted, to get the right line numbers
+		trimmed := len(source) - len(strings.TrimLeftFunc(source, unicode.IsSpace))
+		ccode = extractInlineC(strings.TrimSpace(source), filename, strings.Count(source[:trimmed], "\n")+1)
+	}
+	if prototypes := FunPrototypes(strings.Split(source, "\n"), moduleLoader.Defined); (ccode != "") && (prototypes != "") {
+		ccode = "// The Battlestar functions that are called from the C code\n" + prototypes + ccode
+	}
+	if config.PlatformBits == 16 {
+		if err := inline16(source, filename); err != nil {
+			return "", "", nil, "", err
+		}
+	}
+	inline := make(map[string]string)
+	for _, language := range inlineLanguages {
+		if code := ExtractInline(source, language); code != "" {
+			inline[language.Name] = code
+		}
+	}
+	return asmdata, ccode, inline, flagsdata, nil
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugInfo(t *testing.T) {
+	defer quiet()()
+	source := "\n" + `extern c_hi
+fun main
+    rax = 42
+end
+
+inline_c
+    void c_hi() {
+    }
+end
+`
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DebugInfo = true
+	asmcode, ccode, _, _, err := config.Compile(source, "debug.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"%line 2+0 debug.bts\nextern c_hi", "%line 3+0 debug.bts\n;--- function main ---", "%line 4+0 debug.bts\n\tmov rax, 42"} {
+		if !strings.Contains(asmcode, expected) {
+			t.Errorf("expected %q in the assembly:\n%s", expected, asmcode)
+		}
+	}
+	if !strings.HasPrefix(ccode, "#line 8 \"debug.bts\"\nvoid c_hi() {\n") {
+		t.Errorf("expected a #line directive for line 8 first in the C code:\n%s", ccode)
+	}
+}
+
+func TestBootSector(t *testing.T) {
+	defer quiet()()
+	source := `const msg = "hi"
+
+fun main
+    si = msg
+    exit
+end
+`
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.BootSector = true
+	asmcode, _, _, _, err := config.Compile(source, "boot.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"org 0x7C00\n", "\tmov sp, 0x7C00", "\thlt", "msg:\tdb \"hi\"", "\ttimes 510-($-$$) db 0", "\tdw 0xAA55"} {
+		if !strings.Contains(asmcode, expected) {
+			t.Errorf("expected %q in the assembly:\n%s", expected, asmcode)
+		}
+	}
+	if strings.Contains(asmcode, "section .data") || strings.Contains(asmcode, "int 0x21") {
+		t.Errorf("expected the constants in the boot sector, and no DOS interrupts:\n%s", asmcode)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(asmcode), "dw 0xAA55\t\t\t; the BIOS only boots a sector that ends with the bytes 0x55, 0xAA") {
+		t.Errorf("expected the signature last in the assembly:\n%s", asmcode)
+	}
+	config, _ = NewTargetConfig(64, false, false)
+	config.BootSector = true
+	if _, _, _, _, err := config.Compile(source, "boot.bts", nil, false, NewProgramState()); err == nil {
+		t.Error("expected an error for a 64-bit boot sector")
+	}
+}
@@ -0,0 +1,406 @@
+package lib
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The address where DOS places .com programs
+const comOrigin = 0x100
+
+// The conditional jumps that can be written as "break" or "continue" with a flag condition
+var flagConditions = map[string]string{
+	"jz": "zero", "jnz": "not zero", "jc": "carry", "jnc": "not carry",
+	"js": "sign", "jns": "not sign", "jo": "overflow", "jno": "not overflow",
+}
+
+// The Battlestar operators for the arithmetic and logic instructions with two operands
+var decompileOperators = map[string]string{
+	"add": "+=", "sub": "-=", "xor": "^=", "and": "&=", "or": "|=", "adc": "+c=", "sbb": "-c=",
+	"shl": "<<", "shr": ">>", "sar": ">>s", "rol": "<<<", "ror": ">>>",
+}
+
+// decompiledLoop is a loop that was found in the decoded instructions. first is the index of
+// the top of the loop and last is the index of the jump back to the top. A raw loop ends with
+// "dec cx" and "jnz" and is written as "rawloop", the others are endless loops.
+type decompiledLoop struct {
+	first, last int
+	raw         bool
+}
+
+// decompiler keeps track of the decoded instructions while they are turned into Battlestar code
+type decompiler struct {
+	config       *TargetConfig
+	instructions []instruction16
+	index        map[int]int // from addresses to the instructions that start there
+	end          int         // the address right after the code
+	loops        []decompiledLoop
+	labels       map[int]bool // the addresses that are jumped to from inline assembly
+	lines        []string
+	depth        int
+}
+
+// Decompile turns a DOS .com file into Battlestar source code. The instructions that have a
+// Battlestar statement become statements, jumps back to the top of a loop become "loop" or
+// "rawloop" blocks with "break" and "continue" where the control flow allows, and the rest
+// becomes inline assembly, with labels for the jumps and calls.
+// The assembler may pick shorter encodings than the original for some of the instructions.
+func Decompile(code []byte) (string, error) {
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		return "", err
+	}
+	d := &decompiler{config: config, instructions: decode16(code, comOrigin), index: make(map[int]int), end: comOrigin + len(code), labels: make(map[int]bool)}
+	for i, inst := range d.instructions {
+		d.index[inst.address] = i
+	}
+	d.findLoops()
+
+	// Find the statements first, since they decide which labels are needed
+	statements := make([][]string, len(d.instructions))
+	for i := range d.instructions {
+		statements[i] = d.statements(i)
+	}
+
+	// The final "ret" can be left to "end", unless an endless loop has been written as "loop",
+	// since then the function is expected to never return
+	endless := false
+	for _, l := range d.loops {
+		endless = endless || !l.raw
+	}
+	last := len(d.instructions) - 1
+	useEnd := (last >= 0) && (d.instructions[last].String() == "ret") && (d.loopAt(last) == -1) && !endless && !d.labels[d.end]
+
+	d.lines = append(d.lines, "fun main")
+	d.depth = 1
+	for i, inst := range d.instructions {
+		for _, l := range d.loops {
+			if l.first == i {
+				if l.raw {
+					d.emit("rawloop")
+				} else {
+					d.emit("loop")
+				}
+				d.depth++
+			}
+		}
+		if d.labels[inst.address] {
+			d.emit("asm 16 " + d.label(inst.address) + ":")
+		}
+		for _, l := range d.loops {
+			if l.last == i {
+				d.depth--
+				d.emit("end")
+			}
+		}
+		if useEnd && (i == last) {
+			break
+		}
+		for _, s := range statements[i] {
+			d.emit(s)
+		}
+	}
+	if d.labels[d.end] {
+		d.emit("asm 16 " + d.label(d.end) + ":")
+	}
+	if useEnd {
+		d.lines = append(d.lines, "end")
+	} else {
+		d.lines = append(d.lines, "noret")
+	}
+	d.lines = append(d.lines, "", "// vim: syntax=c ts=4 sw=4 et:")
+	return strings.Join(d.lines, "\n") + "\n", nil
+}
+
+// emit adds a line of Battlestar code at the current indentation
+func (d *decompiler) emit(line string) {
+	d.lines = append(d.lines, strings.Repeat("    ", d.depth)+line)
+}
+
+// label returns the name of the label for the given address
+func (d *decompiler) label(address int) string {
+	return "l" + strconv.FormatInt(int64(address), 16)
+}
+
+// findLoops finds the jumps back to the top of a loop. Nested loops are not supported,
+// so only the innermost loops that do not overlap are kept.
+func (d *decompiler) findLoops() {
+	// The addresses that are jumped to
+	targets := make(map[int]bool)
+	for _, inst := range d.instructions {
+		if inst.target >= 0 {
+			targets[inst.target] = true
+		}
+	}
+	var candidates []decompiledLoop
+	for j, inst := range d.instructions {
+		if (inst.target < 0) || (len(inst.prefixes) > 0) {
+			continue
+		}
+		i, ok := d.index[inst.target]
+		if !ok || (i > j) {
+			continue
+		}
+		switch {
+		case inst.mnemonic == "jmp":
+			candidates = append(candidates, decompiledLoop{i, j, false})
+		case (inst.mnemonic == "jnz") && (i < j) && (d.instructions[j-1].String() == "dec cx") && !targets[inst.address]:
+			// The "dec cx" and "jnz" are both written by "end", so nothing can jump to the "jnz"
+			candidates = append(candidates, decompiledLoop{i, j, true})
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return (candidates[a].last - candidates[a].first) < (candidates[b].last - candidates[b].first)
+	})
+	for _, c := range candidates {
+		overlaps := false
+		for _, l := range d.loops {
+			if (c.first <= l.last) && (l.first <= c.last) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			d.loops = append(d.loops, c)
+		}
+	}
+	sort.Slice(d.loops, func(a, b int) bool {
+		return d.loops[a].first < d.loops[b].first
+	})
+}
+
+// loopAt returns the index of the loop that the instruction is part of, or -1
+func (d *decompiler) loopAt(i int) int {
+	for n, l := range d.loops {
+		if (l.first <= i) && (i <= l.last) {
+			return n
+		}
+	}
+	return -1
+}
+
+// after returns the address right after the instruction with the given index
+func (d *decompiler) after(i int) int {
+	if i+1 < len(d.instructions) {
+		return d.instructions[i+1].address
+	}
+	return d.end
+}
+
+// statements returns the Battlestar code for the instruction with the given index,
+// and marks the labels that the code needs
+func (d *decompiler) statements(i int) []string {
+	inst := &d.instructions[i]
+	if n := d.loopAt(i); n != -1 {
+		l := d.loops[n]
+		if (i == l.last) || (l.raw && (i == l.last-1)) {
+			// Written by "end"
+			return nil
+		}
+		if (inst.target >= 0) && (len(inst.prefixes) == 0) {
+			condition, isFlag := flagConditions[inst.mnemonic]
+			switch {
+			case (inst.target == d.after(l.last)) && (inst.mnemonic == "jmp"):
+				return []string{"break"}
+			case (inst.target == d.after(l.last)) && isFlag:
+				return []string{"break " + condition}
+			case !l.raw && (inst.target == d.instructions[l.first].address) && (inst.mnemonic == "jmp"):
+				return []string{"continue"}
+			case !l.raw && (inst.target == d.instructions[l.first].address) && isFlag:
+				return []string{"continue " + condition}
+			}
+		}
+	}
+	if s := statement16(inst); s != "" {
+		return []string{s}
+	}
+	if inst.target >= 0 {
+		if _, ok := d.index[inst.target]; ok || (inst.target == d.end) {
+			d.labels[inst.target] = true
+		}
+	}
+	return d.inlineAssembly(inst)
+}
+
+// inlineAssembly returns "asm 16" lines for the instruction. If Battlestar can not give back the
+// same instruction, the prefixes are placed on lines of their own, and if that does not help
+// either, the instruction is written as bytes.
+func (d *decompiler) inlineAssembly(inst *instruction16) []string {
+	comment := ""
+	if (inst.target >= 0) && !d.labels[inst.target] {
+		comment = "\t// outside of the program or within an instruction"
+	}
+	text := inst.text(func(address int) string {
+		if d.labels[address] {
+			return d.label(address)
+		}
+		return hexNumber(address)
+	})
+	if len(inst.prefixes) > 0 {
+		if line := strings.Join(inst.prefixes, " ") + " " + text; d.assembles(line) {
+			return []string{"asm 16 " + line + comment}
+		}
+	}
+	if d.assembles(text) {
+		var lines []string
+		for _, prefix := range inst.prefixes {
+			if !d.assembles(prefix) {
+				lines = nil
+				break
+			}
+			lines = append(lines, "asm 16 "+prefix)
+		}
+		if len(lines) == len(inst.prefixes) {
+			return append(lines, "asm 16 "+text+comment)
+		}
+	}
+	var lines []string
+	for i := 0; i < len(inst.bytes); i += 2 {
+		var values []string
+		j := i + 2
+		if j > len(inst.bytes) {
+			j = len(inst.bytes)
+		}
+		for _, b := range inst.bytes[i:j] {
+			values = append(values, hexNumber(int(b)))
+		}
+		line := "db " + strings.Join(values, ", ")
+		if !d.assembles(line) {
+			// One byte at a time
+			line = "db " + values[0]
+			if len(values) > 1 {
+				lines = append(lines, "asm 16 "+line)
+				line = "db " + values[1]
+			}
+		}
+		lines = append(lines, "asm 16 "+line)
+	}
+	lines[0] += "\t// " + inst.String()
+	return lines
+}
+
+// assembles checks if "asm 16" with the given line of assembly gives back the same line
+func (d *decompiler) assembles(line string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	tokens := d.config.Tokenize("asm 16 "+line, " ")
+	for i, t := range tokens {
+		if t.T == SEP {
+			tokens = tokens[:i]
+			break
+		}
+	}
+	if len(tokens) < 3 {
+		return false
+	}
+	asmcode := emitInlineAssembly(d.config, NewProgramState(), Statement(tokens))
+	if pos := strings.Index(asmcode, ";"); pos != -1 {
+		asmcode = asmcode[:pos]
+	}
+	return strings.Join(strings.Fields(asmcode), " ") == line
+}
+
+// statement16 returns the Battlestar statement for the instruction, or an empty string if
+// there is no statement that gives back exactly the same instruction
+func statement16(inst *instruction16) string {
+	if len(inst.prefixes) > 0 {
+		return ""
+	}
+	args := inst.args
+	switch inst.mnemonic {
+	case "int":
+		return "int(" + args[0].text + ")"
+	case "stosb":
+		return "write"
+	}
+	switch len(args) {
+	case 1:
+		a := args[0]
+		switch {
+		case (inst.mnemonic == "inc") && (generalRegister(a) || (a.text == "sp")):
+			return a.text + "++"
+		case (inst.mnemonic == "dec") && (generalRegister(a) || (a.text == "sp")):
+			return a.text + "--"
+		case (inst.mnemonic == "push") && ((a.kind == argImmediate) && (a.size == 16) || wordRegister(a)):
+			return a.text + " -> stack"
+		case (inst.mnemonic == "pop") && wordRegister(a):
+			return "stack -> " + a.text
+		}
+	case 2:
+		a, b := args[0], args[1]
+		switch inst.mnemonic {
+		case "mov":
+			switch {
+			case generalRegister(a) && generalRegister(b) && (a.size == b.size):
+				return a.text + " = " + b.text
+			case (a.kind == argRegister) && has(segmentRegisters, a.text) && (b.size == 16) && generalRegister(b):
+				return a.text + " = " + b.text
+			case (b.kind == argRegister) && has(segmentRegisters, b.text) && (a.size == 16) && generalRegister(a):
+				return a.text + " = " + b.text
+			case generalRegister(a) && (b.kind == argImmediate) && (b.value != 0):
+				return a.text + " = " + b.text
+			case simpleMemory(a) && ((b.kind == argImmediate) || (b.size == 8) || has([]string{"ax", "bx", "cx", "dx"}, b.text)):
+				// memword turns sp, bp, si and di into the 32-bit registers
+				if a.size == 8 {
+					return "membyte " + a.text + " = " + b.text
+				}
+				return "memword " + a.text + " = " + b.text
+			}
+		case "xchg":
+			if generalRegister(a) && generalRegister(b) {
+				return a.text + " <-> " + b.text
+			}
+		case "out":
+			if a.isRegister("dx") && ((b.text == "al") || (b.text == "ax")) {
+				return "dx ==> " + b.text
+			}
+		case "in":
+			if b.isRegister("dx") && ((a.text == "al") || (a.text == "ax")) {
+				return "dx <== " + a.text
+			}
+		default:
+			op, ok := decompileOperators[inst.mnemonic]
+			if !ok || !generalRegister(a) {
+				return ""
+			}
+			shift := strings.HasPrefix(op, "<<") || strings.HasPrefix(op, ">>")
+			switch {
+			case shift && ((b.kind == argImmediate) || b.isRegister("cl")):
+				return a.text + " " + op + " " + b.text
+			case shift:
+				return ""
+			case (b.kind == argImmediate) && (b.value == 1) && ((op == "+=") || (op == "-=")):
+				// Battlestar uses inc and dec for these
+				return ""
+			case (b.kind == argImmediate) || (generalRegister(b) && (a.size == b.size)):
+				return a.text + " " + op + " " + b.text
+			case (b.kind == argMemory) && (b.size == a.size) && !strings.Contains(b.text, ":") && ((op == "+=") || (op == "-=")):
+				return a.text + " " + op + " [" + b.text + "]"
+			}
+		}
+	}
+	return ""
+}
+
+// generalRegister checks if the argument is an 8-bit or 16-bit general purpose register
+func generalRegister(a arg16) bool {
+	return (a.kind == argRegister) && ((a.size == 8) || (a.size == 16))
+}
+
+// wordRegister checks if the argument is a 16-bit general purpose register or a segment register
+func wordRegister(a arg16) bool {
+	return (a.kind == argRegister) && ((a.size == 16) || has(segmentRegisters, a.text))
+}
+
+// simpleMemory checks if the argument is a byte or a word in memory, at an address that is
+// only a register or a value, without a segment
+func simpleMemory(a arg16) bool {
+	if (a.kind != argMemory) || ((a.size != 8) && (a.size != 16)) {
+		return false
+	}
+	return has(registers16, a.text) || strings.HasPrefix(a.text, "0x") || isValue(a.text)
+}
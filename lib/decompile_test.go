@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// normalizeInstruction makes an instruction from the decoder comparable with one from the compiler,
+// by removing the size keywords and replacing the label or address of jumps and calls with "T"
+func normalizeInstruction(s string) string {
+	s = strings.ToLower(s)
+	for _, keyword := range []string{"byte ", "word ", "dword "} {
+		s = strings.Replace(s, keyword, "", -1)
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 2 && (strings.HasPrefix(fields[0], "j") || fields[0] == "call" || fields[0] == "loop") {
+		target := fields[1]
+		if strings.HasPrefix(target, "l") || strings.HasPrefix(target, "e_") || strings.HasPrefix(target, "r_") || strings.HasPrefix(target, "0x") {
+			fields[1] = "T"
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func TestDecompile(t *testing.T) {
+	defer quiet()()
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"../spongy/original.com", "../workinprogress/puls/original.com", "../workinprogress/color_dream/original.com"} {
+		code, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := Decompile(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asmcode, _, _, _, err := config.Compile(source, filename, nil, false, NewProgramState())
+		if err != nil {
+			t.Fatalf("%s: the decompiled code does not compile: %v\n%s", filename, err, source)
+		}
+
+		// The compiled instructions should be the same as the decoded ones
+		var expected, compiled []string
+		for _, inst := range decode16(code, comOrigin) {
+			expected = append(expected, normalizeInstruction(inst.text(hexNumber)))
+		}
+		for _, line := range strings.Split(asmcode, "\n") {
+			if pos := strings.Index(line, ";"); pos != -1 {
+				line = line[:pos]
+			}
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasSuffix(line, ":") || strings.HasPrefix(line, "bits ") || strings.HasPrefix(line, "org ") || strings.HasPrefix(line, "section ") {
+				continue
+			}
+			compiled = append(compiled, normalizeInstruction(line))
+		}
+		if len(compiled) != len(expected) {
+			t.Fatalf("%s: expected %d instructions, got %d:\n%s", filename, len(expected), len(compiled), asmcode)
+		}
+		for i := range expected {
+			if compiled[i] != expected[i] {
+				t.Errorf("%s: instruction %d is %q, expected %q", filename, i, compiled[i], expected[i])
+			}
+		}
+	}
+}
+
+func TestDecompileLoops(t *testing.T) {
+	defer quiet()()
+	code, err := ioutil.ReadFile("../spongy/original.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := Decompile(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"\n    loop\n", "\n        continue not zero\n", "\n    end\nnoret\n"} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("expected %q in the decompiled code:\n%s", expected, source)
+		}
+	}
+}
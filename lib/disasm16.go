@@ -0,0 +1,869 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The kinds of arguments of a decoded 16-bit instruction
+const (
+	argRegister = iota
+	argImmediate
+	argMemory
+	argTarget // the address that a jump, call or loop goes to
+)
+
+var (
+	registers8       = []string{"al", "cl", "dl", "bl", "ah", "ch", "dh", "bh"}
+	registers16      = []string{"ax", "cx", "dx", "bx", "sp", "bp", "si", "di"}
+	registers32      = []string{"eax", "ecx", "edx", "ebx", "esp", "ebp", "esi", "edi"}
+	segmentRegisters = []string{"es", "cs", "ss", "ds", "fs", "gs"}
+	addresses16      = []string{"bx+si", "bx+di", "bp+si", "bp+di", "si", "di", "bp", "bx"}
+	aluMnemonics     = []string{"add", "or", "adc", "sbb", "and", "sub", "xor", "cmp"}
+	shiftMnemonics   = []string{"rol", "ror", "rcl", "rcr", "shl", "shr", "sal", "sar"}
+	jumpConditions   = []string{"o", "no", "c", "nc", "z", "nz", "na", "a", "s", "ns", "pe", "po", "l", "ge", "le", "g"}
+
+	// The x87 instructions, where the first byte is D8 to DF and the ModRM byte refers to memory.
+	// The size of the memory operand is given after the mnemonic.
+	fpuMemory = [8][8]string{
+		{"fadd dword", "fmul dword", "fcom dword", "fcomp dword", "fsub dword", "fsubr dword", "fdiv dword", "fdivr dword"},
+		{"fld dword", "", "fst dword", "fstp dword", "fldenv", "fldcw word", "fnstenv", "fnstcw word"},
+		{"fiadd dword", "fimul dword", "ficom dword", "ficomp dword", "fisub dword", "fisubr dword", "fidiv dword", "fidivr dword"},
+		{"fild dword", "fisttp dword", "fist dword", "fistp dword", "", "fld tword", "", "fstp tword"},
+		{"fadd qword", "fmul qword", "fcom qword", "fcomp qword", "fsub qword", "fsubr qword", "fdiv qword", "fdivr qword"},
+		{"fld qword", "fisttp qword", "fst qword", "fstp qword", "frstor", "", "fnsave", "fnstsw word"},
+		{"fiadd word", "fimul word", "ficom word", "ficomp word", "fisub word", "fisubr word", "fidiv word", "fidivr word"},
+		{"fild word", "fisttp word", "fist word", "fistp word", "fbld tword", "fild qword", "fbstp tword", "fistp qword"},
+	}
+
+	// The x87 instructions with a register operand, by the first byte (D8 to DF) and the reg field of the ModRM byte.
+	// "st0, i" is st0 and st(i), "i, st0" is st(i) and st0 and "i" is only st(i), like in the Intel manuals and NASM.
+	fpuRegister = [8][8]string{
+		{"fadd st0, i", "fmul st0, i", "fcom i", "fcomp i", "fsub st0, i", "fsubr st0, i", "fdiv st0, i", "fdivr st0, i"},
+		{"fld i", "fxch i", "", "", "", "", "", ""},
+		{"fcmovb st0, i", "fcmove st0, i", "fcmovbe st0, i", "fcmovu st0, i", "", "", "", ""},
+		{"fcmovnb st0, i", "fcmovne st0, i", "fcmovnbe st0, i", "fcmovnu st0, i", "", "fucomi st0, i", "fcomi st0, i", ""},
+		{"fadd i, st0", "fmul i, st0", "", "", "fsubr i, st0", "fsub i, st0", "fdivr i, st0", "fdiv i, st0"},
+		{"ffree i", "", "fst i", "fstp i", "fucom i", "fucomp i", "", ""},
+		{"faddp i, st0", "fmulp i, st0", "", "", "fsubrp i, st0", "fsubp i, st0", "fdivrp i, st0", "fdivp i, st0"},
+		{"", "", "", "", "", "fucomip st0, i", "fcomip st0, i", ""},
+	}
+
+	// The x87 instructions without operands, by the first and second byte
+	fpuSingle = map[[2]byte]string{
+		{0xd9, 0xd0}: "fnop", {0xd9, 0xe0}: "fchs", {0xd9, 0xe1}: "fabs", {0xd9, 0xe4}: "ftst", {0xd9, 0xe5}: "fxam",
+		{0xd9, 0xe8}: "fld1", {0xd9, 0xe9}: "fldl2t", {0xd9, 0xea}: "fldl2e", {0xd9, 0xeb}: "fldpi", {0xd9, 0xec}: "fldlg2",
+		{0xd9, 0xed}: "fldln2", {0xd9, 0xee}: "fldz", {0xd9, 0xf0}: "f2xm1", {0xd9, 0xf1}: "fyl2x", {0xd9, 0xf2}: "fptan",
+		{0xd9, 0xf3}: "fpatan", {0xd9, 0xf4}: "fxtract", {0xd9, 0xf5}: "fprem1", {0xd9, 0xf6}: "fdecstp", {0xd9, 0xf7}: "fincstp",
+		{0xd9, 0xf8}: "fprem", {0xd9, 0xf9}: "fyl2xp1", {0xd9, 0xfa}: "fsqrt", {0xd9, 0xfb}: "fsincos", {0xd9, 0xfc}: "frndint",
+		{0xd9, 0xfd}: "fscale", {0xd9, 0xfe}: "fsin", {0xd9, 0xff}: "fcos", {0xda, 0xe9}: "fucompp", {0xdb, 0xe2}: "fnclex",
+		{0xdb, 0xe3}: "fninit", {0xde, 0xd9}: "fcompp", {0xdf, 0xe0}: "fnstsw ax",
+	}
+)
+
+type (
+	// instruction16 is a decoded 16-bit x86 instruction
+	instruction16 struct {
+		address  int
+		bytes    []byte
+		prefixes []string // like "rep", "lock" or a segment override that is not part of a memory operand
+		mnemonic string
+		args     []arg16
+		target   int // the address that a jump, call or loop goes to, or -1
+	}
+
+	// arg16 is an argument of a decoded 16-bit instruction
+	arg16 struct {
+		kind    int
+		text    string // the register, the value, or the address within the brackets
+		size    int    // in bits, or 0 if it is not known or not needed
+		keyword string // for memory operands, like "word" or "far"
+		value   int    // for immediate values and targets
+	}
+
+	// decoder16 decodes 16-bit x86 machine code, one instruction at a time
+	decoder16 struct {
+		code       []byte
+		origin     int // the address of the first byte, like 0x100 for .com files
+		pos        int
+		opsize     int // 16, or 32 with the 0x66 prefix
+		adsize     int // 16, or 32 with the 0x67 prefix
+		seg        string
+		segUsed    bool
+		opsizeUsed bool
+		adsizeUsed bool
+		eof        bool // tried to read past the end of the code
+	}
+)
+
+// hexNumber formats a number the way the decompiled code shows it, like 9 or 0x3c8
+func hexNumber(n int) string {
+	if (n >= 0) && (n < 10) {
+		return strconv.Itoa(n)
+	}
+	if n < 0 {
+		return "-0x" + strconv.FormatInt(int64(-n), 16)
+	}
+	return "0x" + strconv.FormatInt(int64(n), 16)
+}
+
+// memory returns the text of a memory argument, like "word [es:bx+si+0x10]"
+func (a arg16) memory(qualify bool) string {
+	s := "[" + a.text + "]"
+	if qualify && (a.keyword != "") {
+		s = a.keyword + " " + s
+	}
+	return s
+}
+
+// isRegister checks if the argument is the given register
+func (a arg16) isRegister(name string) bool {
+	return (a.kind == argRegister) && (a.text == name)
+}
+
+// text returns the instruction in NASM syntax, where the given function names the addresses of jumps and calls
+func (inst *instruction16) text(label func(int) string) string {
+	if len(inst.args) == 0 {
+		return inst.mnemonic
+	}
+	// Memory operands need a size, unless there is a register of the same size, or the size does not matter
+	qualify := true
+	if !strings.Contains(" rol ror rcl rcr shl shr sal sar ", " "+inst.mnemonic+" ") {
+		for _, a := range inst.args {
+			if (a.kind == argRegister) && (a.size != 0) {
+				for _, m := range inst.args {
+					if (m.kind == argMemory) && (m.size == a.size) {
+						qualify = false
+					}
+				}
+			}
+		}
+	}
+	args := make([]string, len(inst.args))
+	for i, a := range inst.args {
+		switch a.kind {
+		case argMemory:
+			args[i] = a.memory(qualify)
+		case argTarget:
+			args[i] = label(a.value)
+		default:
+			args[i] = a.text
+		}
+	}
+	return inst.mnemonic + " " + strings.Join(args, ", ")
+}
+
+// String returns the instruction in NASM syntax, with the addresses of jumps and calls as numbers
+func (inst *instruction16) String() string {
+	s := inst.text(hexNumber)
+	if len(inst.prefixes) > 0 {
+		s = strings.Join(inst.prefixes, " ") + " " + s
+	}
+	return s
+}
+
+// decode16 decodes 16-bit x86 machine code that is placed at the given address.
+// Bytes that are not the start of a known instruction are returned as "db" instructions.
+func decode16(code []byte, origin int) []instruction16 {
+	var instructions []instruction16
+	for pos := 0; pos < len(code); {
+		d := &decoder16{code: code, origin: origin, pos: pos, opsize: 16, adsize: 16}
+		inst, ok := d.decode()
+		if !ok || d.eof {
+			inst = instruction16{mnemonic: "db", args: []arg16{{kind: argImmediate, text: hexNumber(int(code[pos])), size: 8, value: int(code[pos])}}, target: -1}
+			d.pos = pos + 1
+		}
+		inst.address = origin + pos
+		inst.bytes = code[pos:d.pos]
+		instructions = append(instructions, inst)
+		pos = d.pos
+	}
+	return instructions
+}
+
+// next returns the next byte of the code
+func (d *decoder16) next() int {
+	if d.pos >= len(d.code) {
+		d.eof = true
+		return 0
+	}
+	b := d.code[d.pos]
+	d.pos++
+	return int(b)
+}
+
+// immediate reads a little endian value of the given size, in bits
+func (d *decoder16) immediate(size int) int {
+	v := 0
+	for i := 0; i < size/8; i++ {
+		v |= d.next() << uint(8*i)
+	}
+	return v
+}
+
+// signed reads a byte and sign extends it to the given size
+func (d *decoder16) signed(size int) int {
+	v := d.next()
+	if v >= 0x80 {
+		v -= 0x100
+	}
+	return v & (1<<uint(size) - 1)
+}
+
+// operandSize returns the size of the "v" operands, 16 or 32 bits
+func (d *decoder16) operandSize() int {
+	d.opsizeUsed = true
+	return d.opsize
+}
+
+// register returns a general purpose register of the given size
+func register16(n, size int) arg16 {
+	switch size {
+	case 8:
+		return arg16{kind: argRegister, text: registers8[n], size: 8}
+	case 32:
+		return arg16{kind: argRegister, text: registers32[n], size: 32}
+	}
+	return arg16{kind: argRegister, text: registers16[n], size: 16}
+}
+
+// imm returns an immediate value of the given size
+func imm16(v, size int) arg16 {
+	return arg16{kind: argImmediate, text: hexNumber(v), size: size, value: v}
+}
+
+// sizeKeyword returns the NASM keyword for a memory operand of the given size
+func sizeKeyword(size int) string {
+	switch size {
+	case 8:
+		return "byte"
+	case 16:
+		return "word"
+	case 32:
+		return "dword"
+	case 64:
+		return "qword"
+	case 80:
+		return "tword"
+	}
+	return ""
+}
+
+// modrm reads a ModRM byte and returns the mod, reg and rm fields
+func (d *decoder16) modrm() (int, int, int) {
+	b := d.next()
+	return b >> 6, (b >> 3) & 7, b & 7
+}
+
+// rm returns the register or memory operand of a ModRM byte, reading the displacement
+func (d *decoder16) rm(mod, rm, size int) arg16 {
+	if mod == 3 {
+		return register16(rm, size)
+	}
+	var address string
+	if d.adsize == 32 {
+		address = d.address32(mod, rm)
+	} else {
+		switch {
+		case (mod == 0) && (rm == 6):
+			address = hexNumber(d.immediate(16))
+		case mod == 0:
+			address = addresses16[rm]
+		case mod == 1:
+			address = addresses16[rm] + displacement(d.next(), 8)
+		default:
+			address = addresses16[rm] + displacement(d.immediate(16), 16)
+		}
+	}
+	return d.memoryArg(address, size)
+}
+
+// address32 returns the address of a ModRM byte with 32-bit addressing, reading the SIB byte and the displacement
+func (d *decoder16) address32(mod, rm int) string {
+	d.adsizeUsed = true
+	base := registers32[rm]
+	if rm == 4 {
+		sib := d.next()
+		scale, index, b := sib>>6, (sib>>3)&7, sib&7
+		base = registers32[b]
+		if (b == 5) && (mod == 0) {
+			base = hexNumber(d.immediate(32))
+		}
+		if index != 4 {
+			base += "+" + registers32[index]
+			if scale > 0 {
+				base += "*" + strconv.Itoa(1<<uint(scale))
+			}
+		}
+	} else if (rm == 5) && (mod == 0) {
+		return hexNumber(d.immediate(32))
+	}
+	switch mod {
+	case 1:
+		return base + displacement(d.next(), 8)
+	case 2:
+		return base + displacement(d.immediate(32), 32)
+	}
+	return base
+}
+
+// displacement returns a displacement of the given size as "+0x10" or "-0x6", or "" for 0
+func displacement(v, size int) string {
+	if size == 8 && v >= 0x80 {
+		v -= 0x100
+	}
+	if (size == 32) && (v >= 0x80000000) {
+		v -= 0x100000000
+	}
+	if v == 0 {
+		return ""
+	}
+	if v < 0 {
+		return hexNumber(v)
+	}
+	return "+" + hexNumber(v)
+}
+
+// memoryArg returns a memory argument for the given address, with the segment override, if any
+func (d *decoder16) memoryArg(address string, size int) arg16 {
+	if d.seg != "" {
+		address = d.seg + ":" + address
+		d.segUsed = true
+	}
+	return arg16{kind: argMemory, text: address, size: size, keyword: sizeKeyword(size)}
+}
+
+// jump returns the target of a relative jump with a displacement of the given size
+func (d *decoder16) jump(size int) arg16 {
+	var rel int
+	if size == 8 {
+		rel = d.next()
+		if rel >= 0x80 {
+			rel -= 0x100
+		}
+	} else {
+		rel = d.immediate(size)
+		if rel >= 1<<uint(size-1) {
+			rel -= 1 << uint(size)
+		}
+	}
+	target := (d.origin + d.pos + rel) & 0xffff
+	return arg16{kind: argTarget, text: hexNumber(target), value: target}
+}
+
+// The forms of the operands of the arithmetic instructions and mov, where E is a register or memory,
+// G is a register, b is a byte and v is a word or double word
+const (
+	formEbGb = iota
+	formEvGv
+	formGbEb
+	formGvEv
+	formALIb
+	formAXIv
+)
+
+// operands decodes the operands of the given form
+func (d *decoder16) operands(form int) []arg16 {
+	switch form {
+	case formEbGb, formGbEb:
+		mod, reg, rm := d.modrm()
+		e := d.rm(mod, rm, 8)
+		if form == formGbEb {
+			return []arg16{register16(reg, 8), e}
+		}
+		return []arg16{e, register16(reg, 8)}
+	case formEvGv, formGvEv:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		e := d.rm(mod, rm, size)
+		if form == formGvEv {
+			return []arg16{register16(reg, size), e}
+		}
+		return []arg16{e, register16(reg, size)}
+	case formALIb:
+		return []arg16{register16(0, 8), imm16(d.next(), 8)}
+	}
+	size := d.operandSize()
+	return []arg16{register16(0, size), imm16(d.immediate(size), size)}
+}
+
+// stringMnemonic returns the name of a string instruction, like "stosb", "stosw" or "stosd"
+func (d *decoder16) stringMnemonic(name string, op int) string {
+	if op&1 == 0 {
+		return name + "b"
+	}
+	if d.operandSize() == 32 {
+		return name + "d"
+	}
+	return name + "w"
+}
+
+// decode decodes the instruction at the current position.
+// Returns false if the bytes are not a known instruction.
+func (d *decoder16) decode() (instruction16, bool) {
+	inst := instruction16{target: -1}
+	rep := ""
+	op := 0
+prefixes:
+	for {
+		op = d.next()
+		switch op {
+		case 0x26, 0x2e, 0x36, 0x3e:
+			d.seg = segmentRegisters[(op>>3)&3]
+		case 0x64, 0x65:
+			d.seg = segmentRegisters[op-0x60]
+		case 0x66:
+			d.opsize = 32
+		case 0x67:
+			d.adsize = 32
+		case 0xf0:
+			inst.prefixes = append(inst.prefixes, "lock")
+		case 0xf2:
+			rep = "repne"
+		case 0xf3:
+			rep = "rep"
+		default:
+			break prefixes
+		}
+		if d.eof {
+			return inst, false
+		}
+	}
+	ok := true
+	switch {
+	case (op < 0x40) && (op&7 < 6):
+		inst.mnemonic = aluMnemonics[op>>3]
+		inst.args = d.operands(op & 7)
+	case (op < 0x20) && (op&6 == 6) && (op != 0x0f):
+		inst.mnemonic = "push"
+		if op&1 == 1 {
+			inst.mnemonic = "pop"
+		}
+		inst.args = []arg16{{kind: argRegister, text: segmentRegisters[op>>3]}}
+	case op == 0x0f:
+		ok = d.twoByte(&inst)
+	case op == 0x27, op == 0x2f, op == 0x37, op == 0x3f:
+		inst.mnemonic = map[int]string{0x27: "daa", 0x2f: "das", 0x37: "aaa", 0x3f: "aas"}[op]
+	case op < 0x60:
+		inst.mnemonic = []string{"inc", "dec", "push", "pop"}[(op-0x40)>>3]
+		inst.args = []arg16{register16(op&7, d.operandSize())}
+	case op == 0x60, op == 0x61:
+		inst.mnemonic = []string{"pusha", "popa"}[op&1]
+		if d.operandSize() == 32 {
+			inst.mnemonic += "d"
+		}
+	case op == 0x62:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		if mod == 3 {
+			return inst, false
+		}
+		inst.mnemonic = "bound"
+		inst.args = []arg16{register16(reg, size), d.rm(mod, rm, 0)}
+	case op == 0x63:
+		inst.mnemonic = "arpl"
+		mod, reg, rm := d.modrm()
+		inst.args = []arg16{d.rm(mod, rm, 16), register16(reg, 16)}
+	case op == 0x68:
+		size := d.operandSize()
+		inst.mnemonic = "push"
+		inst.args = []arg16{imm16(d.immediate(size), size)}
+		if size == 32 {
+			inst.args[0].text = "dword " + inst.args[0].text
+		}
+	case op == 0x6a:
+		size := d.operandSize()
+		inst.mnemonic = "push"
+		inst.args = []arg16{imm16(d.signed(size), size)}
+		if size == 32 {
+			inst.args[0].text = "dword " + inst.args[0].text
+		}
+	case op == 0x69, op == 0x6b:
+		inst.mnemonic = "imul"
+		inst.args = d.operands(formGvEv)
+		size := inst.args[0].size
+		if op == 0x69 {
+			inst.args = append(inst.args, imm16(d.immediate(size), size))
+		} else {
+			inst.args = append(inst.args, imm16(d.signed(size), size))
+		}
+	case (op >= 0x6c) && (op <= 0x6f):
+		inst.mnemonic = d.stringMnemonic([]string{"ins", "outs"}[(op>>1)&1], op)
+	case (op >= 0x70) && (op <= 0x7f):
+		inst.mnemonic = "j" + jumpConditions[op&15]
+		inst.args = []arg16{d.jump(8)}
+	case (op >= 0x80) && (op <= 0x83):
+		size := 8
+		if op&1 == 1 {
+			size = d.operandSize()
+		}
+		mod, reg, rm := d.modrm()
+		inst.mnemonic = aluMnemonics[reg]
+		e := d.rm(mod, rm, size)
+		switch op {
+		case 0x81:
+			inst.args = []arg16{e, imm16(d.immediate(size), size)}
+		case 0x83:
+			inst.args = []arg16{e, imm16(d.signed(size), size)}
+		default:
+			inst.args = []arg16{e, imm16(d.next(), 8)}
+		}
+	case (op >= 0x84) && (op <= 0x8b):
+		inst.mnemonic = []string{"test", "xchg", "mov", "mov"}[(op-0x84)>>1]
+		if op < 0x88 {
+			inst.args = d.operands(op & 1)
+		} else {
+			inst.args = d.operands(op - 0x88)
+		}
+	case op == 0x8c, op == 0x8e:
+		mod, reg, rm := d.modrm()
+		if reg > 5 {
+			return inst, false
+		}
+		inst.mnemonic = "mov"
+		s := arg16{kind: argRegister, text: segmentRegisters[reg]}
+		e := d.rm(mod, rm, 16)
+		inst.args = []arg16{e, s}
+		if op == 0x8e {
+			inst.args = []arg16{s, e}
+		}
+	case op == 0x8d:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		if mod == 3 {
+			return inst, false
+		}
+		inst.mnemonic = "lea"
+		inst.args = []arg16{register16(reg, size), d.rm(mod, rm, 0)}
+	case op == 0x8f:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		if reg != 0 {
+			return inst, false
+		}
+		inst.mnemonic = "pop"
+		inst.args = []arg16{d.rm(mod, rm, size)}
+	case op == 0x90:
+		inst.mnemonic = "nop"
+	case op < 0x98:
+		size := d.operandSize()
+		inst.mnemonic = "xchg"
+		inst.args = []arg16{register16(op&7, size), register16(0, size)}
+	case op == 0x98, op == 0x99:
+		inst.mnemonic = []string{"cbw", "cwd"}[op&1]
+		if d.operandSize() == 32 {
+			inst.mnemonic = []string{"cwde", "cdq"}[op&1]
+		}
+	case op == 0x9a, op == 0xea:
+		size := d.operandSize()
+		offset := d.immediate(size)
+		segment := d.immediate(16)
+		inst.mnemonic = "call"
+		if op == 0xea {
+			inst.mnemonic = "jmp"
+		}
+		inst.args = []arg16{{kind: argImmediate, text: hexNumber(segment) + ":" + hexNumber(offset)}}
+	case op == 0x9b:
+		inst.mnemonic = "wait"
+	case op == 0x9c, op == 0x9d:
+		inst.mnemonic = []string{"pushf", "popf"}[op&1]
+		if d.operandSize() == 32 {
+			inst.mnemonic += "d"
+		}
+	case op == 0x9e:
+		inst.mnemonic = "sahf"
+	case op == 0x9f:
+		inst.mnemonic = "lahf"
+	case (op >= 0xa0) && (op <= 0xa3):
+		size := 8
+		if op&1 == 1 {
+			size = d.operandSize()
+		}
+		d.adsizeUsed = true
+		m := d.memoryArg(hexNumber(d.immediate(d.adsize)), size)
+		inst.mnemonic = "mov"
+		inst.args = []arg16{register16(0, size), m}
+		if op >= 0xa2 {
+			inst.args = []arg16{m, register16(0, size)}
+		}
+	case op == 0xa8, op == 0xa9:
+		inst.mnemonic = "test"
+		inst.args = d.operands(formALIb + op&1)
+	case (op >= 0xa4) && (op <= 0xaf):
+		inst.mnemonic = d.stringMnemonic(map[int]string{0xa4: "movs", 0xa6: "cmps", 0xaa: "stos", 0xac: "lods", 0xae: "scas"}[op&^1], op)
+		if (rep == "rep") && ((op&^1 == 0xa6) || (op&^1 == 0xae)) {
+			rep = "repe"
+		}
+	case op < 0xb8:
+		inst.mnemonic = "mov"
+		inst.args = []arg16{register16(op&7, 8), imm16(d.next(), 8)}
+	case op < 0xc0:
+		size := d.operandSize()
+		inst.mnemonic = "mov"
+		inst.args = []arg16{register16(op&7, size), imm16(d.immediate(size), size)}
+	case op == 0xc0, op == 0xc1, (op >= 0xd0) && (op <= 0xd3):
+		size := 8
+		if op&1 == 1 {
+			size = d.operandSize()
+		}
+		mod, reg, rm := d.modrm()
+		inst.mnemonic = shiftMnemonics[reg]
+		e := d.rm(mod, rm, size)
+		switch {
+		case op <= 0xc1:
+			inst.args = []arg16{e, imm16(d.next(), 8)}
+		case op <= 0xd1:
+			inst.args = []arg16{e, imm16(1, 8)}
+		default:
+			inst.args = []arg16{e, register16(1, 8)}
+		}
+	case op == 0xc2, op == 0xca:
+		inst.mnemonic = []string{"ret", "retf"}[(op>>3)&1]
+		inst.args = []arg16{imm16(d.immediate(16), 16)}
+	case op == 0xc3, op == 0xcb:
+		inst.mnemonic = []string{"ret", "retf"}[(op>>3)&1]
+	case op == 0xc4, op == 0xc5:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		if mod == 3 {
+			return inst, false
+		}
+		inst.mnemonic = []string{"les", "lds"}[op&1]
+		inst.args = []arg16{register16(reg, size), d.rm(mod, rm, 0)}
+	case op == 0xc6, op == 0xc7:
+		size := 8
+		if op == 0xc7 {
+			size = d.operandSize()
+		}
+		mod, reg, rm := d.modrm()
+		if reg != 0 {
+			return inst, false
+		}
+		inst.mnemonic = "mov"
+		inst.args = []arg16{d.rm(mod, rm, size), imm16(d.immediate(size), size)}
+	case op == 0xc8:
+		inst.mnemonic = "enter"
+		inst.args = []arg16{imm16(d.immediate(16), 16), imm16(d.next(), 8)}
+	case op == 0xc9:
+		inst.mnemonic = "leave"
+	case op == 0xcc:
+		inst.mnemonic = "int3"
+	case op == 0xcd:
+		inst.mnemonic = "int"
+		inst.args = []arg16{imm16(d.next(), 8)}
+	case op == 0xce:
+		inst.mnemonic = "into"
+	case op == 0xcf:
+		inst.mnemonic = "iret"
+		if d.operandSize() == 32 {
+			inst.mnemonic = "iretd"
+		}
+	case op == 0xd4, op == 0xd5:
+		inst.mnemonic = []string{"aam", "aad"}[op&1]
+		if v := d.next(); v != 10 {
+			inst.args = []arg16{imm16(v, 8)}
+		}
+	case op == 0xd6:
+		inst.mnemonic = "salc"
+	case op == 0xd7:
+		inst.mnemonic = "xlatb"
+	case op < 0xe0:
+		ok = d.fpu(&inst, op)
+	case op <= 0xe3:
+		inst.mnemonic = []string{"loopne", "loope", "loop", "jcxz"}[op&3]
+		if (op == 0xe3) && (d.adsize == 32) {
+			inst.mnemonic = "jecxz"
+			d.adsizeUsed = true
+		}
+		inst.args = []arg16{d.jump(8)}
+	case (op >= 0xe4) && (op <= 0xe7), (op >= 0xec) && (op <= 0xef):
+		size := 8
+		if op&1 == 1 {
+			size = d.operandSize()
+		}
+		port := arg16{kind: argRegister, text: "dx", size: 16}
+		if op < 0xe8 {
+			port = imm16(d.next(), 8)
+		}
+		inst.mnemonic = "in"
+		inst.args = []arg16{register16(0, size), port}
+		if op&2 == 2 {
+			inst.mnemonic = "out"
+			inst.args = []arg16{port, register16(0, size)}
+		}
+	case op == 0xe8, op == 0xe9:
+		inst.mnemonic = []string{"call", "jmp"}[op&1]
+		inst.args = []arg16{d.jump(d.operandSize())}
+	case op == 0xeb:
+		inst.mnemonic = "jmp"
+		inst.args = []arg16{d.jump(8)}
+	case op == 0xf1:
+		inst.mnemonic = "int1"
+	case op == 0xf4:
+		inst.mnemonic = "hlt"
+	case op == 0xf5:
+		inst.mnemonic = "cmc"
+	case op == 0xf6, op == 0xf7:
+		size := 8
+		if op == 0xf7 {
+			size = d.operandSize()
+		}
+		mod, reg, rm := d.modrm()
+		inst.mnemonic = []string{"test", "test", "not", "neg", "mul", "imul", "div", "idiv"}[reg]
+		inst.args = []arg16{d.rm(mod, rm, size)}
+		if reg < 2 {
+			inst.args = append(inst.args, imm16(d.immediate(size), size))
+		}
+	case (op >= 0xf8) && (op <= 0xfd):
+		inst.mnemonic = []string{"clc", "stc", "cli", "sti", "cld", "std"}[op-0xf8]
+	case op == 0xfe:
+		mod, reg, rm := d.modrm()
+		if reg > 1 {
+			return inst, false
+		}
+		inst.mnemonic = []string{"inc", "dec"}[reg]
+		inst.args = []arg16{d.rm(mod, rm, 8)}
+	case op == 0xff:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		if (reg == 7) || (((reg == 3) || (reg == 5)) && (mod == 3)) {
+			return inst, false
+		}
+		inst.mnemonic = []string{"inc", "dec", "call", "call", "jmp", "jmp", "push"}[reg]
+		e := d.rm(mod, rm, size)
+		if (reg == 3) || (reg == 5) {
+			e.size, e.keyword = 0, "far"
+		}
+		inst.args = []arg16{e}
+	default:
+		ok = false
+	}
+	if !ok || d.eof {
+		return inst, false
+	}
+	// Prefixes that are not part of the instruction text come first
+	if rep != "" {
+		inst.prefixes = append(inst.prefixes, rep)
+	}
+	if (d.seg != "") && !d.segUsed {
+		inst.prefixes = append(inst.prefixes, d.seg)
+	}
+	if (d.opsize == 32) && !d.opsizeUsed {
+		inst.prefixes = append(inst.prefixes, "o32")
+	}
+	if (d.adsize == 32) && !d.adsizeUsed {
+		inst.prefixes = append(inst.prefixes, "a32")
+	}
+	for _, a := range inst.args {
+		if a.kind == argTarget {
+			inst.target = a.value
+		}
+	}
+	return inst, true
+}
+
+// twoByte decodes the instructions that start with 0x0f
+func (d *decoder16) twoByte(inst *instruction16) bool {
+	op := d.next()
+	switch {
+	case (op >= 0x80) && (op <= 0x8f):
+		inst.mnemonic = "j" + jumpConditions[op&15]
+		inst.args = []arg16{d.jump(d.operandSize())}
+	case (op >= 0x90) && (op <= 0x9f):
+		mod, _, rm := d.modrm()
+		inst.mnemonic = "set" + jumpConditions[op&15]
+		inst.args = []arg16{d.rm(mod, rm, 8)}
+	case (op >= 0x40) && (op <= 0x4f):
+		inst.mnemonic = "cmov" + jumpConditions[op&15]
+		inst.args = d.operands(formGvEv)
+	case op == 0xa0, op == 0xa1, op == 0xa8, op == 0xa9:
+		inst.mnemonic = []string{"push", "pop"}[op&1]
+		inst.args = []arg16{{kind: argRegister, text: segmentRegisters[4+(op>>3)&1]}}
+	case op == 0xa2:
+		inst.mnemonic = "cpuid"
+	case op == 0x31:
+		inst.mnemonic = "rdtsc"
+	case op == 0xa3, op == 0xab, op == 0xb3, op == 0xbb:
+		inst.mnemonic = []string{"bt", "bts", "btr", "btc"}[(op>>3)&3]
+		inst.args = d.operands(formEvGv)
+	case op == 0xa4, op == 0xa5, op == 0xac, op == 0xad:
+		inst.mnemonic = []string{"shld", "shrd"}[(op>>3)&1]
+		inst.args = d.operands(formEvGv)
+		if op&1 == 0 {
+			inst.args = append(inst.args, imm16(d.next(), 8))
+		} else {
+			inst.args = append(inst.args, register16(1, 8))
+		}
+	case op == 0xaf:
+		inst.mnemonic = "imul"
+		inst.args = d.operands(formGvEv)
+	case op == 0xb6, op == 0xb7, op == 0xbe, op == 0xbf:
+		inst.mnemonic = []string{"movzx", "movsx"}[(op>>3)&1]
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		inst.args = []arg16{register16(reg, size), d.rm(mod, rm, 8<<uint(op&1))}
+	case op == 0xba:
+		size := d.operandSize()
+		mod, reg, rm := d.modrm()
+		if reg < 4 {
+			return false
+		}
+		inst.mnemonic = []string{"bt", "bts", "btr", "btc"}[reg-4]
+		inst.args = []arg16{d.rm(mod, rm, size), imm16(d.next(), 8)}
+	case op == 0xbc, op == 0xbd:
+		inst.mnemonic = []string{"bsf", "bsr"}[op&1]
+		inst.args = d.operands(formGvEv)
+	case (op >= 0xc8) && (op <= 0xcf):
+		// bswap is undefined for 16-bit registers
+		if d.operandSize() != 32 {
+			return false
+		}
+		inst.mnemonic = "bswap"
+		inst.args = []arg16{register16(op&7, 32)}
+	case op == 0xb0, op == 0xb1, op == 0xc0, op == 0xc1:
+		inst.mnemonic = []string{"cmpxchg", "xadd"}[(op>>4)&1^1]
+		inst.args = d.operands(op & 1)
+	default:
+		return false
+	}
+	return true
+}
+
+// fpu decodes the x87 instructions, from 0xd8 to 0xdf
+func (d *decoder16) fpu(inst *instruction16, op int) bool {
+	mod, reg, rm := d.modrm()
+	if mod != 3 {
+		fields := strings.Fields(fpuMemory[op-0xd8][reg])
+		if len(fields) == 0 {
+			return false
+		}
+		inst.mnemonic = fields[0]
+		m := d.rm(mod, rm, 0)
+		if len(fields) > 1 {
+			m.keyword = fields[1]
+		}
+		inst.args = []arg16{m}
+		return true
+	}
+	if name, ok := fpuSingle[[2]byte{byte(op), byte(0xc0 | reg<<3 | rm)}]; ok {
+		fields := strings.Fields(name)
+		inst.mnemonic = fields[0]
+		if len(fields) > 1 {
+			inst.args = []arg16{{kind: argRegister, text: fields[1], size: 16}}
+		}
+		return true
+	}
+	form := fpuRegister[op-0xd8][reg]
+	if form == "" {
+		return false
+	}
+	fields := strings.Fields(strings.Replace(form, ",", "", -1))
+	inst.mnemonic = fields[0]
+	for _, f := range fields[1:] {
+		if f == "i" {
+			f = "st" + strconv.Itoa(rm)
+		}
+		inst.args = append(inst.args, arg16{kind: argRegister, text: f})
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"strings"
+)
+
+// flagJumps maps the CPU flags that can be used as conditions to the conditional jumps
+// for when the flag is set and for when it is not
+var flagJumps = map[string][2]string{
+	"zero":     {"jz", "jnz"},
+	"carry":    {"jc", "jnc"},
+	"sign":     {"js", "jns"},
+	"overflow": {"jo", "jno"},
+}
+
+// flagCondition returns the jump for when the flag condition at the end of the statement is true,
+// and the jump for when it is false. The condition is either a flag, like "carry", or "not" and a flag.
+func flagCondition(st Statement) (string, string, string) {
+	flag := st[len(st)-1].Value
+	jumps, ok := flagJumps[flag]
+	if !ok {
+		fatal("Error: Unknown flag:", flag)
+	}
+	if (len(st) > 2) && (st[len(st)-2].Value == "not") {
+		return jumps[1], jumps[0], "not " + flag
+	}
+	return jumps[0], jumps[1], flag
+}
+
+// emitIfFlag starts an if block that is run if the flag condition is true
+func emitIfFlag(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inIfBlock != "" {
+		fatal("Error: Already in an if-block (nested block are to be implemented)")
+	}
+	ps.inIfBlock = ps.newIfLabel()
+	_, jumpIfFalse, condition := flagCondition(st)
+	asmcode := "\t;--- " + ps.inIfBlock + " ---\n"
+	asmcode += "\t" + jumpIfFalse + " " + ps.inIfBlock + "_end\t\t\t; skip the block unless " + condition + "\n"
+	return asmcode
+}
+
+// emitBreakIfFlag breaks out of a loop if the flag condition is true
+func emitBreakIfFlag(config *TargetConfig, ps *ProgramState, st Statement) string {
+	jumpIfTrue, jumpIfFalse, condition := flagCondition(st)
+	return config.breakIf(ps, jumpIfTrue, jumpIfFalse, condition)
+}
+
+// emitContinueIfFlag continues from the top of a loop if the flag condition is true
+func emitContinueIfFlag(config *TargetConfig, ps *ProgramState, st Statement) string {
+	jumpIfTrue, jumpIfFalse, condition := flagCondition(st)
+	return config.continueIf(ps, st[0], jumpIfTrue, jumpIfFalse, condition)
+}
+
+// breakIf returns code for breaking out of the current loop, with the given conditional jumps,
+// for after the flags have been set
+func (config *TargetConfig) breakIf(ps *ProgramState, jumpIfTrue, jumpIfFalse, condition string) string {
+	if ps.inLoop == "" {
+		fatal("Error: Unclear which loop one should break out of.")
+	}
+	saved := config.loopSavedRegister(ps)
+	if saved == "" {
+		return "\t" + jumpIfTrue + " " + ps.inLoop + "_end\t\t\t; break if " + condition + "\n"
+	}
+	// The saved register must only be restored if the loop is left
+	skip := ps.newIfLabel() + "_skip"
+	asmcode := "\t" + jumpIfFalse + " " + skip + "\t\t\t; do not break unless " + condition + "\n"
+	asmcode += "\tpop " + saved + "\t\t\t\t; restore counter\n"
+	asmcode += "\tjmp " + ps.inLoop + "_end\t\t\t; break\n"
+	asmcode += skip + ":\n"
+	return asmcode
+}
+
+// continueIf returns code for continuing from the top of the current loop, with the given conditional
+// jumps, for after the flags have been set. The given token is the "continue" keyword.
+func (config *TargetConfig) continueIf(ps *ProgramState, keyword Token, jumpIfTrue, jumpIfFalse, condition string) string {
+	if ps.inLoop == "" {
+		fatal("Error: Unclear which loop one should continue to the top of.")
+	}
+	if ps.inStructuredLoop() {
+		return "\t" + jumpIfTrue + " " + ps.inLoop + "_continue\t\t\t; continue if " + condition + "\n"
+	}
+	if strings.HasPrefix(ps.inLoop, endlessloopPrefix) {
+		return "\t" + jumpIfTrue + " " + ps.inLoop + "\t\t\t; continue if " + condition + "\n"
+	}
+	// Decreasing the counter changes the flags, so jump past the continue unless the condition is true
+	skip := ps.newIfLabel() + "_skip"
+	asmcode := "\t" + jumpIfFalse + " " + skip + "\t\t\t; do not continue unless " + condition + "\n"
+	asmcode += emitContinue(config, ps, Statement{keyword})
+	asmcode += skip + ":\n"
+	return asmcode
+}
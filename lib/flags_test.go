@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestInterpretFlags(t *testing.T) {
+	defer quiet()()
+	testExitCodes(t, "", []exitCodeTest{
+		{"zero", "rcx = 1\nrbx = 4\nrbx -= 4\nif zero\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"zero, when not set", "rcx = 1\nrbx = 5\nrbx -= 4\nif zero\n    rcx = 7\nend\nexit(rcx)", 1},
+		{"not zero", "rcx = 1\nrbx = 5\nrbx -= 4\nif not zero\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"carry", "rcx = 1\nrbx = 3\nrbx -= 4\nif carry\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"not carry", "rcx = 1\nrbx = 5\nrbx -= 4\nif not carry\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"sign", "rcx = 1\nrbx = 3\nrbx -= 4\nif sign\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"overflow", "rcx = 1\nbl = 127\nbl += 2\nif overflow\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"not overflow", "rcx = 1\nbl = 100\nbl += 2\nif not overflow\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"carry after += 1", "rcx = 1\nrbx = -1\nrbx += 1\nif carry\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"borrow after -= 1", "rcx = 1\nrbx = 0\nrbx -= 1\nif carry\n    rcx = 7\nend\nexit(rcx)", 7},
+		{"add with carry after += 1", "rcx = 0\nrbx = -1\nrbx += 1\nrcx +c= 0\nexit(rcx)", 1},
+		{"break carry", "rbx = 0\nal = 250\nloop 10\n    rbx++\n    al += 2\n    break carry\nend\nexit(rbx)", 3},
+		{"continue not zero", "rbx = 0\nrdx = 0\nloop 6\n    rdx ^= 1\n    continue not zero\n    rbx++\nend\nexit(rbx)", 3},
+	})
+}
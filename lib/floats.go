@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// floatOperand is an f64 value in an xmm register or in memory
+type floatOperand struct {
+	name    string // as it is written in the program
+	xmm     string // the xmm register, or empty if the value is in memory
+	address string // the memory operand, like [pi] or [xs+ecx*8]
+	before  string // code that calculates the address in a register, if needed
+	after   string // code that restores the register that was used for the address
+}
+
+var (
+	// floatRegisters are the SSE2 registers that hold f64 values. xmm8 to xmm15 are only available on 64-bit.
+	floatRegisters = []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7",
+		"xmm8", "xmm9", "xmm10", "xmm11", "xmm12", "xmm13", "xmm14", "xmm15"}
+
+	// floatFunctions maps the built-in float functions to the x87 instructions that calculate them
+	floatFunctions = map[string]string{"sqrt": "fsqrt", "sin": "fsin", "cos": "fcos"}
+
+	// floatInstructions maps the operators to the SSE2 instruction for an xmm register and the x87 instruction
+	// for memory, where the x87 instruction calculates "memory operator st0"
+	floatInstructions = map[TokenType][2]string{ADDITION: {"addsd", "fadd"}, SUBTRACTION: {"subsd", "fsubr"},
+		MULTIPLICATION: {"mulsd", "fmul"}, DIVISION: {"divsd", "fdivr"}}
+)
+
+// floatScratch is reserved in the .bss section and used for moving values between the x87 FPU and registers.
+// It has room for three f64 values, so that it can also hold a vec3 while rotating.
+const floatScratch = "_float_scratch"
+
+// isFloat checks if the given string is a floating point number, like 3.14 or -0.5
+func isFloat(s string) bool {
+	if !strings.Contains(s, ".") || !strings.ContainsAny(s, "0123456789") {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// floatConstant returns the number of values, if the given values of a constant are f64 values, or 0 if not
+func floatConstant(values []Token) int {
+	float := false
+	for _, t := range values {
+		if t.T != VALUE {
+			return 0
+		}
+		float = float || isFloat(strings.TrimSuffix(t.Value, ","))
+	}
+	if !float {
+		return 0
+	}
+	return len(values)
+}
+
+// isFloatName checks if the given token is the name of an f64 variable or constant
+func (ps *ProgramState) isFloatName(t Token) bool {
+	v, ok := ps.typedVariables[t.Value]
+	return (t.T == VALIDNAME) && ok && (v.typ == "f64")
+}
+
+// floatOperand finds the xmm register or the memory operand for an f64 value.
+// If the address needs to be calculated in a register, a register from another family than the given one is used.
+func (config *TargetConfig) floatOperand(ps *ProgramState, t Token, avoid string) *floatOperand {
+	switch t.T {
+	case FLOATREG:
+		if config.PlatformBits == 16 {
+			fatal("Error: The xmm registers can not be used on 16-bit platforms, use f64 variables instead of", t.Value)
+		}
+		if (config.PlatformBits == 32) && (pos(floatRegisters, t.Value) >= 8) {
+			fatal("Error: xmm8 to xmm15 are only available on 64-bit platforms, not", t.Value)
+		}
+		return &floatOperand{name: t.Value, xmm: t.Value}
+	case VALIDNAME:
+		if v, ok := ps.typedVariables[t.Value]; ok && (v.typ == "f64") {
+			if v.count > 0 {
+				fatal("Error:", t.Value, "is an array and needs an index, like "+t.Value+"[0]")
+			}
+			return &floatOperand{name: t.Value, address: "[" + t.Value + "]"}
+		}
+	case ELEMENT:
+		if e := config.resolveElement(ps, t.Value, avoid); e.float {
+			return &floatOperand{t.Value, "", e.address, e.before, e.after}
+		}
+	}
+	fatal("Error:", t.Value, "is not an xmm register or an f64 variable, constant or field")
+	return nil
+}
+
+// operand returns the operand for an SSE2 instruction
+func (f *floatOperand) operand() string {
+	if f.xmm != "" {
+		return f.xmm
+	}
+	return "qword " + f.address
+}
+
+// use returns the given instruction, with the code for calculating the address around it
+func (f *floatOperand) use(instruction string) string {
+	return f.before + instruction + f.after
+}
+
+// fpuLoad returns code that pushes the value onto the x87 FPU stack
+func (ps *ProgramState) fpuLoad(f *floatOperand) string {
+	if f.xmm == "" {
+		return f.use("\tfld qword " + f.address + "\t\t; push " + f.name + " to the FPU\n")
+	}
+	ps.floatScratchNeeded = true
+	asmcode := "\tmovsd [" + floatScratch + "], " + f.xmm + "\n"
+	return asmcode + "\tfld qword [" + floatScratch + "]\t; push " + f.name + " to the FPU\n"
+}
+
+// fpuStore returns code that pops the value at the top of the x87 FPU stack into the given operand
+func (ps *ProgramState) fpuStore(f *floatOperand) string {
+	if f.xmm == "" {
+		return f.use("\tfstp qword " + f.address + "\t\t; pop the result to " + f.name + "\n")
+	}
+	ps.floatScratchNeeded = true
+	asmcode := "\tfstp qword [" + floatScratch + "]\t; pop the result from the FPU\n"
+	return asmcode + "\tmovsd " + f.xmm + ", [" + floatScratch + "]\t; " + f.name + " = the result\n"
+}
+
+// emitFloatAssign assigns an f64 value to an xmm register or to f64 memory, like: xmm0 = pi
+func emitFloatAssign(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.vectorType(st[0]) != "" {
+		return emitVectorArithmetic(config, ps, st)
+	}
+	dest := config.floatOperand(ps, st[0], "")
+	src := config.floatOperand(ps, st[2], "")
+	comment := "\t\t; " + tokensString(st) + "\n"
+	switch {
+	case dest.xmm != "":
+		return src.use("\tmovsd " + dest.xmm + ", " + src.operand() + comment)
+	case src.xmm != "":
+		return dest.use("\tmovsd " + dest.operand() + ", " + src.xmm + comment)
+	}
+	// From memory to memory, through the FPU
+	return ps.fpuLoad(src) + ps.fpuStore(dest)
+}
+
+// emitFloatArithmetic adds, subtracts, multiplies or divides an f64 value, like: xmm0 *= xmm1
+func emitFloatArithmetic(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.vectorType(st[0]) != "" {
+		return emitVectorArithmetic(config, ps, st)
+	}
+	dest := config.floatOperand(ps, st[0], "")
+	src := config.floatOperand(ps, st[2], "")
+	instructions := floatInstructions[st[1].T]
+	comment := "\t\t; " + tokensString(st) + "\n"
+	if dest.xmm != "" {
+		return src.use("\t" + instructions[0] + " " + dest.xmm + ", " + src.operand() + comment)
+	}
+	// With the FPU, where the value that is pushed first is the right hand side
+	asmcode := ps.fpuLoad(src)
+	asmcode += dest.use("\t" + instructions[1] + " qword " + dest.address + comment + "\tfstp qword " + dest.address + "\t\t; store the result\n")
+	return asmcode
+}
+
+// emitFloatFunction calculates sqrt, sin or cos of an f64 value, like: xmm0 = sqrt(xmm1)
+func emitFloatFunction(config *TargetConfig, ps *ProgramState, st Statement) string {
+	dest := config.floatOperand(ps, st[0], "")
+	src := config.floatOperand(ps, st[3], "")
+	function := st[2].Value
+	if (config.PlatformBits == 64) && (dest.xmm != "") && (function == "sqrt") {
+		return src.use("\tsqrtsd " + dest.xmm + ", " + src.operand() + "\t\t; " + tokensString(st) + "\n")
+	}
+	// SSE2 has no sin or cos, and the FPU is used on 16-bit and 32-bit platforms
+	asmcode := ps.fpuLoad(src)
+	asmcode += "\t" + floatFunctions[function] + "\t\t\t\t; " + function + "\n"
+	return asmcode + ps.fpuStore(dest)
+}
+
+// emitIntegerToFloat converts the value of a general purpose register to f64, like: xmm0 = rax
+func emitIntegerToFloat(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (st[0].T == VALIDNAME) && !ps.isFloatName(st[0]) {
+		fatal("Error: Only registers can be assigned to f64 variables, and", st[0].Value, "is not one")
+	}
+	reg := st[2].Value
+	dest := config.floatOperand(ps, st[0], regFamily(reg))
+	bits := registerBits(reg)
+	if (bits < 16) || (bits > config.PlatformBits) {
+		fatal("Error: Can not convert", reg, "to f64, use a 16, 32 or 64-bit register")
+	}
+	comment := "\t\t; " + tokensString(st) + "\n"
+	if (dest.xmm != "") && (bits >= 32) {
+		return "\tcvtsi2sd " + dest.xmm + ", " + reg + comment
+	}
+	ps.floatScratchNeeded = true
+	asmcode := "\tmov [" + floatScratch + "], " + reg + comment
+	asmcode += "\tfild " + sizeQualifier(bits) + " [" + floatScratch + "]\t; push " + reg + " to the FPU\n"
+	return asmcode + ps.fpuStore(dest)
+}
+
+// emitFloatToInteger rounds an f64 value to the nearest integer, like: rax = round(xmm0)
+func emitFloatToInteger(config *TargetConfig, ps *ProgramState, st Statement) string {
+	reg := st[0].Value
+	src := config.floatOperand(ps, st[len(st)-1], regFamily(reg))
+	bits := registerBits(reg)
+	if (bits < 16) || (bits > config.PlatformBits) {
+		fatal("Error: Can not convert", src.name, "to", reg+", use a 16, 32 or 64-bit register")
+	}
+	comment := "\t\t; " + tokensString(st) + "\n"
+	if (bits >= 32) && ((src.xmm != "") || (config.PlatformBits == 64)) {
+		return src.use("\tcvtsd2si " + reg + ", " + src.operand() + comment)
+	}
+	ps.floatScratchNeeded = true
+	asmcode := ps.fpuLoad(src)
+	asmcode += "\tfistp " + sizeQualifier(bits) + " [" + floatScratch + "]\t; pop the rounded value\n"
+	return asmcode + "\tmov " + reg + ", [" + floatScratch + "]" + comment
+}
+
+// isFloatOperand checks if the given token is an xmm register, or an f64 variable, constant or field
+func (config *TargetConfig) isFloatOperand(ps *ProgramState, t Token) bool {
+	switch t.T {
+	case FLOATREG:
+		return true
+	case VALIDNAME:
+		return ps.isFloatName(t)
+	case ELEMENT:
+		return config.resolveElement(ps, t.Value, "").float
+	}
+	return false
+}
+
+// comparisonJumps returns the conditional jumps for when the comparison is true and for when it is false
+func (config *TargetConfig) comparisonJumps(ps *ProgramState, a Token, comparison string, b Token) [2]string {
+	if config.isFloatOperand(ps, a) || config.isFloatOperand(ps, b) {
+		return floatComparisonJumps[comparison]
+	}
+	return comparisonJumps[comparison]
+}
+
+// compareFloats compares two f64 values, with ucomisd if the first one is in an xmm register and with the FPU if not
+func (config *TargetConfig) compareFloats(ps *ProgramState, a, b Token, comment string) string {
+	left := config.floatOperand(ps, a, "")
+	right := config.floatOperand(ps, b, "")
+	if left.xmm != "" {
+		return right.use("\tucomisd " + left.xmm + ", " + right.operand() + "\t\t; " + comment + "\n")
+	}
+	asmcode := ps.fpuLoad(right) + ps.fpuLoad(left)
+	asmcode += "\tfcomip st0, st1\t\t\t; " + comment + "\n"
+	return asmcode + "\tfstp st0\t\t\t; pop " + right.name + " from the FPU\n"
+}
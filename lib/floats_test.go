@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsFloat(t *testing.T) {
+	for _, s := range []string{"3.14", "-0.5", "1.", ".5"} {
+		if !isFloat(s) {
+			t.Errorf("%s should be a float\n", s)
+		}
+	}
+	for _, s := range []string{"3", "0x10", ".", "[di+1.2]", "a.b"} {
+		if isFloat(s) {
+			t.Errorf("%s should not be a float\n", s)
+		}
+	}
+	if n := floatConstant([]Token{{VALUE, "1,", 0, ""}, {VALUE, "2.5", 0, ""}}); n != 2 {
+		t.Errorf("Expected 2 f64 values, got %d\n", n)
+	}
+}
+
+// The xmm registers are used with SSE2 instructions, and the f64 variables with the x87 FPU
+func TestInterpretFloats(t *testing.T) {
+	defer quiet()()
+	testExitCodes(t, "const three = 3.0\nconst limit = 10.0\nvar x f64\nvar y f64\n\n", []exitCodeTest{
+		{"xmm arithmetic", "rax = 7\nxmm0 = rax\nrax = 2\nxmm1 = rax\nxmm0 *= xmm1\nxmm0 -= xmm1\nxmm0 /= xmm1\nxmm0 *= three\nrbx = xmm0\nexit(rbx)", 18},
+		{"memory arithmetic", "rax = 7\nx = rax\nrax = 2\ny = rax\nx *= y\nx -= y\nx /= y\nx *= three\nrbx = round(x)\nexit(rbx)", 18},
+		{"xmm and memory", "rax = 5\nx = rax\nxmm0 = x\nxmm0 += xmm0\ny = xmm0\ny *= three\nrbx = round(y)\nexit(rbx)", 30},
+		{"sqrt", "rax = 10\nxmm0 = rax\nxmm0 = sqrt(xmm0)\nrbx = xmm0\nexit(rbx)", 3},
+		{"negative numbers", "rax = -9\nx = rax\nrbx = round(x)\nrbx += 20\nxmm0 = rax\nrcx = xmm0\nrcx += 30\nrbx += rcx\nexit(rbx)", 32},
+		{"from a 16-bit register", "cx = 300\nxmm0 = cx\nrax = 100\nxmm1 = rax\nxmm0 /= xmm1\nrbx = xmm0\nexit(rbx)", 3},
+		{"xmm less than", "rax = -3\nxmm0 = rax\nrax = 2\nxmm1 = rax\nrbx = 1\nif xmm0 < xmm1\n    rbx = 7\nend\nexit(rbx)", 7},
+		{"xmm greater than", "rax = -3\nxmm0 = rax\nrax = 2\nxmm1 = rax\nrbx = 1\nif xmm0 > xmm1\n    rbx = 7\nend\nexit(rbx)", 1},
+		{"xmm and a constant", "rax = 3\nxmm0 = rax\nrbx = 1\nif xmm0 == three\n    rbx = 7\nend\nexit(rbx)", 7},
+		{"memory less than", "rax = -3\nx = rax\nrax = 2\ny = rax\nrbx = 1\nif x < y\n    rbx = 7\nend\nexit(rbx)", 7},
+		{"memory greater than or equal", "rax = -3\nx = rax\nrax = 2\ny = rax\nrbx = 1\nif x >= y\n    rbx = 7\nend\nexit(rbx)", 1},
+		{"memory and xmm", "rax = 2\nx = rax\nxmm0 = x\nrbx = 1\nif x <= xmm0\n    rbx = 7\nend\nexit(rbx)", 7},
+		{"memory not equal", "rax = 2\nx = rax\nrbx = 1\nif x != three\n    rbx = 7\nend\nexit(rbx)", 7},
+		{"while", "rax = 1\nxmm0 = rax\nxmm1 = rax\nrbx = 0\nwhile xmm0 < limit\n    xmm0 += xmm1\n    rbx++\nend\nexit(rbx)", 9},
+		{"do until", "rax = 1\nx = rax\nrbx = 0\ndo\n    x *= three\n    rbx++\nuntil x > limit\nexit(rbx)", 3},
+		{"with an integer comparison", "rax = 1\nxmm0 = rax\nrbx = 1\nrcx = 2\nif xmm0 < limit and rcx == 2\n    rbx = 7\nend\nexit(rbx)", 7},
+	})
+}
+
+func TestCompareFloats(t *testing.T) {
+	defer quiet()()
+	source := "var x f64\nvar y f64\n\nfun main\n    if x < y\n        xmm0 = x\n    end\n    if xmm0 >= y\n        xmm1 = y\n    end\nend\n"
+	for _, bits := range []int{32, 64} {
+		config, err := NewTargetConfig(bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asmcode, _, _, _, err := config.Compile(source, "compare.bts", nil, false, NewProgramState())
+		if err != nil {
+			t.Fatalf("%d-bit: %v", bits, err)
+		}
+		for _, expected := range []string{"fcomip st0, st1", "jae ", "ucomisd xmm0, qword [y]", "jb "} {
+			if !strings.Contains(asmcode, expected) {
+				t.Errorf("%d-bit: expected %q in the assembly:\n%s", bits, expected, asmcode)
+			}
+		}
+	}
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source = "fun main\n    if xmm0 < rax\n        rax = 1\n    end\nend\n"
+	if _, _, _, _, err := config.Compile(source, "compare.bts", nil, false, NewProgramState()); err == nil {
+		t.Error("expected an error when comparing an xmm register with a general purpose register")
+	}
+}
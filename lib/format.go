@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// indentation is what one level of indentation looks like in formatted source code
+const indentation = "    "
+
+// formatLine is a line of formatted source code, before the trailing comments are aligned
+type formatLine struct {
+	code     string // the indented code, or the whole line if it is verbatim or only a comment
+	comment  string // the trailing comment, if any
+	verbatim bool   // inline C, which is not formatted
+}
+
+// operatorsBySize are the operators and comparisons, with the longest ones first,
+// for splitting words like "a+=2" into "a", "+=" and "2"
+var operatorsBySize = func() []string {
+	var ops []string
+	for _, op := range append(append([]string{}, operators...), comparisons...) {
+		if op != ".." {
+			ops = append(ops, op)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool { return len(ops[i]) > len(ops[j]) })
+	return ops
+}()
+
+// Format returns the given Battlestar source code in the canonical format.
+// Blocks are indented with four spaces, the spacing around operators is normalized
+// and trailing comments on consecutive lines are aligned. Inline C and other inline code is left as it is.
+// Formatting code that is already formatted does not change it.
+func Format(source string) string {
+	var (
+		lines    []formatLine
+		blocks   []string // the first word of each block that is open, like "fun" or "loop"
+		previous = ""     // the previous line, for collapsing blank lines
+	)
+	source = strings.Replace(source, "\r\n", "\n", -1)
+	sourceLines := strings.Split(source, "\n")
+
+	// The lines of C code are kept as they are, and the "end" of an "inline_c" block does not end a Battlestar block.
+	// The same goes for inline assembly and the other inline languages.
+	cCode := make([]bool, len(sourceLines))
+	cEnd := make([]bool, len(sourceLines))
+	for _, block := range CBlocks(sourceLines) {
+		for j := range block.Code {
+			cCode[block.CodeStart+j] = true
+		}
+		cEnd[block.End] = block.Delimited && !cCode[block.End]
+	}
+	for _, block := range InlineBlocks(sourceLines) {
+		for j := range block.Code {
+			cCode[block.Start+1+j] = true
+		}
+		cEnd[block.End] = !cCode[block.End]
+	}
+
+	for i, line := range sourceLines {
+		code := strings.TrimSpace(removecomments(line))
+		words := strings.Fields(code)
+		first := ""
+		if len(words) > 0 {
+			first = words[0]
+		}
+		// Inline C and the other inline code is kept as it is, except for the lines that start and end it
+		if cCode[i] {
+			lines = append(lines, formatLine{code: line, verbatim: true})
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			// At most one blank line in a row, and none at the start
+			if (previous != "") && (len(lines) > 0) {
+				lines = append(lines, formatLine{})
+			}
+			previous = ""
+			continue
+		}
+		previous = trimmed
+		comment := strings.TrimSpace(trimmed[len(strings.TrimSpace(removecomments(trimmed))):])
+		if first == "fun" {
+			// A function can not be declared within another function, so the previous one has ended
+			for j := len(blocks) - 1; j >= 0; j-- {
+				if blocks[j] == "fun" {
+					blocks = blocks[:j]
+					break
+				}
+			}
+		}
+		if !cEnd[i] && closesBlock(words) && (len(blocks) > 0) {
+			blocks = blocks[:len(blocks)-1]
+		}
+		indent := strings.Repeat(indentation, len(blocks))
+		if code == "" {
+			// A comment on its own line, which continues the trailing comment of the line above
+			// if it is indented further than the code
+			last := len(lines) - 1
+			if (last >= 0) && (lines[last].comment != "") && (len(line)-len(strings.TrimLeft(line, " \t")) > len(indent)) {
+				lines = append(lines, formatLine{comment: comment})
+			} else {
+				lines = append(lines, formatLine{code: indent + comment})
+			}
+			continue
+		}
+		lines = append(lines, formatLine{code: indent + normalizeSpacing(code), comment: comment})
+		switch {
+		case inlineKeyword(first):
+			// Ended by the "end" after the inline code
+		case opensBlock(words):
+			blocks = append(blocks, first)
+		case has([]string{"ret", "exit", "noret"}, first) && (len(blocks) > 0) && (blocks[len(blocks)-1] == "fun") && !endFollows(sourceLines[i+1:]):
+			// The function ends here, unless it is also ended with "end"
+			blocks = blocks[:len(blocks)-1]
+		}
+	}
+	for (len(lines) > 0) && (lines[len(lines)-1] == formatLine{}) {
+		lines = lines[:len(lines)-1]
+	}
+	return alignComments(lines)
+}
+
+// endFollows checks if the next line with code is "end"
+func endFollows(lines []string) bool {
+	for _, line := range lines {
+		if words := strings.Fields(removecomments(strings.TrimSpace(line))); len(words) > 0 {
+			return (len(words) == 1) && (words[0] == "end")
+		}
+	}
+	return false
+}
+
+// alignComments aligns the trailing comments within each group of lines that are not separated
+// by blank lines or inline C, and returns the lines as one string
+func alignComments(lines []formatLine) string {
+	var sb strings.Builder
+	for i := 0; i < len(lines); {
+		// Find the end of the group, and the longest line of code with a trailing comment
+		j, width := i, 0
+		for ; (j < len(lines)) && !lines[j].verbatim && (lines[j] != formatLine{}); j++ {
+			if (lines[j].comment != "") && (len(lines[j].code) > width) {
+				width = len(lines[j].code)
+			}
+		}
+		if j == i {
+			j++
+		}
+		for ; i < j; i++ {
+			if lines[i].comment == "" {
+				sb.WriteString(lines[i].code + "\n")
+			} else {
+				sb.WriteString(lines[i].code + strings.Repeat(" ", width-len(lines[i].code)+1) + lines[i].comment + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// normalizeSpacing puts one space between the words of a line of code and around the operators,
+// without changing strings or lines that are passed on to the assembler or declare constants
+func normalizeSpacing(code string) string {
+	var (
+		words []string
+		word  []rune
+		quote rune
+	)
+	for _, r := range code {
+		switch {
+		case quote != 0:
+			word = append(word, r)
+			if r == quote {
+				quote = 0
+			}
+		case (r == '"') || (r == '\''):
+			word = append(word, r)
+			quote = r
+		case (r == ' ') || (r == '\t'):
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+		default:
+			word = append(word, r)
+		}
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	if has([]string{"asm", "const", "use", "import", "extern", "bootable"}, words[0]) {
+		return strings.Join(words, " ")
+	}
+	var result []string
+	for _, word := range words {
+		result = append(result, splitOperator(word)...)
+	}
+	return strings.Join(result, " ")
+}
+
+// splitOperator splits a word like "a+=2" into "a", "+=" and "2". Words with strings, brackets or
+// parentheses are not split, since the operators may be a part of an expression, like in [di+321].
+func splitOperator(word string) []string {
+	if strings.ContainsAny(word, "\"'[]()") || has(operatorsBySize, word) {
+		return []string{word}
+	}
+	for i := 1; i < len(word)-1; i++ {
+		for _, op := range operatorsBySize {
+			if strings.HasPrefix(word[i:], op) && (i+len(op) < len(word)) {
+				return append([]string{word[:i], op}, splitOperator(word[i+len(op):])...)
+			}
+		}
+	}
+	return []string{word}
+}
@@ -0,0 +1,17 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	source := "fun main\nrax=2 // two\n  loop 3\nrbx+=rax   // add\n            // more\nend\n\n\ninline_c\n  int x =  2;  \nend\nret\n"
+	expected := "fun main\n    rax = 2        // two\n    loop 3\n        rbx += rax // add\n                   // more\n    end\n\n    inline_c\n  int x =  2;  \n    end\n    ret\n"
+	formatted := Format(source)
+	if formatted != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n", expected, formatted)
+	}
+	if Format(formatted) != formatted {
+		t.Errorf("Formatting twice changed the source code:\n%s\n", Format(formatted))
+	}
+}
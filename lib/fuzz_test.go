@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// addSamples adds the samples as seeds for fuzzing
+func addSamples(f *testing.F) {
+	for _, samples := range goldenSamples {
+		filenames, err := filepath.Glob(filepath.Join("..", samples.dir, "*.bts"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, filename := range filenames {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(data))
+		}
+	}
+}
+
+// quiet turns off the log output while fuzzing, and returns a function that turns it back on
+func quiet() func() {
+	log.SetOutput(ioutil.Discard)
+	return func() {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// tokenize is like Tokenize, but returns errors in the source code instead of panicking
+func tokenize(config *TargetConfig, source string) (tokens []Token, err error) {
+	defer recoverCompileError(&err, "")
+	return config.Tokenize(source, " "), nil
+}
+
+// FuzzTokenize checks that the tokenizer either returns tokens or an error in the source code, but never panics
+func FuzzTokenize(f *testing.F) {
+	addSamples(f)
+	defer quiet()()
+	f.Fuzz(func(t *testing.T, source string) {
+		for _, bits := range []int{16, 32, 64} {
+			config, err := NewTargetConfig(bits, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokenize(config, source)
+		}
+	})
+}
+
+// FuzzCompile checks that compiling either gives assembly code or an error in the source code, but never panics
+func FuzzCompile(f *testing.F) {
+	addSamples(f)
+	defer quiet()()
+	f.Fuzz(func(t *testing.T, source string) {
+		for _, bits := range []int{16, 32, 64} {
+			config, err := NewTargetConfig(bits, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Compile(source, "fuzz.bts", nil, false, NewProgramState())
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the .golden files in testdata/golden")
+
+// goldenSamples are the directories with samples, and the platforms each sample is compiled for
+var goldenSamples = []struct {
+	dir  string
+	bits []int
+}{
+	{"samples", []int{16, 32, 64}},
+	{"samples16", []int{16}},
+	{"samples32", []int{32}},
+	{"samples64", []int{64}},
+}
+
+// cSeparator is placed between the assembly and the C code in the .golden files.
+// The code of the other inline languages follows, with a separator of its own.
+const cSeparator = "// --- inline C ---\n"
+
+// compileSample compiles a sample for the given platform.
+// Returns the generated code, or the error message if the sample could not be compiled.
+func compileSample(filename string, bits int) (string, bool) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err.Error() + "\n", false
+	}
+	config, err := NewTargetConfig(bits, false, false)
+	if err != nil {
+		return err.Error() + "\n", false
+	}
+	asmcode, ccode, inline, _, err := config.Compile(string(data), filename, nil, false, NewProgramState())
+	if err != nil {
+		return err.Error() + "\n", false
+	}
+	output := asmcode
+	if ccode != "" {
+		output += "\n" + cSeparator + ccode
+	}
+	for _, language := range InlineLanguages() {
+		if code, ok := inline[language.Name]; ok {
+			output += "\n" + language.Comment + " --- inline " + language.Name + " ---\n" + code
+		}
+	}
+	return output, true
+}
+
+// TestGolden compiles every sample and compares the generated code with the .golden files in testdata/golden.
+// The samples named shouldfail*.bts must fail, and their .golden files contain the error message.
+// Run "go test -run TestGolden -update" to write new .golden files.
+func TestGolden(t *testing.T) {
+	defer quiet()()
+	for _, samples := range goldenSamples {
+		filenames, err := filepath.Glob(filepath.Join("..", samples.dir, "*.bts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, filename := range filenames {
+			for _, bits := range samples.bits {
+				name := filepath.Base(filename)
+				goldenFilename := filepath.Join("testdata", "golden", samples.dir, name+"."+strconv.Itoa(bits)+".golden")
+				shouldFail := strings.HasPrefix(name, "shouldfail")
+				t.Run(samples.dir+"/"+name+"/"+strconv.Itoa(bits), func(t *testing.T) {
+					output, ok := compileSample(filename, bits)
+					if ok && shouldFail {
+						t.Errorf("%s should fail to compile for %d-bit", filename, bits)
+					} else if !ok && !shouldFail {
+						t.Errorf("%s failed to compile for %d-bit:\n%s", filename, bits, output)
+					}
+					if *update {
+						if err := os.MkdirAll(filepath.Dir(goldenFilename), 0755); err != nil {
+							t.Fatal(err)
+						}
+						if err := ioutil.WriteFile(goldenFilename, []byte(output), 0644); err != nil {
+							t.Fatal(err)
+						}
+						return
+					}
+					expected, err := ioutil.ReadFile(goldenFilename)
+					if err != nil {
+						t.Fatalf("%v (run with -update to create it)", err)
+					}
+					if output != string(expected) {
+						t.Errorf("The output for %s (%d-bit) differs from %s:\n%s", filename, bits, goldenFilename, firstDifference(string(expected), output))
+					}
+				})
+			}
+		}
+	}
+}
+
+// firstDifference shows the first lines that differ, for the test output
+func firstDifference(expected, got string) string {
+	a, b := strings.Split(expected, "\n"), strings.Split(got, "\n")
+	for i := 0; (i < len(a)) || (i < len(b)); i++ {
+		if (i < len(a)) && (i < len(b)) && (a[i] == b[i]) {
+			continue
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "first difference at line %d\n", i+1)
+		for j := i; (j < i+5) && (j < len(a)); j++ {
+			sb.WriteString("- " + a[j] + "\n")
+		}
+		for j := i; (j < i+5) && (j < len(b)); j++ {
+			sb.WriteString("+ " + b[j] + "\n")
+		}
+		return sb.String()
+	}
+	return ""
+}
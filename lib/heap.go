@@ -0,0 +1,201 @@
+package lib
+
+// The routines that alloc and free call, for Linux on 64-bit x86.
+// The size is stored in the 16 bytes before the memory, so that free knows how much to unmap.
+const heapLinux64 = `
+_alloc:					; rax = the address of rax bytes of memory, or 0
+	push rdi
+	push rsi
+	push rdx
+	push rcx
+	push r8
+	push r9
+	push r10
+	push r11
+	lea rsi, [rax+16]		; room for the size, before the memory
+	xor edi, edi			; at any address
+	mov edx, 3			; PROT_READ | PROT_WRITE
+	mov r10d, 0x22			; MAP_PRIVATE | MAP_ANONYMOUS
+	mov r8, -1			; no file
+	xor r9d, r9d			; no offset
+	mov eax, 9			; mmap
+	syscall
+	cmp rax, -4096
+	jbe _alloc_ok			; the error codes are from -4095 to -1
+	xor eax, eax
+	jmp _alloc_done
+_alloc_ok:
+	mov [rax], rsi			; store the size
+	add rax, 16
+_alloc_done:
+	pop r11
+	pop r10
+	pop r9
+	pop r8
+	pop rcx
+	pop rdx
+	pop rsi
+	pop rdi
+	ret
+
+_free:					; free the memory at rax, that was returned by _alloc
+	test rax, rax
+	jz _free_done
+	push rax
+	push rdi
+	push rsi
+	push rcx
+	push r11
+	lea rdi, [rax-16]
+	mov rsi, [rdi]			; the stored size
+	mov eax, 11			; munmap
+	syscall
+	pop r11
+	pop rcx
+	pop rsi
+	pop rdi
+	pop rax
+_free_done:
+	ret
+`
+
+// The routines that alloc and free call, for Linux on 32-bit x86
+const heapLinux32 = `
+_alloc:					; eax = the address of eax bytes of memory, or 0
+	push ebx
+	push ecx
+	push edx
+	push esi
+	push edi
+	push ebp
+	lea ecx, [eax+16]		; room for the size, before the memory
+	xor ebx, ebx			; at any address
+	mov edx, 3			; PROT_READ | PROT_WRITE
+	mov esi, 0x22			; MAP_PRIVATE | MAP_ANONYMOUS
+	mov edi, -1			; no file
+	xor ebp, ebp			; no offset
+	mov eax, 192			; mmap2
+	int 0x80
+	cmp eax, -4096
+	jbe _alloc_ok			; the error codes are from -4095 to -1
+	xor eax, eax
+	jmp _alloc_done
+_alloc_ok:
+	mov [eax], ecx			; store the size
+	add eax, 16
+_alloc_done:
+	pop ebp
+	pop edi
+	pop esi
+	pop edx
+	pop ecx
+	pop ebx
+	ret
+
+_free:					; free the memory at eax, that was returned by _alloc
+	test eax, eax
+	jz _free_done
+	push eax
+	push ebx
+	push ecx
+	lea ebx, [eax-16]
+	mov ecx, [ebx]			; the stored size
+	mov eax, 91			; munmap
+	int 0x80
+	pop ecx
+	pop ebx
+	pop eax
+_free_done:
+	ret
+`
+
+// The routines that alloc and free call, for DOS. The memory is given as a segment.
+const heapDOS = `
+_alloc:					; ax = the segment of ax bytes of memory, or 0
+	push bx
+	push es
+	push ax
+	mov bx, cs
+	mov es, bx
+	mov bx, 0x1000			; shrink the program to 64 KiB, so that DOS has memory to give
+	mov ah, 0x4a
+	int 0x21
+	pop bx
+	add bx, 15
+	rcr bx, 1			; round up to paragraphs of 16 bytes
+	shr bx, 3
+	mov ah, 0x48			; allocate memory
+	int 0x21
+	jnc _alloc_done
+	xor ax, ax
+_alloc_done:
+	pop es
+	pop bx
+	ret
+
+_free:					; free the segment in ax, that was returned by _alloc
+	test ax, ax
+	jz _free_done
+	push ax
+	push es
+	mov es, ax
+	mov ah, 0x49			; free memory
+	int 0x21
+	pop es
+	pop ax
+_free_done:
+	ret
+`
+
+// heapRoutines returns the routines that alloc and free call, for the current platform
+func (config *TargetConfig) heapRoutines() string {
+	if config.BootableKernel || config.BootSector || config.macOS {
+		fatal("Error: alloc and free are only available for Linux and DOS")
+	}
+	routines := map[int]string{16: heapDOS, 32: heapLinux32, 64: heapLinux64}[config.PlatformBits]
+	return "\n\t;--- memory allocation ---" + routines
+}
+
+// emitAlloc allocates memory and places the address in the given register, or 0 if there is no memory
+// left, like: rbx = alloc(4096). On 16-bit, the register is given the segment of the memory instead.
+func emitAlloc(config *TargetConfig, ps *ProgramState, st Statement) string {
+	reg, size := st[0].Value, st[3].Value
+	if registerBits(reg) != config.PlatformBits {
+		fatal("Error: The address from alloc needs a", config.PlatformBits, "bit register, not", reg)
+	}
+	if (st[3].T == REGISTER) && (registerBits(size) != config.PlatformBits) {
+		fatal("Error: The size for alloc must be a number or a", config.PlatformBits, "bit register, not", size)
+	}
+	ps.heapNeeded = true
+	a := config.nativeRegister("ax")
+	asmcode := ""
+	if reg != a {
+		asmcode += "\tpush " + a + "\t\t\t\t; save " + a + "\n"
+	}
+	if size != a {
+		asmcode += "\tmov " + a + ", " + size + "\t\t\t; the number of bytes\n"
+	}
+	asmcode += "\tcall _alloc\t\t\t; " + tokensString(st) + "\n"
+	if reg != a {
+		asmcode += "\tmov " + reg + ", " + a + "\n"
+		asmcode += "\tpop " + a + "\t\t\t\t; restore " + a + "\n"
+	}
+	return asmcode
+}
+
+// emitFree frees memory that was returned by alloc, like: free(rbx)
+func emitFree(config *TargetConfig, ps *ProgramState, st Statement) string {
+	reg := st[1].Value
+	if registerBits(reg) != config.PlatformBits {
+		fatal("Error: free needs the", config.PlatformBits, "bit register with the address from alloc, not", reg)
+	}
+	ps.heapNeeded = true
+	a := config.nativeRegister("ax")
+	if reg == a {
+		return "\tcall _free\t\t\t; " + tokensString(st) + "\n"
+	}
+	asmcode := "\tpush " + a + "\t\t\t\t; save " + a + "\n"
+	asmcode += "\tmov " + a + ", " + reg + "\n"
+	asmcode += "\tcall _free\t\t\t; " + tokensString(st) + "\n"
+	return asmcode + "\tpop " + a + "\t\t\t\t; restore " + a + "\n"
+}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterpretAlloc(t *testing.T) {
+	defer quiet()()
+	testExitCodes(t, "", []exitCodeTest{
+		{"alloc and free", "rbx = alloc(4096)\nmembyte rbx = 42\nrcx = readbyte rbx\nfree(rbx)\nexit(rcx)", 42},
+		{"alloc with a register", "rsi = 100\nrbx = alloc(rsi)\nmembyte rbx = 7\nrcx = readbyte rbx\nfree(rbx)\nexit(rcx)", 7},
+		{"separate allocations", "rbx = alloc(16)\nrsi = alloc(16)\nmembyte rbx = 1\nmembyte rsi = 2\nrcx = readbyte rbx\nrdx = readbyte rsi\nrdx += rdx\nrcx += rdx\nfree(rsi)\nfree(rbx)\nexit(rcx)", 5},
+		{"free 0", "rbx = 0\nfree(rbx)\nrcx = 3\nexit(rcx)", 3},
+	})
+}
+
+func TestInterpretUseAfterFree(t *testing.T) {
+	defer quiet()()
+	source := "fun main\n    rbx = alloc(4096)\n    free(rbx)\n    membyte rbx = 42\nend\n"
+	for _, bits := range []int{32, 64} {
+		code := source
+		if bits == 32 {
+			code = strings.Replace(source, "rbx", "ebx", -1)
+		}
+		if _, err := InterpretProgram(code, "free.bts", bits, nil, nil, nil, nil); (err == nil) || !strings.Contains(err.Error(), "segmentation fault") {
+			t.Errorf("%d-bit: expected a segmentation fault when writing to freed memory, got %v", bits, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// InlineLanguage is a compiled language that can be written in blocks between "inline_<name>" and "end",
+// like C can be written between "inline_c" and "end". The code of all the blocks is written to a file of its own,
+// which is compiled to an object file and linked with the program. Functions are called in both directions
+// with the C calling convention, so the language must be able to export and call C functions.
+type InlineLanguage struct {
+	Name      string // the name in the keyword that starts a block, like "zig" for "inline_zig"
+	Extension string // the extension of the file with the code, like ".zig"
+	Comment   string // what a line comment starts with, for the first line of the file
+	Braces    bool   // braces are matched, and strings and comments are skipped, when looking for the "end" of a block
+
+	// Command returns the shell command that compiles the file with the code to an object file, for the given platform
+	Command func(src, obj string, platformBits int) string
+
+	// Exports returns the names of the functions that the given code exports, which can then be called
+	// from the Battlestar code without declaring them with "extern". May be nil.
+	Exports func(code string) []string
+}
+
+// InlineBlock is a block of assembly, or of code in one of the inline languages, between "inline_<name>" and "end".
+// The lines count from 0. Inline C is found by CBlocks instead.
+type InlineBlock struct {
+	Language string   // "asm", or the name of an inline language
+	Bits     string   // for "inline_asm", the platform bits the assembly is for, like "64", or empty for all platforms
+	Start    int      // the "inline_<name>" line
+	End      int      // the "end" line
+	Code     []string // the lines in between, as they are in the source code
+}
+
+// inlineLanguages are the languages that can be used in addition to C and assembly, in the order they were registered
+var inlineLanguages []*InlineLanguage
+
+func init() {
+	zig := &InlineLanguage{Name: "zig", Extension: ".zig", Comment: "//", Braces: true}
+	zig.Command = func(src, obj string, platformBits int) string {
+		target := "x86_64-linux-none"
+		if platformBits == 32 {
+			target = "x86-linux-none"
+		}
+		return "zig build-obj -O ReleaseSmall -fno-PIC -target " + target + " -femit-bin=" + obj + " " + src
+	}
+	zig.Exports = func(code string) []string {
+		// Functions like: export fn add(a: c_int, b: c_int) c_int {
+		var names []string
+		for _, line := range strings.Split(code, "\n") {
+			words := strings.Fields(strings.Replace(line, "(", " (", 1))
+			if (len(words) > 2) && (words[0] == "export") && (words[1] == "fn") && cIdentifier(words[2]) {
+				names = append(names, words[2])
+			}
+		}
+		return names
+	}
+	if err := RegisterInlineLanguage(zig); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterInlineLanguage makes it possible to use the given language in blocks of "inline_<name>"
+func RegisterInlineLanguage(language *InlineLanguage) error {
+	if !validName(language.Name) || (language.Name == "c") || (language.Name == "asm") {
+		return errors.New("invalid name for an inline language: " + language.Name)
+	}
+	if inlineLanguage(language.Keyword()) != nil {
+		return errors.New("the inline language is already registered: " + language.Name)
+	}
+	if (language.Extension == "") || (language.Command == nil) {
+		return errors.New("the inline language needs a file extension and a command: " + language.Name)
+	}
+	inlineLanguages = append(inlineLanguages, language)
+	return nil
+}
+
+// InlineLanguages returns the languages that can be used in addition to C and assembly
+func InlineLanguages() []*InlineLanguage {
+	return append([]*InlineLanguage{}, inlineLanguages...)
+}
+
+// Keyword returns the keyword that starts a block of the language, like "inline_zig"
+func (language *InlineLanguage) Keyword() string {
+	return "inline_" + language.Name
+}
+
+// inlineLanguage returns the inline language that is started by the given keyword, or nil
+func inlineLanguage(keyword string) *InlineLanguage {
+	for _, language := range inlineLanguages {
+		if language.Keyword() == keyword {
+			return language
+		}
+	}
+	return nil
+}
+
+// inlineKeyword checks if the given word starts a block of inline C, inline assembly or another inline language
+func inlineKeyword(word string) bool {
+	return (word == "inline_c") || (word == "inline_asm") || (inlineLanguage(word) != nil)
+}
+
+// InlineBlocks finds the blocks of inline assembly and the blocks of the inline languages in the given lines
+// of source code. Inline C is skipped. A block that is not ended continues to the end of the source code.
+func InlineBlocks(lines []string) []InlineBlock {
+	var blocks []InlineBlock
+	inC := make([]bool, len(lines))
+	for _, block := range CBlocks(lines) {
+		for i := block.Start; i <= block.End; i++ {
+			inC[i] = true
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		words := strings.Fields(removecomments(strings.TrimSpace(lines[i])))
+		if inC[i] || (len(words) == 0) {
+			continue
+		}
+		block := InlineBlock{Start: i, End: len(lines) - 1}
+		braces := false
+		if words[0] == "inline_asm" {
+			block.Language = "asm"
+			if len(words) > 1 {
+				block.Bits = words[1]
+			}
+		} else if language := inlineLanguage(words[0]); language != nil {
+			block.Language, braces = language.Name, language.Braces
+		} else {
+			continue
+		}
+		var s cScanner
+		for j := i + 1; j < len(lines); j++ {
+			if (!braces || ((s.depth <= 0) && !s.comment)) && (strings.TrimSpace(removecomments(lines[j])) == "end") {
+				block.End = j
+				break
+			}
+			if braces {
+				s.scan(lines[j])
+			}
+			block.Code = append(block.Code, lines[j])
+		}
+		blocks = append(blocks, block)
+		i = block.End
+	}
+	return blocks
+}
+
+// inlineLines returns, for each of the given lines, if it is a part of a block of inline C, inline assembly
+// or another inline language, including the lines that start and end the block
+func inlineLines(lines []string) []bool {
+	inline := make([]bool, len(lines))
+	for _, block := range CBlocks(lines) {
+		for i := block.Start; i <= block.End; i++ {
+			inline[i] = true
+		}
+	}
+	for _, block := range InlineBlocks(lines) {
+		for i := block.Start; i <= block.End; i++ {
+			inline[i] = true
+		}
+	}
+	return inline
+}
+
+// ExtractInline returns the code of all the blocks of the given inline language,
+// or an empty string if the language is not used
+func ExtractInline(source string, language *InlineLanguage) string {
+	code := ""
+	for _, block := range InlineBlocks(strings.Split(source, "\n")) {
+		if block.Language == language.Name {
+			code += unindent(block.Code)
+		}
+	}
+	return code
+}
+
+// inlineExports returns the names of the functions that are exported by the code of the inline languages
+func inlineExports(lines []string) []string {
+	var names []string
+	for _, block := range InlineBlocks(lines) {
+		if language := inlineLanguage("inline_" + block.Language); (language != nil) && (language.Exports != nil) {
+			names = append(names, language.Exports(strings.Join(block.Code, "\n"))...)
+		}
+	}
+	return names
+}
+
+// InlineFlags returns the commands that compile the code of the given inline languages, and the object files
+// that are then linked with the program, as lines that can be sourced by a shell script, like LibraryFlags.
+// The code of each language is in a file that is named prefix followed by the extension of the language.
+func InlineFlags(languages []*InlineLanguage, prefix string, platformBits int) string {
+	var names, commands, objects []string
+	for _, language := range languages {
+		obj := prefix + "_" + language.Name + ".o"
+		names = append(names, language.Name)
+		commands = append(commands, language.Command(prefix+language.Extension, obj, platformBits))
+		objects = append(objects, obj)
+	}
+	return fmt.Sprintf("# Inline languages: %s\nBTS_INLINE=\"%s\"\nBTS_OBJECTS=\"%s\"\n", strings.Join(names, " "), strings.Join(commands, " && "), strings.Join(objects, " "))
+}
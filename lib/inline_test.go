@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineBlocks(t *testing.T) {
+	defer quiet()()
+	source := `fun main
+    inline_asm 64
+        mov rcx, 10
+    .again:
+        dec rcx
+        jnz .again
+    end
+    inline_asm 32
+        xor eax, eax
+    end
+    add(1, 2)
+end
+
+inline_zig
+    export fn add(a: c_int, b: c_int) c_int {
+        if (a > b) { return a; } // end
+        return a + b;
+    }
+end
+`
+	blocks := InlineBlocks(strings.Split(source, "\n"))
+	if (len(blocks) != 3) || (blocks[0].Bits != "64") || (blocks[0].End != 6) || (blocks[2].Language != "zig") || (blocks[2].End != 18) {
+		t.Fatalf("expected two blocks of assembly and one of Zig, got: %v", blocks)
+	}
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, _, inline, _, err := config.Compile(source, "inline.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"extern add", "    .again:\n        dec rcx\n", "call add"} {
+		if !strings.Contains(asmcode, expected) {
+			t.Errorf("expected %q in the assembly:\n%s", expected, asmcode)
+		}
+	}
+	if strings.Contains(asmcode, "xor eax, eax") {
+		t.Errorf("expected the 32-bit assembly to be left out:\n%s", asmcode)
+	}
+	if code := inline["zig"]; !strings.HasPrefix(code, "export fn add(") {
+		t.Errorf("expected the Zig code to be returned, got:\n%s", code)
+	}
+}
+
+func TestRegisterInlineLanguage(t *testing.T) {
+	command := func(src, obj string, platformBits int) string { return "cc -c -o " + obj + " " + src }
+	for _, language := range []*InlineLanguage{
+		{Name: "zig", Extension: ".zig", Command: command},
+		{Name: "c", Extension: ".c", Command: command},
+		{Name: "asm", Extension: ".s", Command: command},
+		{Name: "not valid", Extension: ".x", Command: command},
+		{Name: "nim", Extension: ".nim"},
+		{Name: "nim", Command: command},
+	} {
+		if err := RegisterInlineLanguage(language); err == nil {
+			t.Errorf("expected an error when registering %q", language.Name)
+		}
+	}
+	if len(InlineLanguages()) != 1 {
+		t.Errorf("expected only Zig to be registered, got %d languages", len(InlineLanguages()))
+	}
+}
@@ -0,0 +1,445 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// The words that a C function definition or declaration can start with, before the name of the function
+var cTypeWords = []string{"void", "char", "short", "int", "long", "float", "double", "signed", "unsigned", "_Bool", "bool", "static", "inline", "extern", "const", "volatile", "register", "struct", "union", "enum"}
+
+// CBlock is a block of inline C in Battlestar source code. The lines count from 0.
+// A block is either C code between "inline_c" and "end", or a C function, like "int add(int a, int b) { ... }",
+// or a C declaration on a line of its own, like "void hi(char *msg, int len);".
+type CBlock struct {
+	Start     int      // the first line of the block, which is "inline_c" if Delimited is true
+	End       int      // the last line of the block, which is "end" if Delimited is true
+	CodeStart int      // the first line of the C code
+	Code      []string // the lines of C code, as they are in the source code
+	Delimited bool     // started with "inline_c" and ended with "end"
+}
+
+// cScanner keeps track of the braces in C code, one line at a time,
+// while skipping strings, character literals and comments
+type cScanner struct {
+	depth     int  // the number of { that are not closed
+	comment   bool // within a /* */ comment
+	braces    bool // a { has been found
+	semicolon bool // a ; has been found outside of any braces
+}
+
+// scan goes through a line of C code, and returns the code without the comments
+// and with the contents of strings and character literals left out
+func (s *cScanner) scan(line string) string {
+	if !s.comment && strings.HasPrefix(strings.TrimSpace(line), "#") {
+		// A preprocessor directive
+		return ""
+	}
+	var code []byte
+	for i := 0; i < len(line); i++ {
+		if s.comment {
+			if strings.HasPrefix(line[i:], "*/") {
+				s.comment = false
+				i++
+			}
+			continue
+		}
+		switch line[i] {
+		case '/':
+			if strings.HasPrefix(line[i:], "//") {
+				return string(code)
+			}
+			if strings.HasPrefix(line[i:], "/*") {
+				s.comment = true
+				i++
+				code = append(code, ' ')
+				continue
+			}
+		case '"', '\'':
+			// Skip the string or character literal, and the escaped characters within it
+			quote := line[i]
+			for i++; (i < len(line)) && (line[i] != quote); i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			code = append(code, quote, quote)
+			continue
+		case '{':
+			s.depth++
+			s.braces = true
+		case '}':
+			s.depth--
+		case ';':
+			if s.depth == 0 {
+				s.semicolon = true
+			}
+		}
+		code = append(code, line[i])
+	}
+	return string(code)
+}
+
+// cFunctionStart checks if the line starts a C function definition or declaration, like "static int *f(int a) {".
+// The name is preceded by words like "int" or "static", type names that end with "_t" or a struct, union or enum.
+func cFunctionStart(line string) bool {
+	line = strings.TrimSpace(line)
+	if pos := strings.Index(line, "//"); pos != -1 {
+		line = line[:pos]
+	}
+	pos := strings.Index(line, "(")
+	if pos == -1 {
+		return false
+	}
+	words := strings.Fields(strings.Replace(line[:pos], "*", " ", -1))
+	if len(words) < 2 {
+		return false
+	}
+	for i, word := range words {
+		if !cIdentifier(word) {
+			return false
+		}
+		if i == len(words)-1 {
+			// The name of the function
+			break
+		}
+		named := (i > 0) && has([]string{"struct", "union", "enum"}, words[i-1])
+		if !has(cTypeWords, word) && !strings.HasSuffix(word, "_t") && !named {
+			return false
+		}
+	}
+	return true
+}
+
+// cIdentifier checks if the word is a valid name in C
+func cIdentifier(word string) bool {
+	for i, r := range word {
+		if !((r == '_') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// CBlocks finds the blocks of inline C in the given lines of source code. The braces of C functions are matched,
+// so that a function ends at the } that closes it, and "end" only ends an "inline_c" block if it is not within
+// braces or a comment. A block that is not ended continues to the end of the source code.
+func CBlocks(lines []string) []CBlock {
+	var blocks []CBlock
+	for i := 0; i < len(lines); i++ {
+		code := strings.TrimSpace(removecomments(lines[i]))
+		words := strings.Fields(code)
+		if len(words) == 0 {
+			continue
+		}
+		var block CBlock
+		var s cScanner
+		switch {
+		case words[0] == "inline_c":
+			block = CBlock{Start: i, End: len(lines) - 1, CodeStart: i + 1, Delimited: true}
+			for j := i + 1; j < len(lines); j++ {
+				if (s.depth <= 0) && !s.comment && (strings.TrimSpace(removecomments(lines[j])) == "end") {
+					block.End = j
+					break
+				}
+				s.scan(lines[j])
+				block.Code = append(block.Code, lines[j])
+			}
+		case cFunctionStart(code):
+			block = CBlock{Start: i, End: len(lines) - 1, CodeStart: i}
+			for j := i; j < len(lines); j++ {
+				s.scan(lines[j])
+				block.Code = append(block.Code, lines[j])
+				if (s.braces && (s.depth <= 0)) || (!s.braces && s.semicolon) {
+					block.End = j
+					break
+				}
+			}
+		default:
+			continue
+		}
+		blocks = append(blocks, block)
+		i = block.End
+	}
+	return blocks
+}
+
+// CFunction is the signature of a C function that is defined or declared at the top level of the inline C
+type CFunction struct {
+	Name     string
+	Return   string   // the return type, like "int" or "char*"
+	Params   []string // the types of the parameters, without the parameter names
+	Variadic bool     // the last parameter is "..."
+	Defined  bool     // the function has a body, and is not only declared
+	Static   bool     // the function can not be called from outside of the C code
+	Line     int      // the line where the signature starts, counting from 0
+}
+
+// inline16 returns an error for the first block of inline C, or of another inline language, in the given source
+// code, since gcc can not build 16-bit x86 code, and the other inline languages are linked like inline C
+func inline16(source, filename string) error {
+	lines := strings.Split(source, "\n")
+	if blocks := CBlocks(lines); len(blocks) > 0 {
+		return &CompileError{"Inline C is not supported for 16-bit, since gcc can not build 16-bit x86 code", filename, blocks[0].Start + 1}
+	}
+	for _, block := range InlineBlocks(lines) {
+		if block.Language != "asm" {
+			return &CompileError{"Inline " + block.Language + " is not supported for 16-bit, since it is linked like inline C", filename, block.Start + 1}
+		}
+	}
+	return nil
+}
+
+// CFunctions finds the signatures of the C functions that are defined or declared in the given lines of source code.
+// Declarations within the bodies of C functions, like "extern void hi(char *msg);", are also found.
+func CFunctions(lines []string) []CFunction {
+	var functions []CFunction
+	for _, block := range CBlocks(lines) {
+		var (
+			s         cScanner
+			signature string
+			start     int
+		)
+		for i, line := range block.Code {
+			for _, c := range []byte(s.scan(line)) {
+				switch c {
+				case '{', ';':
+					if f, ok := parseCSignature(signature); ok {
+						f.Defined, f.Line = (c == '{'), start
+						functions = append(functions, f)
+					}
+					signature = ""
+				case '}':
+					signature = ""
+				default:
+					if strings.TrimSpace(signature) == "" {
+						start = block.CodeStart + i
+					}
+					signature += string(c)
+				}
+			}
+			signature += " "
+		}
+	}
+	return functions
+}
+
+// parseCSignature parses the signature of a C function, like "static int add(int a, int b)"
+func parseCSignature(signature string) (CFunction, bool) {
+	signature = strings.TrimSpace(signature)
+	open := strings.Index(signature, "(")
+	if (open == -1) || !strings.HasSuffix(signature, ")") || !cFunctionStart(signature) {
+		return CFunction{}, false
+	}
+	words := strings.Fields(strings.Replace(signature[:open], "*", " * ", -1))
+	f := CFunction{Name: words[len(words)-1]}
+	if has(cTypeWords, f.Name) {
+		// A function pointer, like "void (*handler)(int)"
+		return CFunction{}, false
+	}
+	var returnType []string
+	for _, word := range words[:len(words)-1] {
+		switch word {
+		case "static":
+			f.Static = true
+		case "inline", "extern":
+		default:
+			returnType = append(returnType, word)
+		}
+	}
+	f.Return = strings.Replace(strings.Join(returnType, " "), " *", "*", -1)
+	params := cSplitArguments(signature[open+1 : len(signature)-1])
+	if (len(params) == 1) && (params[0] == "void") {
+		return f, true
+	}
+	for _, param := range params {
+		if param == "..." {
+			f.Variadic = true
+			break
+		}
+		f.Params = append(f.Params, cParameterType(param))
+	}
+	return f, true
+}
+
+// cSplitArguments splits the parameters of a C function signature, or the arguments of a call,
+// at the commas that are not within parentheses. Each part is trimmed.
+func cSplitArguments(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var parts []string
+	depth, last := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[last:i]))
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(s[last:]))
+}
+
+// cParameterType returns the type of a C function parameter, like "char*" for "char *msg" or "int*" for "int a[]"
+func cParameterType(param string) string {
+	if strings.Contains(param, "(") {
+		// A function pointer
+		return param
+	}
+	array := false
+	if pos := strings.Index(param, "["); pos != -1 {
+		param, array = param[:pos], true
+	}
+	words := strings.Fields(strings.Replace(param, "*", " * ", -1))
+	if n := len(words); n > 1 {
+		last, named := words[n-1], has([]string{"struct", "union", "enum"}, words[n-2])
+		if cIdentifier(last) && !has(cTypeWords, last) && !strings.HasSuffix(last, "_t") && !named {
+			// Leave out the name of the parameter
+			words = words[:n-1]
+		}
+	}
+	typ := strings.Replace(strings.Join(words, " "), " *", "*", -1)
+	if array {
+		typ += "*"
+	}
+	return typ
+}
+
+// cFloating checks if the given C type is a floating point number, which is not passed in the general purpose registers
+func cFloating(typ string) bool {
+	if strings.Contains(typ, "*") || strings.Contains(typ, "(") {
+		return false
+	}
+	for _, word := range strings.Fields(typ) {
+		if (word == "float") || (word == "double") {
+			return true
+		}
+	}
+	return false
+}
+
+// cCalls finds the names that are called as functions within the blocks of inline C, together with
+// the arguments of the first call. Declarations and definitions of C functions are included.
+func cCalls(lines []string) map[string][]string {
+	calls := make(map[string][]string)
+	for _, block := range CBlocks(lines) {
+		var s cScanner
+		code := ""
+		for _, line := range block.Code {
+			code += s.scan(line) + "\n"
+		}
+		for i := 1; i < len(code); i++ {
+			if code[i] != '(' {
+				continue
+			}
+			// Find the name before the parenthesis
+			end := len(strings.TrimRight(code[:i], " \t\n"))
+			start := end
+			for (start > 0) && cIdentifier(code[start-1:end]) {
+				start--
+			}
+			name := code[start:end]
+			if !cIdentifier(name) || has([]string{"if", "while", "for", "switch", "return", "sizeof"}, name) {
+				continue
+			}
+			if _, ok := calls[name]; ok {
+				continue
+			}
+			// Find the matching parenthesis
+			depth, closing := 0, -1
+			for j := i; (j < len(code)) && (closing == -1); j++ {
+				switch code[j] {
+				case '(':
+					depth++
+				case ')':
+					depth--
+					if depth == 0 {
+						closing = j
+					}
+				}
+			}
+			if closing != -1 {
+				calls[name] = cSplitArguments(code[i+1 : closing])
+			}
+		}
+	}
+	return calls
+}
+
+// checkArguments checks that a call with the given arguments fits the signature of the C function
+func (f CFunction) checkArguments(args []Token) {
+	switch {
+	case f.Variadic && (len(args) < len(f.Params)):
+		fatalf("Error: The C function %s takes at least %d arguments, not %d", f.Name, len(f.Params), len(args))
+	case !f.Variadic && (len(args) != len(f.Params)):
+		fatalf("Error: The C function %s takes %d arguments, not %d", f.Name, len(f.Params), len(args))
+	}
+	for i, param := range f.Params {
+		if cFloating(param) {
+			fatalf("Error: Argument #%d to the C function %s is a %s, which can not be passed in a general purpose register", i, f.Name, param)
+		}
+	}
+}
+
+// AddInlineCExterns declares the C functions in the inline C that are called from the Battlestar code as
+// external symbols, unless they are already declared with "extern". Static C functions are left out, since
+// they can not be called from the outside. The defined function is used for checking if a name is defined elsewhere.
+func (config *TargetConfig) AddInlineCExterns(tokens []Token, functions []CFunction, defined func(string) bool) []Token {
+	var externs []Token
+	cNames := make(map[string]bool)
+	for _, f := range functions {
+		if !f.Static {
+			cNames[f.Name] = true
+		}
+	}
+	declared := externNames(tokens)
+	for _, called := range calledNames(tokens) {
+		if !cNames[called.Value] || has(declared, called.Value) || defined(called.Value) {
+			continue
+		}
+		declared = append(declared, called.Value)
+		externs = append(externs, Token{KEYWORD, "extern", called.Line, ""}, called, Token{SEP, ";", called.Line, ""})
+	}
+	return append(externs, tokens...)
+}
+
+// FunPrototypes returns C prototypes for the Battlestar functions that are called from the inline C,
+// but not declared there. The defined function is used for checking if a name is a Battlestar function.
+// The functions return the value of the a register. The parameters are strings if a string is given
+// in the first call, and integers that are as large as the registers if not.
+func FunPrototypes(lines []string, defined func(string) bool) string {
+	declared := make(map[string]bool)
+	for _, f := range CFunctions(lines) {
+		declared[f.Name] = true
+	}
+	var names []string
+	calls := cCalls(lines)
+	for name := range calls {
+		if !declared[name] && defined(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	prototypes := ""
+	for _, name := range names {
+		var params []string
+		for _, arg := range calls[name] {
+			if strings.HasPrefix(arg, "\"") {
+				params = append(params, "char*")
+			} else {
+				params = append(params, "long")
+			}
+		}
+		if len(params) == 0 {
+			params = []string{"void"}
+		}
+		prototypes += "long " + name + "(" + strings.Join(params, ", ") + ");\n"
+	}
+	return prototypes
+}
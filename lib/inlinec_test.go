@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCBlocks(t *testing.T) {
+	source := `fun hello
+    inline_c
+    int twice(int x) {
+        if (x > 100) { return x; } // }
+        return x * 2;
+    }
+    end
+end
+
+static int helper(int a) { return a + 1; }
+
+int add(int a, int b) {
+    const char *s = "}\"";  /* a } in a comment
+    end } */
+    char c = '}';
+    return helper(a) + b + (s[0] == c);
+}
+
+unsigned long *nothing(void);
+struct point origin(void) {
+}
+const x = 42
+int(0x80)`
+	expected := []CBlock{
+		{Start: 1, End: 6, CodeStart: 2, Delimited: true},
+		{Start: 9, End: 9, CodeStart: 9},
+		{Start: 11, End: 16, CodeStart: 11},
+		{Start: 18, End: 18, CodeStart: 18},
+		{Start: 19, End: 20, CodeStart: 19},
+	}
+	blocks := CBlocks(strings.Split(source, "\n"))
+	if len(blocks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d: %v", len(expected), len(blocks), blocks)
+	}
+	for i, block := range blocks {
+		e := expected[i]
+		if (block.Start != e.Start) || (block.End != e.End) || (block.CodeStart != e.CodeStart) || (block.Delimited != e.Delimited) {
+			t.Errorf("block %d: expected lines %d to %d, got %d to %d", i, e.Start, e.End, block.Start, block.End)
+		}
+	}
+	if len(blocks[0].Code) != 4 {
+		t.Errorf("expected 4 lines of C code in the inline_c block, got %d", len(blocks[0].Code))
+	}
+}
+
+func TestCFunctions(t *testing.T) {
+	source := `fun main
+    add(rax, 5)
+    show(msg)
+end
+
+inline_c
+static unsigned long *lookup(const char *key, size_t n) { return 0; }
+int printf(const char *fmt, ...);
+int add(int a, int b) {
+    extern void show(char s[]);
+    return a + b;
+}
+end`
+	functions := CFunctions(strings.Split(source, "\n"))
+	expected := []CFunction{
+		{Name: "lookup", Return: "unsigned long*", Params: []string{"const char*", "size_t"}, Defined: true, Static: true, Line: 6},
+		{Name: "printf", Return: "int", Params: []string{"const char*"}, Variadic: true, Line: 7},
+		{Name: "add", Return: "int", Params: []string{"int", "int"}, Defined: true, Line: 8},
+		{Name: "show", Return: "void", Params: []string{"char*"}, Line: 9},
+	}
+	if len(functions) != len(expected) {
+		t.Fatalf("expected %d functions, got %d: %v", len(expected), len(functions), functions)
+	}
+	for i, f := range functions {
+		if fmt.Sprint(f) != fmt.Sprint(expected[i]) {
+			t.Errorf("expected %v, got %v", expected[i], f)
+		}
+	}
+}
+
+func TestArgumentCall(t *testing.T) {
+	defer quiet()()
+	source := `fun greet
+end
+
+fun main
+    add(rsi, rdi)
+end
+
+inline_c
+int add(int a, int b) {
+    greet("hi", a);
+    return a + b;
+}
+end
+`
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, ccode, _, _, err := config.Compile(source, "call.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"extern add", "push rsi", "pop rdi", "call add"} {
+		if !strings.Contains(asmcode, expected) {
+			t.Errorf("expected %q in the assembly:\n%s", expected, asmcode)
+		}
+	}
+	if !strings.Contains(ccode, "long greet(char*, long);\n") {
+		t.Errorf("expected a prototype for greet in the C code:\n%s", ccode)
+	}
+	if _, _, _, _, err := config.Compile(strings.Replace(source, "add(rsi, rdi)", "add(rsi)", 1), "call.bts", nil, false, NewProgramState()); err == nil {
+		t.Error("expected an error when calling add with one argument")
+	}
+	_, _, _, _, err = config.Compile(strings.Replace(source, "add(rsi, rdi)", "nothere(rsi, rdi)", 1), "call.bts", nil, false, NewProgramState())
+	if (err == nil) || !strings.Contains(err.Error(), "Did you mean one of these?") {
+		t.Errorf("expected the nearest statement forms when calling a function that does not exist, got: %v", err)
+	}
+	asmcode, _, _, _, err = config.Compile(strings.Replace(source, "add(rsi, rdi)", "greet(msg)", 1)+"const msg = \"hi\"\n", "call.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(asmcode, "call greet") {
+		t.Errorf("expected a call to greet in the assembly:\n%s", asmcode)
+	}
+}
+
+func TestInline16(t *testing.T) {
+	defer quiet()()
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		source  string
+		message string
+		line    int
+	}{
+		{"fun main\n    ax = 1\nend\n\ninline_c\n    void hi() {}\nend\n", "Inline C is not supported for 16-bit", 5},
+		{"int twice(int x) {\n    return x * 2;\n}\n\nfun main\n    ax = 1\nend\n", "Inline C is not supported for 16-bit", 1},
+		{"fun main\n    ax = 1\nend\n\ninline_zig\n    export fn hi() void {}\nend\n", "Inline zig is not supported for 16-bit", 5},
+	}
+	for _, test := range tests {
+		_, _, _, _, err := config.Compile(test.source, "inline16.bts", nil, false, NewProgramState())
+		compileError, ok := err.(*CompileError)
+		if !ok || !strings.HasPrefix(compileError.Message, test.message) {
+			t.Errorf("expected %q, got %v", test.message, err)
+			continue
+		}
+		if (compileError.Filename != "inline16.bts") || (compileError.Line != test.line) {
+			t.Errorf("%s: expected inline16.bts, line %d, got %s, line %d", test.message, test.line, compileError.Filename, compileError.Line)
+		}
+	}
+	// Inline assembly is fine
+	if _, _, _, _, err := config.Compile("fun main\n    inline_asm\n        nop\n    end\nend\n", "asm.bts", nil, false, NewProgramState()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,1098 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The memory layout of a program that is interpreted, like for a linked executable.
+// Every instruction takes one byte of the address space for the code, instead of the size of its encoding.
+const (
+	textAddress   = 0x401000
+	dataAddress   = 0x600000
+	heapAddress   = 0x10000000
+	stackTop      = 0x7ff00000
+	stackSize     = 1 << 20
+	externAddress = 0x300000 // the external symbols, that can not be called
+	pageSize      = 4096
+)
+
+// The kinds of operands
+const (
+	operandRegister = iota
+	operandXMM
+	operandST
+	operandImmediate
+	operandMemory
+)
+
+// errExit is returned by the system calls that exit the program
+var errExit = errors.New("exit")
+
+type (
+	// Interpreter interprets the assembly code that Battlestar generates for 32-bit and 64-bit Linux, as an x86 CPU
+	// with the system calls that the generated programs use, without assembling and linking the code first.
+	// The assembly code is interpreted as text, instead of loading the linked ELF executable, so each instruction
+	// takes one byte of the address space for the code. The addresses and sizes of the data are like in the
+	// executable, but code that calculates with the addresses of instructions or their sizes behaves differently.
+	Interpreter struct {
+		Stdin    io.Reader
+		Stdout   io.Writer
+		Stderr   io.Writer
+		MaxSteps int // the maximum number of instructions to run, or 0 for no limit
+
+		bits   int
+		regs   [16]uint64
+		xmm    [16][2]uint64 // the two 64-bit lanes of each xmm register
+		fpu    []float64     // the x87 register stack, with st0 last
+		flags  struct{ cf, zf, sf, of, pf, df bool }
+		ip     int // the index of the next instruction
+		code   []*asmInstruction
+		exit   int // the exit code, when the program has exited
+		mapped []memoryRange
+		pages  map[uint64][]byte
+		heap   uint64 // where the next memory from mmap is placed
+		brk    uint64
+
+		// For loading the assembly code
+		section  string
+		sections map[string][]byte // the contents of the .data section and the size of the .bss section
+		bssSize  uint64
+		labels   map[string]symbolLocation
+		equs     map[string]*equ
+		externs  []string
+		fixups   []dataFixup
+	}
+
+	// asmInstruction is an instruction with its operands, and the line in the assembly code it came from
+	asmInstruction struct {
+		mnemonic string
+		prefix   string // rep, repe or repne
+		args     []string
+		operands []*asmOperand
+		index    int // the index of the instruction, which is also the offset of its address
+		line     int
+		text     string
+	}
+
+	// asmOperand is a register, an immediate value or a memory location, like [rbx+rsi*8+16]
+	asmOperand struct {
+		kind  int
+		reg   int   // the register number, or the index into the FPU stack
+		size  int   // the size in bytes, or 0 if it is not given for a memory location
+		high  bool  // for ah, bh, ch and dh
+		value int64 // the immediate value, or the displacement of a memory location
+		base  int   // the base register of a memory location, or -1
+		index int   // the index register of a memory location, or -1
+		scale int64
+	}
+
+	// memoryRange is memory that can be used by the program, from start up to end
+	memoryRange struct {
+		start, end uint64
+	}
+
+	// symbolLocation is where a label is defined
+	symbolLocation struct {
+		section string
+		offset  uint64
+	}
+
+	// equ is a constant that is defined with "equ", where $ is the given location
+	equ struct {
+		expression string
+		dollar     symbolLocation
+		evaluating bool
+	}
+
+	// dataFixup is a value in the .data section that is evaluated when all the labels are known
+	dataFixup struct {
+		offset     uint64
+		size       int
+		expression string
+		dollar     symbolLocation
+		line       int
+	}
+)
+
+// registerNumbers are the numbers of the 64-bit general purpose registers, as used in the instruction encoding
+var registerNumbers = map[string]int{"rax": 0, "rcx": 1, "rdx": 2, "rbx": 3, "rsp": 4, "rbp": 5, "rsi": 6, "rdi": 7}
+
+// NewInterpreter parses assembly code that was generated for 32-bit or 64-bit Linux, so that it can be interpreted
+func NewInterpreter(asmcode string, bits int) (*Interpreter, error) {
+	if (bits != 32) && (bits != 64) {
+		return nil, fmt.Errorf("only 32-bit and 64-bit programs can be interpreted, not %d-bit programs", bits)
+	}
+	m := &Interpreter{
+		bits:     bits,
+		pages:    make(map[uint64][]byte),
+		heap:     heapAddress,
+		section:  ".text",
+		sections: map[string][]byte{".data": nil},
+		labels:   make(map[string]symbolLocation),
+		equs:     make(map[string]*equ),
+		MaxSteps: 100000000,
+	}
+	for i, line := range strings.Split(asmcode, "\n") {
+		if err := m.loadLine(line, i+1); err != nil {
+			return nil, fmt.Errorf("line %d of the assembly: %s: %v", i+1, strings.TrimSpace(line), err)
+		}
+	}
+	bssStart := m.bssAddress()
+	m.mapped = append(m.mapped, memoryRange{dataAddress, bssStart + align(m.bssSize, pageSize)})
+	m.brk = bssStart + align(m.bssSize, pageSize)
+	m.mapped = append(m.mapped, memoryRange{stackTop - stackSize, stackTop})
+	for _, fixup := range m.fixups {
+		if err := m.fixup(fixup); err != nil {
+			return nil, fmt.Errorf("line %d of the assembly: %v", fixup.line, err)
+		}
+	}
+	for i, b := range m.sections[".data"] {
+		m.store(dataAddress+uint64(i), 1, uint64(b))
+	}
+	for _, inst := range m.code {
+		for _, arg := range inst.args {
+			op, err := m.operand(arg, inst)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of the assembly: %s: %v", inst.line, inst.text, err)
+			}
+			inst.operands = append(inst.operands, op)
+		}
+	}
+	return m, nil
+}
+
+// align rounds n up to the nearest multiple of a
+func align(n, a uint64) uint64 {
+	return (n + a - 1) / a * a
+}
+
+// stripComment removes the comment from a line of assembly code, if it is not within quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case (r == '"') || (r == '\'') || (r == '`'):
+			quote = r
+		case r == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitOperands splits the operands of an instruction or a data directive on the commas
+// that are not within quotes, brackets or parentheses
+func splitOperands(s string) []string {
+	var (
+		args  []string
+		quote rune
+		depth int
+		start int
+	)
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case (r == '"') || (r == '\'') || (r == '`'):
+			quote = r
+		case (r == '[') || (r == '('):
+			depth++
+		case (r == ']') || (r == ')'):
+			depth--
+		case (r == ',') && (depth == 0):
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(args) > 0 {
+		args = append(args, rest)
+	}
+	return args
+}
+
+// here returns the current location in the current section
+func (m *Interpreter) here() symbolLocation {
+	switch m.section {
+	case ".text":
+		return symbolLocation{".text", uint64(len(m.code))}
+	case ".bss":
+		return symbolLocation{".bss", m.bssSize}
+	}
+	return symbolLocation{".data", uint64(len(m.sections[".data"]))}
+}
+
+// loadLine loads one line of assembly code
+func (m *Interpreter) loadLine(line string, linenr int) error {
+	text := strings.TrimSpace(stripComment(line))
+	if text == "" {
+		return nil
+	}
+	fields := strings.Fields(text)
+	// Labels, like "main:" or "hi:	db 1, 2, 3"
+	if label := fields[0]; strings.HasSuffix(label, ":") {
+		m.labels[strings.TrimSuffix(label, ":")] = m.here()
+		text = strings.TrimSpace(text[len(label):])
+		if text == "" {
+			return nil
+		}
+		fields = strings.Fields(text)
+	}
+	directive := strings.ToLower(fields[0])
+	rest := strings.TrimSpace(text[len(fields[0]):])
+	switch directive {
+	case "bits", "global", "default", "cpu", "org":
+		return nil
+	case "section", "segment":
+		switch name := strings.Fields(rest)[0]; name {
+		case ".text", ".bss":
+			m.section = name
+		default:
+			m.section = ".data"
+		}
+		return nil
+	case "extern":
+		for _, name := range splitOperands(rest) {
+			m.externs = append(m.externs, name)
+		}
+		return nil
+	case "align", "alignb":
+		n, err := m.evaluate(splitOperands(rest)[0], m.here())
+		if err != nil {
+			return err
+		}
+		switch m.section {
+		case ".data":
+			data := m.sections[".data"]
+			for uint64(len(data))%uint64(n) != 0 {
+				data = append(data, 0)
+			}
+			m.sections[".data"] = data
+		case ".bss":
+			m.bssSize = align(m.bssSize, uint64(n))
+		}
+		return nil
+	}
+	if (len(fields) > 1) && (strings.ToLower(fields[1]) == "equ") {
+		m.equs[fields[0]] = &equ{expression: strings.TrimSpace(text[strings.Index(text, fields[1])+len(fields[1]):]), dollar: m.here()}
+		return nil
+	}
+	times := 1
+	if directive == "times" {
+		// Like "times 4 db 0"
+		n, err := m.evaluate(fields[1], m.here())
+		if err != nil {
+			return err
+		}
+		times = int(n)
+		text = strings.TrimSpace(text[strings.Index(text, fields[1])+len(fields[1]):])
+		fields = strings.Fields(text)
+		directive = strings.ToLower(fields[0])
+		rest = strings.TrimSpace(text[len(fields[0]):])
+	}
+	if size, ok := map[string]int{"db": 1, "dw": 2, "dd": 4, "dq": 8}[directive]; ok {
+		for i := 0; i < times; i++ {
+			if err := m.loadData(size, splitOperands(rest), linenr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if size, ok := map[string]uint64{"resb": 1, "resw": 2, "resd": 4, "resq": 8}[directive]; ok {
+		n, err := m.evaluate(rest, m.here())
+		if err != nil {
+			return err
+		}
+		if m.section == ".bss" {
+			m.bssSize += size * uint64(n) * uint64(times)
+		} else {
+			m.sections[".data"] = append(m.sections[".data"], make([]byte, size*uint64(n)*uint64(times))...)
+		}
+		return nil
+	}
+	if m.section != ".text" {
+		return fmt.Errorf("instructions can only be placed in the .text section")
+	}
+	// An instruction, like "mov rax, 1" or "rep stosb"
+	inst := &asmInstruction{line: linenr, text: text}
+	if has([]string{"rep", "repe", "repz", "repne", "repnz", "lock"}, directive) && (len(fields) > 1) {
+		inst.prefix = directive
+		text = strings.TrimSpace(text[len(fields[0]):])
+		fields = strings.Fields(text)
+		directive = strings.ToLower(fields[0])
+		rest = strings.TrimSpace(text[len(fields[0]):])
+	}
+	inst.mnemonic = directive
+	inst.args = splitOperands(rest)
+	for i := 0; i < times; i++ {
+		repeated := *inst
+		repeated.index = len(m.code)
+		m.code = append(m.code, &repeated)
+	}
+	return nil
+}
+
+// loadData loads the values of a db, dw, dd or dq directive into the .data section
+func (m *Interpreter) loadData(size int, values []string, linenr int) error {
+	if m.section == ".bss" {
+		return fmt.Errorf("data can not be placed in the .bss section")
+	}
+	for _, value := range values {
+		data := m.sections[".data"]
+		if value == "" {
+			// The generated code may have empty items, like "dq 1,, 2"
+			continue
+		}
+		if (len(value) > 1) && strings.ContainsRune("\"'`", rune(value[0])) && (value[len(value)-1] == value[0]) {
+			// A string, padded to the size of the values
+			s := []byte(value[1 : len(value)-1])
+			for len(s)%size != 0 {
+				s = append(s, 0)
+			}
+			m.sections[".data"] = append(data, s...)
+			continue
+		}
+		if f, err := strconv.ParseFloat(value, 64); (err == nil) && strings.ContainsAny(value, ".eE") && !strings.HasPrefix(value, "0x") {
+			// A floating point number
+			b := make([]byte, 8)
+			if size == 4 {
+				binary.LittleEndian.PutUint32(b, math.Float32bits(float32(f)))
+			} else {
+				binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+			}
+			m.sections[".data"] = append(data, b[:size]...)
+			continue
+		}
+		m.fixups = append(m.fixups, dataFixup{uint64(len(data)), size, value, m.here(), linenr})
+		m.sections[".data"] = append(data, make([]byte, size)...)
+	}
+	return nil
+}
+
+// fixup evaluates a value in the .data section, now that all the labels are known
+func (m *Interpreter) fixup(f dataFixup) error {
+	v, err := m.evaluate(f.expression, f.dollar)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	copy(m.sections[".data"][f.offset:], b[:f.size])
+	return nil
+}
+
+// address returns the address of a location in a section
+func (m *Interpreter) address(loc symbolLocation) uint64 {
+	switch loc.section {
+	case ".text":
+		return textAddress + loc.offset
+	case ".bss":
+		return m.bssAddress() + loc.offset
+	}
+	return dataAddress + loc.offset
+}
+
+// bssAddress returns where the .bss section starts. Like when the program is linked, it follows
+// right after the .data section, aligned to 4 bytes, as NASM aligns .bss for ELF.
+func (m *Interpreter) bssAddress() uint64 {
+	return align(dataAddress+uint64(len(m.sections[".data"])), 4)
+}
+
+// symbol returns the value of a label or a constant that is defined with equ
+func (m *Interpreter) symbol(name string) (int64, error) {
+	if loc, ok := m.labels[name]; ok {
+		return int64(m.address(loc)), nil
+	}
+	if e, ok := m.equs[name]; ok {
+		if e.evaluating {
+			return 0, fmt.Errorf("%s is defined in terms of itself", name)
+		}
+		e.evaluating = true
+		defer func() { e.evaluating = false }()
+		return m.evaluate(e.expression, e.dollar)
+	}
+	for i, extern := range m.externs {
+		if extern == name {
+			return int64(externAddress + i), nil
+		}
+	}
+	return 0, fmt.Errorf("undefined symbol: %s", name)
+}
+
+// evaluate evaluates an expression with numbers, labels and constants, like "$ - hi" or "_length_of_x*2"
+func (m *Interpreter) evaluate(expression string, dollar symbolLocation) (int64, error) {
+	p := &expressionParser{m: m, dollar: dollar, tokens: expressionTokens(expression)}
+	v, err := p.parse(0)
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.tokens) {
+		return 0, fmt.Errorf("unexpected %s in %s", p.tokens[p.pos], expression)
+	}
+	return v, nil
+}
+
+// expressionTokens splits an expression into numbers, names, character literals and operators
+func expressionTokens(expression string) []string {
+	var tokens []string
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case (c == ' ') || (c == '\t'):
+			i++
+		case (c == '\'') || (c == '"') || (c == '`'):
+			j := strings.IndexByte(expression[i+1:], c)
+			if j < 0 {
+				j = len(expression) - i - 1
+			}
+			tokens = append(tokens, expression[i:i+j+2])
+			i += j + 2
+		case strings.HasPrefix(expression[i:], "<<") || strings.HasPrefix(expression[i:], ">>") || strings.HasPrefix(expression[i:], "//"):
+			tokens = append(tokens, expression[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/%&|^~()", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for (j < len(expression)) && !strings.ContainsRune(" \t+-*/%&|^~()'\"`<>", rune(expression[j])) {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			tokens = append(tokens, expression[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// expressionParser evaluates expressions with the operator precedence of nasm
+type expressionParser struct {
+	m      *Interpreter
+	dollar symbolLocation
+	tokens []string
+	pos    int
+}
+
+// The binary operators, from the lowest to the highest precedence
+var expressionOperators = [][]string{{"|"}, {"^"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "//", "%"}}
+
+func (p *expressionParser) parse(level int) (int64, error) {
+	if level == len(expressionOperators) {
+		return p.unary()
+	}
+	left, err := p.parse(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for (p.pos < len(p.tokens)) && has(expressionOperators[level], p.tokens[p.pos]) {
+		op := p.tokens[p.pos]
+		p.pos++
+		right, err := p.parse(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left = int64(uint64(left) >> uint(right))
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "//", "%":
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			switch op {
+			case "/":
+				left = int64(uint64(left) / uint64(right))
+			case "//":
+				left /= right
+			default:
+				left = int64(uint64(left) % uint64(right))
+			}
+		}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) unary() (int64, error) {
+	if p.pos >= len(p.tokens) {
+		return 0, fmt.Errorf("incomplete expression")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case token == "-":
+		v, err := p.unary()
+		return -v, err
+	case token == "+":
+		return p.unary()
+	case token == "~":
+		v, err := p.unary()
+		return ^v, err
+	case token == "(":
+		v, err := p.parse(0)
+		if err != nil {
+			return 0, err
+		}
+		if (p.pos >= len(p.tokens)) || (p.tokens[p.pos] != ")") {
+			return 0, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return v, nil
+	case token == "$":
+		return int64(p.m.address(p.dollar)), nil
+	case token == "$$":
+		return int64(p.m.address(symbolLocation{p.dollar.section, 0})), nil
+	case strings.ContainsRune("'\"`", rune(token[0])):
+		// A character constant, like 'a', in little endian order
+		var v int64
+		s := strings.Trim(token, token[:1])
+		for i := len(s) - 1; i >= 0; i-- {
+			v = v<<8 | int64(s[i])
+		}
+		return v, nil
+	case (token[0] >= '0') && (token[0] <= '9'):
+		return parseAsmNumber(token)
+	}
+	return p.m.symbol(token)
+}
+
+// parseAsmNumber parses a number in the assembly code, like 42, 0x2a, 2ah, 0b101010 or 0o52
+func parseAsmNumber(s string) (int64, error) {
+	lower := strings.ToLower(strings.Replace(s, "_", "", -1))
+	var (
+		v   uint64
+		err error
+	)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		v, err = strconv.ParseUint(lower[2:], 16, 64)
+	case strings.HasPrefix(lower, "0b"):
+		v, err = strconv.ParseUint(lower[2:], 2, 64)
+	case strings.HasPrefix(lower, "0o"):
+		v, err = strconv.ParseUint(lower[2:], 8, 64)
+	case strings.HasSuffix(lower, "h"):
+		v, err = strconv.ParseUint(lower[:len(lower)-1], 16, 64)
+	default:
+		v, err = strconv.ParseUint(lower, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %s", s)
+	}
+	return int64(v), nil
+}
+
+// register returns the number, size and high byte flag of a general purpose register
+func (m *Interpreter) register(name string) (int, int, bool, bool) {
+	if n, ok := registerNumbers[name]; ok {
+		return n, 8, false, m.bits == 64
+	}
+	switch {
+	case (len(name) == 3) && (name[0] == 'e'):
+		if n, ok := registerNumbers["r"+name[1:]]; ok {
+			return n, 4, false, true
+		}
+	case (len(name) == 2) && strings.Contains("acdb", name[:1]) && strings.Contains("xlh", name[1:]):
+		n := registerNumbers["r"+name[:1]+"x"]
+		switch name[1] {
+		case 'x':
+			return n, 2, false, true
+		case 'l':
+			return n, 1, false, true
+		}
+		return n, 1, true, true
+	case has([]string{"si", "di", "sp", "bp"}, name):
+		return registerNumbers["r"+name], 2, false, true
+	case has([]string{"sil", "dil", "spl", "bpl"}, name):
+		return registerNumbers["r"+name[:2]], 1, false, m.bits == 64
+	case (len(name) >= 2) && (name[0] == 'r') && (m.bits == 64):
+		// r8 to r15, r8d, r8w and r8b
+		digits := strings.TrimRight(name[1:], "dwb")
+		n, err := strconv.Atoi(digits)
+		if (err != nil) || (n < 8) || (n > 15) {
+			break
+		}
+		size := map[string]int{"": 8, "d": 4, "w": 2, "b": 1}[name[1+len(digits):]]
+		if size == 0 {
+			break
+		}
+		return n, size, false, true
+	}
+	return 0, 0, false, false
+}
+
+// operand parses an operand of an instruction
+func (m *Interpreter) operand(arg string, inst *asmInstruction) (*asmOperand, error) {
+	op := &asmOperand{base: -1, index: -1}
+	words := strings.Fields(strings.ToLower(arg))
+	// Size qualifiers, like "qword [rsp]" or "byte [rdi]"
+	for (len(words) > 1) && has([]string{"byte", "word", "dword", "qword", "tword", "oword", "ptr", "short", "near", "strict", "to"}, words[0]) {
+		if size, ok := map[string]int{"byte": 1, "word": 2, "dword": 4, "qword": 8, "tword": 10, "oword": 16}[words[0]]; ok {
+			op.size = size
+		}
+		arg = strings.TrimSpace(arg[strings.Index(strings.ToLower(arg), words[0])+len(words[0]):])
+		words = words[1:]
+	}
+	lower := strings.ToLower(arg)
+	if n, size, high, ok := m.register(lower); ok {
+		op.kind, op.reg, op.size, op.high = operandRegister, n, size, high
+		return op, nil
+	}
+	if strings.HasPrefix(lower, "xmm") {
+		if n, err := strconv.Atoi(lower[3:]); (err == nil) && (n >= 0) && (n < 16) {
+			op.kind, op.reg, op.size = operandXMM, n, 16
+			return op, nil
+		}
+	}
+	if strings.HasPrefix(lower, "st") {
+		if n, err := strconv.Atoi(strings.Trim(lower[2:], "()")); (err == nil) && (n >= 0) && (n < 8) {
+			op.kind, op.reg = operandST, n
+			return op, nil
+		}
+	}
+	if strings.HasPrefix(arg, "[") && strings.HasSuffix(arg, "]") {
+		op.kind, op.scale = operandMemory, 1
+		address := arg[1 : len(arg)-1]
+		if i := strings.Index(address, ":"); (i == 2) && strings.HasSuffix(strings.ToLower(address[:i]), "s") {
+			// A segment override, like [ds:rsi]
+			address = address[i+1:]
+		}
+		return op, m.memoryOperand(op, address, inst)
+	}
+	v, err := m.evaluate(arg, symbolLocation{".text", uint64(inst.index)})
+	if err != nil {
+		return nil, err
+	}
+	op.kind, op.value = operandImmediate, v
+	return op, nil
+}
+
+// memoryOperand parses an address like rbx+rsi*8+16 or hi+1
+func (m *Interpreter) memoryOperand(op *asmOperand, address string, inst *asmInstruction) error {
+	var terms []string
+	start, depth := 0, 0
+	for i, c := range address {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case ((c == '+') || (c == '-')) && (depth == 0) && (i > start):
+			terms = append(terms, address[start:i])
+			start = i
+		}
+	}
+	terms = append(terms, address[start:])
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		sign := ""
+		if strings.HasPrefix(term, "+") || strings.HasPrefix(term, "-") {
+			sign, term = term[:1], strings.TrimSpace(term[1:])
+		}
+		// A register, or a register multiplied by a scale
+		factors := strings.Split(term, "*")
+		reg, scale := -1, "1"
+		for i, factor := range factors {
+			if n, size, _, ok := m.register(strings.ToLower(strings.TrimSpace(factor))); ok && (size >= 4) {
+				reg = n
+				scale = strings.Join(append(append([]string{}, factors[:i]...), factors[i+1:]...), "*")
+				if scale == "" {
+					scale = "1"
+				}
+				break
+			}
+		}
+		if reg >= 0 {
+			if sign == "-" {
+				return fmt.Errorf("a register can not be subtracted in an address")
+			}
+			s, err := m.evaluate(scale, symbolLocation{})
+			if err != nil {
+				return err
+			}
+			if (op.base < 0) && (s == 1) {
+				op.base = reg
+			} else if op.index < 0 {
+				op.index, op.scale = reg, s
+			} else {
+				return fmt.Errorf("too many registers in an address")
+			}
+			continue
+		}
+		v, err := m.evaluate(sign+term, symbolLocation{".text", uint64(inst.index)})
+		if err != nil {
+			return err
+		}
+		op.value += v
+	}
+	return nil
+}
+
+// mask returns the bits of a value of the given size, in bytes
+func mask(size int) uint64 {
+	if size >= 8 {
+		return math.MaxUint64
+	}
+	return 1<<(8*uint(size)) - 1
+}
+
+// signExtend sign extends a value of the given size, in bytes
+func signExtend(v uint64, size int) int64 {
+	shift := 64 - 8*uint(size)
+	return int64(v<<shift) >> shift
+}
+
+// pageOf returns the address of the page that the given address is on
+func pageOf(addr uint64) uint64 {
+	return addr / pageSize * pageSize
+}
+
+// page returns the page that the given address is on, or nil if the address is not mapped
+func (m *Interpreter) page(addr uint64) []byte {
+	p := pageOf(addr)
+	if data, ok := m.pages[p]; ok {
+		return data
+	}
+	for _, r := range m.mapped {
+		if (addr >= r.start) && (addr < r.end) {
+			m.pages[p] = make([]byte, pageSize)
+			return m.pages[p]
+		}
+	}
+	return nil
+}
+
+// addressMask is applied to every address, so that addresses wrap around at 4 GiB on 32-bit
+func (m *Interpreter) addressMask() uint64 {
+	return mask(m.bits / 8)
+}
+
+// load reads a value of the given size, in bytes, from memory
+func (m *Interpreter) load(addr uint64, size int) (uint64, error) {
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		a := (addr + uint64(i)) & m.addressMask()
+		p := m.page(a)
+		if p == nil {
+			return 0, fmt.Errorf("segmentation fault, when reading from 0x%x", a)
+		}
+		v = v<<8 | uint64(p[a%pageSize])
+	}
+	return v, nil
+}
+
+// store writes a value of the given size, in bytes, to memory
+func (m *Interpreter) store(addr uint64, size int, v uint64) error {
+	for i := 0; i < size; i++ {
+		a := (addr + uint64(i)) & m.addressMask()
+		p := m.page(a)
+		if p == nil {
+			return fmt.Errorf("segmentation fault, when writing to 0x%x", a)
+		}
+		p[a%pageSize] = byte(v >> (8 * uint(i)))
+	}
+	return nil
+}
+
+// loadBytes reads n bytes from memory
+func (m *Interpreter) loadBytes(addr, n uint64) ([]byte, error) {
+	data := make([]byte, n)
+	for i := range data {
+		b, err := m.load(addr+uint64(i), 1)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = byte(b)
+	}
+	return data, nil
+}
+
+// getRegister returns the value of a general purpose register
+func (m *Interpreter) getRegister(n, size int, high bool) uint64 {
+	if high {
+		return (m.regs[n] >> 8) & 0xff
+	}
+	return m.regs[n] & mask(size)
+}
+
+// setRegister sets a general purpose register. Setting a 32-bit register clears the upper half of the 64-bit register.
+func (m *Interpreter) setRegister(n, size int, high bool, v uint64) {
+	switch {
+	case high:
+		m.regs[n] = (m.regs[n] &^ 0xff00) | ((v & 0xff) << 8)
+	case size >= 4:
+		m.regs[n] = v & mask(size)
+	default:
+		m.regs[n] = (m.regs[n] &^ mask(size)) | (v & mask(size))
+	}
+}
+
+// effectiveAddress returns the address of a memory operand
+func (m *Interpreter) effectiveAddress(op *asmOperand) uint64 {
+	addr := uint64(op.value)
+	if op.base >= 0 {
+		addr += m.regs[op.base]
+	}
+	if op.index >= 0 {
+		addr += m.regs[op.index] * uint64(op.scale)
+	}
+	return addr & m.addressMask()
+}
+
+// get returns the value of an operand, with the given size in bytes
+func (m *Interpreter) get(op *asmOperand, size int) (uint64, error) {
+	switch op.kind {
+	case operandRegister:
+		return m.getRegister(op.reg, op.size, op.high), nil
+	case operandImmediate:
+		return uint64(op.value) & mask(size), nil
+	case operandMemory:
+		return m.load(m.effectiveAddress(op), size)
+	case operandXMM:
+		return m.xmm[op.reg][0] & mask(size), nil
+	}
+	return 0, fmt.Errorf("unsupported operand")
+}
+
+// set sets the value of an operand, with the given size in bytes
+func (m *Interpreter) set(op *asmOperand, size int, v uint64) error {
+	switch op.kind {
+	case operandRegister:
+		m.setRegister(op.reg, op.size, op.high, v)
+		return nil
+	case operandMemory:
+		return m.store(m.effectiveAddress(op), size, v)
+	case operandXMM:
+		m.xmm[op.reg] = [2]uint64{v & mask(size), 0}
+		return nil
+	}
+	return fmt.Errorf("can not assign to an immediate value")
+}
+
+// push pushes a value of the given size to the stack
+func (m *Interpreter) push(size int, v uint64) error {
+	sp := registerNumbers["rsp"]
+	m.setRegister(sp, m.bits/8, false, m.regs[sp]-uint64(size))
+	return m.store(m.regs[sp], size, v)
+}
+
+// pop pops a value of the given size from the stack
+func (m *Interpreter) pop(size int) (uint64, error) {
+	sp := registerNumbers["rsp"]
+	v, err := m.load(m.regs[sp], size)
+	m.setRegister(sp, m.bits/8, false, m.regs[sp]+uint64(size))
+	return v, err
+}
+
+// Run runs the program from the starting point, until it exits, and returns the exit code
+func (m *Interpreter) Run() (int, error) {
+	start := "_start"
+	if _, ok := m.labels[start]; !ok {
+		start = "main"
+	}
+	if loc, ok := m.labels[start]; ok {
+		m.ip = int(loc.offset)
+	}
+	// Like Linux, start with argc, argv and envp on the stack
+	sp := registerNumbers["rsp"]
+	m.regs[sp] = stackTop - 64
+	name := uint64(stackTop - 16)
+	m.store(name, 8, 0x6d6172676f7270) // "program"
+	word := m.bits / 8
+	for i, v := range []uint64{1, name, 0, 0, 0} {
+		m.store(m.regs[sp]+uint64(i*word), word, v)
+	}
+	for steps := 0; (m.MaxSteps == 0) || (steps < m.MaxSteps); steps++ {
+		if (m.ip < 0) || (m.ip >= len(m.code)) {
+			return 0, fmt.Errorf("the program ran past the end of the code")
+		}
+		inst := m.code[m.ip]
+		m.ip++
+		if err := m.execute(inst); err == errExit {
+			return m.exit, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("line %d of the assembly: %s: %v", inst.line, inst.text, err)
+		}
+	}
+	return 0, fmt.Errorf("the program did not exit after %d instructions", m.MaxSteps)
+}
+
+// jump continues at the instruction with the given address
+func (m *Interpreter) jump(addr uint64) error {
+	if (addr >= externAddress) && (addr < externAddress+uint64(len(m.externs))) {
+		return fmt.Errorf("%s is an external symbol, which can not be run", m.externs[addr-externAddress])
+	}
+	if (addr < textAddress) || (addr > textAddress+uint64(len(m.code))) {
+		return fmt.Errorf("jump to 0x%x, which is not an instruction", addr)
+	}
+	m.ip = int(addr - textAddress)
+	return nil
+}
+
+// syscall performs a Linux system call. The 32-bit system call numbers and registers are used
+// on 32-bit, and for "int 0x80" on 64-bit.
+func (m *Interpreter) syscall(int80 bool) error {
+	var number uint64
+	var args [6]uint64
+	legacy := (m.bits == 32) || int80
+	if !legacy {
+		number = m.regs[0]
+		for i, reg := range []int{7, 6, 2, 10, 8, 9} { // rdi, rsi, rdx, r10, r8, r9
+			args[i] = m.regs[reg]
+		}
+	} else {
+		number = m.regs[0] & 0xffffffff
+		for i, reg := range []int{3, 1, 2, 6, 7, 5} { // ebx, ecx, edx, esi, edi, ebp
+			args[i] = m.regs[reg] & 0xffffffff
+		}
+	}
+	names64 := map[uint64]string{0: "read", 1: "write", 9: "mmap", 11: "munmap", 12: "brk", 35: "nanosleep", 60: "exit", 201: "time", 231: "exit"}
+	names32 := map[uint64]string{3: "read", 4: "write", 192: "mmap", 91: "munmap", 45: "brk", 162: "nanosleep", 1: "exit", 13: "time", 252: "exit"}
+	name := names64[number]
+	if legacy {
+		name = names32[number]
+	}
+	result := int64(-38) // ENOSYS
+	switch name {
+	case "exit":
+		m.exit = int(args[0] & 0xff)
+		return errExit
+	case "write":
+		var w io.Writer
+		switch args[0] {
+		case 1:
+			w = m.Stdout
+		case 2:
+			w = m.Stderr
+		}
+		data, err := m.loadBytes(args[1], args[2])
+		if err != nil {
+			result = -14 // EFAULT
+		} else if w == nil {
+			result = -9 // EBADF
+		} else {
+			n, _ := w.Write(data)
+			result = int64(n)
+		}
+	case "read":
+		if (args[0] != 0) || (m.Stdin == nil) {
+			result = -9 // EBADF
+			break
+		}
+		data := make([]byte, args[2])
+		n, _ := m.Stdin.Read(data)
+		result = int64(n)
+		for i := 0; i < n; i++ {
+			if m.store(args[1]+uint64(i), 1, uint64(data[i])) != nil {
+				result = -14 // EFAULT
+				break
+			}
+		}
+	case "mmap":
+		// Only anonymous memory
+		if int64(signExtend(args[4], m.bits/8)) != -1 {
+			result = -19 // ENODEV
+			break
+		}
+		size := align(args[1], pageSize)
+		if (args[1] == 0) || (size < args[1]) {
+			result = -22 // EINVAL
+			break
+		}
+		if m.heap+size > stackTop-stackSize {
+			result = -12 // ENOMEM
+			break
+		}
+		result = int64(m.heap)
+		m.mapped = append(m.mapped, memoryRange{m.heap, m.heap + size})
+		m.heap += size + pageSize
+	case "munmap":
+		result = -22 // EINVAL
+		for i, r := range m.mapped {
+			if r.start == args[0] {
+				m.mapped = append(m.mapped[:i], m.mapped[i+1:]...)
+				for p := r.start; p < r.end; p += pageSize {
+					delete(m.pages, p)
+				}
+				result = 0
+				break
+			}
+		}
+	case "brk":
+		if args[0] > m.brk {
+			m.mapped = append(m.mapped, memoryRange{m.brk, align(args[0], pageSize)})
+			m.brk = align(args[0], pageSize)
+		}
+		result = int64(m.brk)
+	case "nanosleep":
+		seconds, err := m.load(args[0], m.bits/8)
+		if err != nil {
+			result = -14 // EFAULT
+			break
+		}
+		nanoseconds, _ := m.load(args[0]+uint64(m.bits/8), m.bits/8)
+		time.Sleep(time.Duration(seconds)*time.Second + time.Duration(nanoseconds))
+		result = 0
+	case "time":
+		result = time.Now().Unix()
+		if args[0] != 0 {
+			m.store(args[0], m.bits/8, uint64(result))
+		}
+	}
+	m.setRegister(0, m.bits/8, false, uint64(result))
+	return nil
+}
+
+// InterpretProgram compiles Battlestar source code for 32-bit or 64-bit Linux and interprets the assembly code,
+// without assembling and linking it.
+// Returns the exit code of the program.
+func InterpretProgram(source, filename string, bits int, searchPath []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	config, err := NewTargetConfig(bits, false, false)
+	if err != nil {
+		return 0, err
+	}
+	asmcode, ccode, inline, _, err := config.Compile(source, filename, searchPath, false, NewProgramState())
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(ccode) != "" {
+		return 0, fmt.Errorf("programs with inline C can not be interpreted, since the C code is not compiled")
+	}
+	for _, language := range inlineLanguages {
+		if _, ok := inline[language.Name]; ok {
+			return 0, fmt.Errorf("programs with inline %s can not be interpreted, since the %s code is not compiled", language.Name, language.Name)
+		}
+	}
+	m, err := NewInterpreter(asmcode, bits)
+	if err != nil {
+		return 0, err
+	}
+	m.Stdin, m.Stdout, m.Stderr = stdin, stdout, stderr
+	return m.Run()
+}
@@ -0,0 +1,1102 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strings"
+)
+
+// conditions are the conditions of the conditional jumps, set and cmov instructions
+var conditions = map[string]func(m *Interpreter) bool{
+	"o":  func(m *Interpreter) bool { return m.flags.of },
+	"no": func(m *Interpreter) bool { return !m.flags.of },
+	"b":  func(m *Interpreter) bool { return m.flags.cf },
+	"nb": func(m *Interpreter) bool { return !m.flags.cf },
+	"e":  func(m *Interpreter) bool { return m.flags.zf },
+	"ne": func(m *Interpreter) bool { return !m.flags.zf },
+	"be": func(m *Interpreter) bool { return m.flags.cf || m.flags.zf },
+	"a":  func(m *Interpreter) bool { return !m.flags.cf && !m.flags.zf },
+	"s":  func(m *Interpreter) bool { return m.flags.sf },
+	"ns": func(m *Interpreter) bool { return !m.flags.sf },
+	"p":  func(m *Interpreter) bool { return m.flags.pf },
+	"np": func(m *Interpreter) bool { return !m.flags.pf },
+	"l":  func(m *Interpreter) bool { return m.flags.sf != m.flags.of },
+	"ge": func(m *Interpreter) bool { return m.flags.sf == m.flags.of },
+	"le": func(m *Interpreter) bool { return m.flags.zf || (m.flags.sf != m.flags.of) },
+	"g":  func(m *Interpreter) bool { return !m.flags.zf && (m.flags.sf == m.flags.of) },
+}
+
+// conditionAliases are the other names of the conditions
+var conditionAliases = map[string]string{"c": "b", "nae": "b", "nc": "nb", "ae": "nb", "z": "e", "nz": "ne", "na": "be", "nbe": "a",
+	"pe": "p", "po": "np", "nge": "l", "nl": "ge", "ng": "le", "nle": "g"}
+
+// condition returns the condition of a mnemonic like jnz, sete or cmovl, after the given prefix
+func condition(mnemonic, prefix string) (func(m *Interpreter) bool, bool) {
+	if !strings.HasPrefix(mnemonic, prefix) {
+		return nil, false
+	}
+	cc := mnemonic[len(prefix):]
+	if alias, ok := conditionAliases[cc]; ok {
+		cc = alias
+	}
+	f, ok := conditions[cc]
+	return f, ok
+}
+
+// operandSize returns the size of the operation, from the first operand with a known size
+func (m *Interpreter) operandSize(operands ...*asmOperand) int {
+	for _, op := range operands {
+		if (op.kind != operandImmediate) && (op.size > 0) && (op.kind != operandXMM) {
+			return op.size
+		}
+	}
+	return m.bits / 8
+}
+
+// setResultFlags sets the zero, sign and parity flags from a result
+func (m *Interpreter) setResultFlags(result uint64, size int) {
+	result &= mask(size)
+	m.flags.zf = result == 0
+	m.flags.sf = (result>>(8*uint(size)-1))&1 == 1
+	m.flags.pf = bits.OnesCount8(uint8(result))%2 == 0
+}
+
+// add adds two values and a carry, and sets the flags
+func (m *Interpreter) add(a, b, carry uint64, size int) uint64 {
+	var result, carryOut uint64
+	if size == 8 {
+		result, carryOut = bits.Add64(a, b, carry)
+	} else {
+		sum := a + b + carry
+		result, carryOut = sum&mask(size), sum>>(8*uint(size))
+	}
+	sign := uint64(1) << (8*uint(size) - 1)
+	m.flags.cf = carryOut != 0
+	m.flags.of = (a^result)&(b^result)&sign != 0
+	m.setResultFlags(result, size)
+	return result
+}
+
+// sub subtracts a value and a borrow from another, and sets the flags
+func (m *Interpreter) sub(a, b, borrow uint64, size int) uint64 {
+	var result, borrowOut uint64
+	if size == 8 {
+		result, borrowOut = bits.Sub64(a, b, borrow)
+	} else {
+		result = (a - b - borrow) & mask(size)
+		if a < b+borrow {
+			borrowOut = 1
+		}
+	}
+	sign := uint64(1) << (8*uint(size) - 1)
+	m.flags.cf = borrowOut != 0
+	m.flags.of = (a^b)&(a^result)&sign != 0
+	m.setResultFlags(result, size)
+	return result
+}
+
+// logic sets the flags after and, or, xor and test
+func (m *Interpreter) logic(result uint64, size int) uint64 {
+	m.flags.cf, m.flags.of = false, false
+	m.setResultFlags(result, size)
+	return result & mask(size)
+}
+
+// counter returns the register that is used as the counter by loop and rep, for the current platform
+func (m *Interpreter) counter() uint64 {
+	return m.getRegister(1, m.bits/8, false)
+}
+
+// execute runs one instruction
+func (m *Interpreter) execute(inst *asmInstruction) error {
+	ops := inst.operands
+	need := func(n int) error {
+		if len(ops) != n {
+			return fmt.Errorf("%s needs %d operands", inst.mnemonic, n)
+		}
+		return nil
+	}
+	if f, ok := condition(inst.mnemonic, "j"); ok {
+		if err := need(1); err != nil {
+			return err
+		}
+		if f(m) {
+			return m.jump(uint64(ops[0].value))
+		}
+		return nil
+	}
+	if f, ok := condition(inst.mnemonic, "set"); ok {
+		if err := need(1); err != nil {
+			return err
+		}
+		v := uint64(0)
+		if f(m) {
+			v = 1
+		}
+		return m.set(ops[0], 1, v)
+	}
+	if f, ok := condition(inst.mnemonic, "cmov"); ok {
+		if err := need(2); err != nil {
+			return err
+		}
+		size := m.operandSize(ops...)
+		v, err := m.get(ops[1], size)
+		if (err != nil) || !f(m) {
+			return err
+		}
+		return m.set(ops[0], size, v)
+	}
+	if strings.HasPrefix(inst.mnemonic, "f") {
+		return m.executeFPU(inst)
+	}
+	if isSSE(inst) {
+		return m.executeSSE(inst)
+	}
+	if size, ok := stringInstruction(inst); ok {
+		return m.executeString(inst, size)
+	}
+	switch inst.mnemonic {
+	case "nop", "cld", "cli", "sti", "wait", "pause":
+		if inst.mnemonic == "cld" {
+			m.flags.df = false
+		}
+		return nil
+	case "std":
+		m.flags.df = true
+		return nil
+	case "mov":
+		if err := need(2); err != nil {
+			return err
+		}
+		size := m.operandSize(ops...)
+		v, err := m.get(ops[1], size)
+		if err != nil {
+			return err
+		}
+		return m.set(ops[0], size, v)
+	case "movzx", "movsx", "movsxd":
+		if err := need(2); err != nil {
+			return err
+		}
+		srcSize := ops[1].size
+		if srcSize == 0 {
+			srcSize = map[string]int{"movsxd": 4}[inst.mnemonic]
+			if srcSize == 0 {
+				return fmt.Errorf("the size of the source is not given")
+			}
+		}
+		v, err := m.get(ops[1], srcSize)
+		if err != nil {
+			return err
+		}
+		if inst.mnemonic != "movzx" {
+			v = uint64(signExtend(v, srcSize))
+		}
+		return m.set(ops[0], ops[0].size, v)
+	case "lea":
+		if err := need(2); err != nil {
+			return err
+		}
+		if ops[1].kind != operandMemory {
+			return fmt.Errorf("lea needs a memory operand")
+		}
+		return m.set(ops[0], ops[0].size, m.effectiveAddress(ops[1]))
+	case "xchg":
+		if err := need(2); err != nil {
+			return err
+		}
+		size := m.operandSize(ops...)
+		a, err := m.get(ops[0], size)
+		if err != nil {
+			return err
+		}
+		b, err := m.get(ops[1], size)
+		if err != nil {
+			return err
+		}
+		if err := m.set(ops[0], size, b); err != nil {
+			return err
+		}
+		return m.set(ops[1], size, a)
+	case "add", "adc", "sub", "sbb", "cmp", "and", "or", "xor", "test":
+		if err := need(2); err != nil {
+			return err
+		}
+		size := m.operandSize(ops...)
+		a, err := m.get(ops[0], size)
+		if err != nil {
+			return err
+		}
+		b, err := m.get(ops[1], size)
+		if err != nil {
+			return err
+		}
+		carry := uint64(0)
+		if m.flags.cf {
+			carry = 1
+		}
+		var result uint64
+		switch inst.mnemonic {
+		case "add":
+			result = m.add(a, b, 0, size)
+		case "adc":
+			result = m.add(a, b, carry, size)
+		case "sub", "cmp":
+			result = m.sub(a, b, 0, size)
+		case "sbb":
+			result = m.sub(a, b, carry, size)
+		case "and", "test":
+			result = m.logic(a&b, size)
+		case "or":
+			result = m.logic(a|b, size)
+		case "xor":
+			result = m.logic(a^b, size)
+		}
+		if (inst.mnemonic == "cmp") || (inst.mnemonic == "test") {
+			return nil
+		}
+		return m.set(ops[0], size, result)
+	case "inc", "dec", "neg", "not":
+		if err := need(1); err != nil {
+			return err
+		}
+		size := m.operandSize(ops...)
+		a, err := m.get(ops[0], size)
+		if err != nil {
+			return err
+		}
+		var result uint64
+		switch inst.mnemonic {
+		case "inc", "dec":
+			// The carry flag is kept as it is
+			cf := m.flags.cf
+			if inst.mnemonic == "inc" {
+				result = m.add(a, 1, 0, size)
+			} else {
+				result = m.sub(a, 1, 0, size)
+			}
+			m.flags.cf = cf
+		case "neg":
+			result = m.sub(0, a, 0, size)
+		case "not":
+			result = ^a & mask(size)
+		}
+		return m.set(ops[0], size, result)
+	case "shl", "sal", "shr", "sar", "rol", "ror", "rcl", "rcr":
+		return m.shift(inst)
+	case "mul", "imul", "div", "idiv":
+		return m.multiplyOrDivide(inst)
+	case "cbw", "cwde", "cdqe":
+		size := map[string]int{"cbw": 1, "cwde": 2, "cdqe": 4}[inst.mnemonic]
+		m.setRegister(0, size*2, false, uint64(signExtend(m.getRegister(0, size, false), size)))
+		return nil
+	case "cwd", "cdq", "cqo":
+		size := map[string]int{"cwd": 2, "cdq": 4, "cqo": 8}[inst.mnemonic]
+		v := uint64(0)
+		if signExtend(m.getRegister(0, size, false), size) < 0 {
+			v = mask(size)
+		}
+		m.setRegister(2, size, false, v)
+		return nil
+	case "push":
+		if err := need(1); err != nil {
+			return err
+		}
+		size := m.bits / 8
+		if (ops[0].kind != operandImmediate) && (ops[0].size == 2) {
+			size = 2
+		}
+		v, err := m.get(ops[0], size)
+		if err != nil {
+			return err
+		}
+		if ops[0].kind == operandImmediate {
+			v = uint64(ops[0].value)
+		}
+		return m.push(size, v)
+	case "pop":
+		if err := need(1); err != nil {
+			return err
+		}
+		size := m.bits / 8
+		if ops[0].size == 2 {
+			size = 2
+		}
+		v, err := m.pop(size)
+		if err != nil {
+			return err
+		}
+		return m.set(ops[0], size, v)
+	case "jmp", "call":
+		if err := need(1); err != nil {
+			return err
+		}
+		target := uint64(ops[0].value)
+		if ops[0].kind != operandImmediate {
+			v, err := m.get(ops[0], m.bits/8)
+			if err != nil {
+				return err
+			}
+			target = v
+		}
+		if inst.mnemonic == "call" {
+			if err := m.push(m.bits/8, textAddress+uint64(m.ip)); err != nil {
+				return err
+			}
+		}
+		return m.jump(target)
+	case "ret":
+		addr, err := m.pop(m.bits / 8)
+		if err != nil {
+			return err
+		}
+		if len(ops) == 1 {
+			sp := registerNumbers["rsp"]
+			m.setRegister(sp, m.bits/8, false, m.regs[sp]+uint64(ops[0].value))
+		}
+		return m.jump(addr)
+	case "loop", "loope", "loopz", "loopne", "loopnz":
+		if err := need(1); err != nil {
+			return err
+		}
+		m.setRegister(1, m.bits/8, false, m.counter()-1)
+		jump := m.counter() != 0
+		switch inst.mnemonic {
+		case "loope", "loopz":
+			jump = jump && m.flags.zf
+		case "loopne", "loopnz":
+			jump = jump && !m.flags.zf
+		}
+		if jump {
+			return m.jump(uint64(ops[0].value))
+		}
+		return nil
+	case "jcxz", "jecxz", "jrcxz":
+		if err := need(1); err != nil {
+			return err
+		}
+		size := map[string]int{"jcxz": 2, "jecxz": 4, "jrcxz": 8}[inst.mnemonic]
+		if m.getRegister(1, size, false) == 0 {
+			return m.jump(uint64(ops[0].value))
+		}
+		return nil
+	case "syscall":
+		if m.bits != 64 {
+			return fmt.Errorf("syscall is only available on 64-bit")
+		}
+		// Like the real instruction, rcx and r11 are changed
+		m.regs[1], m.regs[11] = textAddress+uint64(m.ip), 0x202
+		return m.syscall(false)
+	case "int":
+		if err := need(1); err != nil {
+			return err
+		}
+		if ops[0].value == 0x80 {
+			return m.syscall(true)
+		}
+		return fmt.Errorf("interrupt %d is not available", ops[0].value)
+	case "hlt":
+		return fmt.Errorf("hlt can only be used by the kernel")
+	case "in", "out", "ins", "outs":
+		return fmt.Errorf("IO ports can only be used by the kernel")
+	}
+	return fmt.Errorf("unsupported instruction: %s", inst.mnemonic)
+}
+
+// shift runs shl, sal, shr, sar, rol, ror, rcl and rcr
+func (m *Interpreter) shift(inst *asmInstruction) error {
+	ops := inst.operands
+	if (len(ops) < 1) || (len(ops) > 2) {
+		return fmt.Errorf("%s needs 1 or 2 operands", inst.mnemonic)
+	}
+	size := m.operandSize(ops[0])
+	a, err := m.get(ops[0], size)
+	if err != nil {
+		return err
+	}
+	count := uint64(1)
+	if len(ops) == 2 {
+		if count, err = m.get(ops[1], 1); err != nil {
+			return err
+		}
+	}
+	if size == 8 {
+		count &= 63
+	} else {
+		count &= 31
+	}
+	if count == 0 {
+		return nil
+	}
+	width := 8 * uint64(size)
+	msb := func(v uint64) bool { return (v>>(width-1))&1 == 1 }
+	var result uint64
+	switch inst.mnemonic {
+	case "shl", "sal":
+		result = (a << count) & mask(size)
+		m.flags.cf = (count <= width) && ((a>>(width-count))&1 == 1)
+		m.flags.of = msb(result) != m.flags.cf
+		m.setResultFlags(result, size)
+	case "shr":
+		result = a >> count
+		m.flags.cf = (a>>(count-1))&1 == 1
+		m.flags.of = msb(a)
+		m.setResultFlags(result, size)
+	case "sar":
+		result = uint64(signExtend(a, size)>>count) & mask(size)
+		m.flags.cf = (uint64(signExtend(a, size))>>(count-1))&1 == 1
+		m.flags.of = false
+		m.setResultFlags(result, size)
+	case "rol", "ror":
+		count %= width
+		if inst.mnemonic == "rol" {
+			result = ((a << count) | (a >> ((width - count) % width))) & mask(size)
+			m.flags.cf = result&1 == 1
+		} else {
+			result = ((a >> count) | (a << ((width - count) % width))) & mask(size)
+			m.flags.cf = msb(result)
+		}
+	case "rcl", "rcr":
+		for i := uint64(0); i < count; i++ {
+			carry := m.flags.cf
+			if inst.mnemonic == "rcl" {
+				m.flags.cf = msb(a)
+				a = (a << 1) & mask(size)
+				if carry {
+					a |= 1
+				}
+			} else {
+				m.flags.cf = a&1 == 1
+				a >>= 1
+				if carry {
+					a |= 1 << (width - 1)
+				}
+			}
+		}
+		result = a
+	}
+	return m.set(ops[0], size, result)
+}
+
+// multiplyOrDivide runs mul, imul, div and idiv
+func (m *Interpreter) multiplyOrDivide(inst *asmInstruction) error {
+	ops := inst.operands
+	if (inst.mnemonic == "imul") && (len(ops) > 1) {
+		// imul with two or three operands keeps the lower half of the result
+		size := m.operandSize(ops[0])
+		a, err := m.get(ops[0], size)
+		if err != nil {
+			return err
+		}
+		b, err := m.get(ops[1], size)
+		if err != nil {
+			return err
+		}
+		if len(ops) == 3 {
+			a, b = b, uint64(ops[2].value)
+		}
+		product := new(big.Int).Mul(big.NewInt(signExtend(a, size)), big.NewInt(signExtend(b&mask(size), size)))
+		result := uint64(product.Int64()) & mask(size)
+		m.flags.cf = product.Cmp(big.NewInt(signExtend(result, size))) != 0
+		m.flags.of = m.flags.cf
+		return m.set(ops[0], size, result)
+	}
+	if len(ops) != 1 {
+		return fmt.Errorf("%s needs 1 operand", inst.mnemonic)
+	}
+	size := m.operandSize(ops[0])
+	src, err := m.get(ops[0], size)
+	if err != nil {
+		return err
+	}
+	// The a and d registers hold the double width value, except for bytes, where ah is the upper half
+	var low, high uint64
+	if size == 1 {
+		low, high = m.getRegister(0, 1, false), m.getRegister(0, 1, true)
+	} else {
+		low, high = m.getRegister(0, size, false), m.getRegister(2, size, false)
+	}
+	setResult := func(low, high uint64) {
+		if size == 1 {
+			m.setRegister(0, 2, false, (high&0xff)<<8|(low&0xff))
+		} else {
+			m.setRegister(0, size, false, low)
+			m.setRegister(2, size, false, high)
+		}
+	}
+	width := uint(8 * size)
+	signed := strings.HasPrefix(inst.mnemonic, "i")
+	toBig := func(v uint64) *big.Int {
+		if signed {
+			return big.NewInt(signExtend(v, size))
+		}
+		return new(big.Int).SetUint64(v & mask(size))
+	}
+	fromBig := func(v *big.Int) (uint64, uint64) {
+		// The lower and upper halves of a two's complement value
+		twos := new(big.Int).And(v, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 2*width), big.NewInt(1)))
+		return new(big.Int).And(twos, new(big.Int).SetUint64(mask(size))).Uint64(), new(big.Int).Rsh(twos, width).Uint64()
+	}
+	if strings.HasSuffix(inst.mnemonic, "mul") {
+		product := new(big.Int).Mul(toBig(low), toBig(src))
+		l, h := fromBig(product)
+		setResult(l, h)
+		if signed {
+			m.flags.cf = product.Cmp(big.NewInt(signExtend(l, size))) != 0
+		} else {
+			m.flags.cf = h != 0
+		}
+		m.flags.of = m.flags.cf
+		return nil
+	}
+	if src&mask(size) == 0 {
+		return fmt.Errorf("division by zero")
+	}
+	dividend := new(big.Int).Or(new(big.Int).Lsh(new(big.Int).SetUint64(high&mask(size)), width), new(big.Int).SetUint64(low&mask(size)))
+	if signed && (high>>(width-1))&1 == 1 {
+		dividend.Sub(dividend, new(big.Int).Lsh(big.NewInt(1), 2*width))
+	}
+	quotient, remainder := new(big.Int).QuoRem(dividend, toBig(src), new(big.Int))
+	q, qHigh := fromBig(quotient)
+	r, _ := fromBig(remainder)
+	if (signed && (quotient.Cmp(big.NewInt(signExtend(q, size))) != 0)) || (!signed && (qHigh != 0)) {
+		return fmt.Errorf("the quotient is too large for the register")
+	}
+	setResult(q, r)
+	return nil
+}
+
+// stringInstruction checks if the instruction is a string instruction, like stosb, and returns the size of each element
+func stringInstruction(inst *asmInstruction) (int, bool) {
+	if len(inst.operands) > 0 {
+		return 0, false
+	}
+	for _, name := range []string{"stos", "lods", "movs", "scas", "cmps"} {
+		if strings.HasPrefix(inst.mnemonic, name) && (len(inst.mnemonic) == 5) {
+			size, ok := map[byte]int{'b': 1, 'w': 2, 'd': 4, 'q': 8}[inst.mnemonic[4]]
+			return size, ok
+		}
+	}
+	return 0, false
+}
+
+// executeString runs stos, lods, movs, scas and cmps, with the rep, repe and repne prefixes
+func (m *Interpreter) executeString(inst *asmInstruction, size int) error {
+	name := inst.mnemonic[:4]
+	word := m.bits / 8
+	si, di := registerNumbers["rsi"], registerNumbers["rdi"]
+	step := uint64(size)
+	if m.flags.df {
+		step = -step
+	}
+	for {
+		if (inst.prefix != "") && (m.counter() == 0) {
+			return nil
+		}
+		switch name {
+		case "stos":
+			if err := m.store(m.getRegister(di, word, false), size, m.getRegister(0, size, false)); err != nil {
+				return err
+			}
+			m.setRegister(di, word, false, m.regs[di]+step)
+		case "lods":
+			v, err := m.load(m.getRegister(si, word, false), size)
+			if err != nil {
+				return err
+			}
+			m.setRegister(0, size, false, v)
+			m.setRegister(si, word, false, m.regs[si]+step)
+		case "movs":
+			v, err := m.load(m.getRegister(si, word, false), size)
+			if err != nil {
+				return err
+			}
+			if err := m.store(m.getRegister(di, word, false), size, v); err != nil {
+				return err
+			}
+			m.setRegister(si, word, false, m.regs[si]+step)
+			m.setRegister(di, word, false, m.regs[di]+step)
+		case "scas", "cmps":
+			a := m.getRegister(0, size, false)
+			if name == "cmps" {
+				v, err := m.load(m.getRegister(si, word, false), size)
+				if err != nil {
+					return err
+				}
+				a = v
+				m.setRegister(si, word, false, m.regs[si]+step)
+			}
+			b, err := m.load(m.getRegister(di, word, false), size)
+			if err != nil {
+				return err
+			}
+			m.sub(a, b, 0, size)
+			m.setRegister(di, word, false, m.regs[di]+step)
+		}
+		if inst.prefix == "" {
+			return nil
+		}
+		m.setRegister(1, word, false, m.counter()-1)
+		if ((inst.prefix == "repe") || (inst.prefix == "repz")) && !m.flags.zf && ((name == "scas") || (name == "cmps")) {
+			return nil
+		}
+		if ((inst.prefix == "repne") || (inst.prefix == "repnz")) && m.flags.zf && ((name == "scas") || (name == "cmps")) {
+			return nil
+		}
+	}
+}
+
+// st returns the FPU register st(i)
+func (m *Interpreter) st(i int) (*float64, error) {
+	if i >= len(m.fpu) {
+		return nil, fmt.Errorf("st%d is empty", i)
+	}
+	return &m.fpu[len(m.fpu)-1-i], nil
+}
+
+// fpuPush pushes a value to the FPU register stack
+func (m *Interpreter) fpuPush(v float64) error {
+	if len(m.fpu) == 8 {
+		return fmt.Errorf("the FPU register stack is full")
+	}
+	m.fpu = append(m.fpu, v)
+	return nil
+}
+
+// fpuPop pops a value from the FPU register stack
+func (m *Interpreter) fpuPop() (float64, error) {
+	if len(m.fpu) == 0 {
+		return 0, fmt.Errorf("the FPU register stack is empty")
+	}
+	v := m.fpu[len(m.fpu)-1]
+	m.fpu = m.fpu[:len(m.fpu)-1]
+	return v, nil
+}
+
+// loadFloat reads a float from memory, with the size of a float32 or a float64
+func (m *Interpreter) loadFloat(op *asmOperand) (float64, error) {
+	switch op.size {
+	case 4:
+		v, err := m.load(m.effectiveAddress(op), 4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 8:
+		v, err := m.load(m.effectiveAddress(op), 8)
+		return math.Float64frombits(v), err
+	}
+	return 0, fmt.Errorf("the size of the floating point value must be given as dword or qword")
+}
+
+// storeFloat writes a float to memory, with the size of a float32 or a float64
+func (m *Interpreter) storeFloat(op *asmOperand, v float64) error {
+	switch op.size {
+	case 4:
+		return m.store(m.effectiveAddress(op), 4, uint64(math.Float32bits(float32(v))))
+	case 8:
+		return m.store(m.effectiveAddress(op), 8, math.Float64bits(v))
+	}
+	return fmt.Errorf("the size of the floating point value must be given as dword or qword")
+}
+
+// floatToInt converts a float to an integer of the given size, rounding to the nearest even integer
+// or truncating, and returns the "integer indefinite" value if it does not fit
+func floatToInt(v float64, size int, truncate bool) uint64 {
+	if truncate {
+		v = math.Trunc(v)
+	} else {
+		v = math.RoundToEven(v)
+	}
+	limit := math.Ldexp(1, 8*size-1)
+	if math.IsNaN(v) || (v >= limit) || (v < -limit) {
+		return 1 << (8*uint(size) - 1)
+	}
+	return uint64(int64(v)) & mask(size)
+}
+
+// fpuArithmetic applies an arithmetic instruction like fsub or fdivr to two values
+func fpuArithmetic(name string, a, b float64) float64 {
+	switch name {
+	case "fadd":
+		return a + b
+	case "fsub":
+		return a - b
+	case "fsubr":
+		return b - a
+	case "fmul":
+		return a * b
+	case "fdiv":
+		return a / b
+	case "fdivr":
+		return b / a
+	}
+	return math.NaN()
+}
+
+// executeFPU runs an x87 FPU instruction
+func (m *Interpreter) executeFPU(inst *asmInstruction) error {
+	ops := inst.operands
+	name := inst.mnemonic
+	switch name {
+	case "finit", "fninit":
+		m.fpu = nil
+		return nil
+	case "fld", "fild":
+		if len(ops) != 1 {
+			return fmt.Errorf("%s needs 1 operand", name)
+		}
+		var v float64
+		switch {
+		case ops[0].kind == operandST:
+			st, err := m.st(ops[0].reg)
+			if err != nil {
+				return err
+			}
+			v = *st
+		case name == "fild":
+			if ops[0].size == 0 {
+				return fmt.Errorf("the size of the integer must be given")
+			}
+			i, err := m.get(ops[0], ops[0].size)
+			if err != nil {
+				return err
+			}
+			v = float64(signExtend(i, ops[0].size))
+		default:
+			f, err := m.loadFloat(ops[0])
+			if err != nil {
+				return err
+			}
+			v = f
+		}
+		return m.fpuPush(v)
+	case "fld1", "fldz", "fldpi":
+		return m.fpuPush(map[string]float64{"fld1": 1, "fldz": 0, "fldpi": math.Pi}[name])
+	case "fst", "fstp", "fist", "fistp":
+		if len(ops) != 1 {
+			return fmt.Errorf("%s needs 1 operand", name)
+		}
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ops[0].kind == operandST:
+			st, err := m.st(ops[0].reg)
+			if err != nil {
+				return err
+			}
+			*st = *st0
+		case strings.HasPrefix(name, "fist"):
+			if ops[0].size == 0 {
+				return fmt.Errorf("the size of the integer must be given")
+			}
+			if err := m.set(ops[0], ops[0].size, floatToInt(*st0, ops[0].size, false)); err != nil {
+				return err
+			}
+		default:
+			if err := m.storeFloat(ops[0], *st0); err != nil {
+				return err
+			}
+		}
+		if strings.HasSuffix(name, "p") {
+			_, err = m.fpuPop()
+		}
+		return err
+	case "fchs", "fabs", "fsqrt", "fsin", "fcos":
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		*st0 = map[string]func(float64) float64{"fchs": func(v float64) float64 { return -v }, "fabs": math.Abs,
+			"fsqrt": math.Sqrt, "fsin": math.Sin, "fcos": math.Cos}[name](*st0)
+		return nil
+	case "fxch":
+		i := 1
+		if len(ops) == 1 {
+			i = ops[0].reg
+		}
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		st, err := m.st(i)
+		if err != nil {
+			return err
+		}
+		*st0, *st = *st, *st0
+		return nil
+	case "fcomi", "fcomip", "fucomi", "fucomip":
+		i := 1
+		if len(ops) > 0 {
+			i = ops[len(ops)-1].reg
+		}
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		st, err := m.st(i)
+		if err != nil {
+			return err
+		}
+		m.compareFloats(*st0, *st)
+		if strings.HasSuffix(name, "p") {
+			_, err = m.fpuPop()
+		}
+		return err
+	}
+	// fadd, fsub, fsubr, fmul, fdiv and fdivr, and the versions that pop
+	arithmetic := strings.TrimSuffix(name, "p")
+	if fpuArithmetic(arithmetic, 1, 1) != fpuArithmetic(arithmetic, 1, 1) {
+		return fmt.Errorf("unsupported instruction: %s", name)
+	}
+	pop := strings.HasSuffix(name, "p")
+	switch {
+	case (len(ops) == 1) && (ops[0].kind == operandMemory):
+		// st0 = st0 op memory
+		v, err := m.loadFloat(ops[0])
+		if err != nil {
+			return err
+		}
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		*st0 = fpuArithmetic(arithmetic, *st0, v)
+		return nil
+	case (len(ops) == 2) && (ops[0].kind == operandST) && (ops[1].kind == operandST) && (ops[0].reg == 0) && !pop:
+		// st0 = st0 op sti
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		st, err := m.st(ops[1].reg)
+		if err != nil {
+			return err
+		}
+		*st0 = fpuArithmetic(arithmetic, *st0, *st)
+		return nil
+	case (len(ops) == 0) || ((len(ops) <= 2) && (ops[0].kind == operandST) && ((len(ops) == 1) || (ops[1].kind == operandST && ops[1].reg == 0))):
+		// sti = sti op st0, where i is 1 if not given, and then pop if it is the p version
+		i := 1
+		if len(ops) > 0 {
+			i = ops[0].reg
+		}
+		st0, err := m.st(0)
+		if err != nil {
+			return err
+		}
+		st, err := m.st(i)
+		if err != nil {
+			return err
+		}
+		*st = fpuArithmetic(arithmetic, *st, *st0)
+		if pop {
+			_, err = m.fpuPop()
+		}
+		return err
+	}
+	return fmt.Errorf("unsupported operands for %s", name)
+}
+
+// compareFloats sets the flags like comisd and fcomi
+func (m *Interpreter) compareFloats(a, b float64) {
+	m.flags.of, m.flags.sf = false, false
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		m.flags.zf, m.flags.pf, m.flags.cf = true, true, true
+	case a < b:
+		m.flags.zf, m.flags.pf, m.flags.cf = false, false, true
+	case a == b:
+		m.flags.zf, m.flags.pf, m.flags.cf = true, false, false
+	default:
+		m.flags.zf, m.flags.pf, m.flags.cf = false, false, false
+	}
+}
+
+// sseInstructions are the SSE2 instructions that are supported, except for moves and conversions
+var sseInstructions = map[string]func(a, b float64) float64{
+	"add": func(a, b float64) float64 { return a + b },
+	"sub": func(a, b float64) float64 { return a - b },
+	"mul": func(a, b float64) float64 { return a * b },
+	"div": func(a, b float64) float64 { return a / b },
+	"min": func(a, b float64) float64 {
+		if a < b {
+			return a
+		}
+		return b
+	},
+	"max": func(a, b float64) float64 {
+		if a > b {
+			return a
+		}
+		return b
+	},
+	"sqrt": func(a, b float64) float64 { return math.Sqrt(b) },
+}
+
+// isSSE checks if an instruction is one of the supported SSE2 instructions
+func isSSE(inst *asmInstruction) bool {
+	switch inst.mnemonic {
+	case "movsd":
+		// movsd without operands is the string instruction
+		return len(inst.operands) > 0
+	case "movq", "movd", "movapd", "movupd", "movaps", "movups", "movdqa", "movdqu", "xorpd", "xorps", "andpd", "andnpd", "orpd", "pxor",
+		"unpcklpd", "unpckhpd", "haddpd", "cvtsi2sd", "cvtsd2si", "cvttsd2si", "cvtss2sd", "cvtsd2ss", "comisd", "ucomisd":
+		return true
+	}
+	if strings.HasSuffix(inst.mnemonic, "sd") || strings.HasSuffix(inst.mnemonic, "pd") {
+		_, ok := sseInstructions[inst.mnemonic[:len(inst.mnemonic)-2]]
+		return ok
+	}
+	return false
+}
+
+// xmmValue returns the two lanes of an xmm register or 128 bits of memory
+func (m *Interpreter) xmmValue(op *asmOperand) ([2]uint64, error) {
+	switch op.kind {
+	case operandXMM:
+		return m.xmm[op.reg], nil
+	case operandMemory:
+		addr := m.effectiveAddress(op)
+		low, err := m.load(addr, 8)
+		if err != nil {
+			return [2]uint64{}, err
+		}
+		high, err := m.load(addr+8, 8)
+		return [2]uint64{low, high}, err
+	}
+	return [2]uint64{}, fmt.Errorf("an xmm register or a memory location is needed")
+}
+
+// setXMMValue sets the two lanes of an xmm register or 128 bits of memory
+func (m *Interpreter) setXMMValue(op *asmOperand, v [2]uint64) error {
+	switch op.kind {
+	case operandXMM:
+		m.xmm[op.reg] = v
+		return nil
+	case operandMemory:
+		addr := m.effectiveAddress(op)
+		if err := m.store(addr, 8, v[0]); err != nil {
+			return err
+		}
+		return m.store(addr+8, 8, v[1])
+	}
+	return fmt.Errorf("an xmm register or a memory location is needed")
+}
+
+// executeSSE runs an SSE2 instruction
+func (m *Interpreter) executeSSE(inst *asmInstruction) error {
+	ops := inst.operands
+	name := inst.mnemonic
+	if len(ops) != 2 {
+		return fmt.Errorf("%s needs 2 operands", name)
+	}
+	dst, src := ops[0], ops[1]
+	f := math.Float64frombits
+	b := math.Float64bits
+	switch name {
+	case "movsd", "movq", "movd":
+		size := 8
+		if name == "movd" {
+			size = 4
+		}
+		if src.kind == operandXMM && dst.kind == operandXMM {
+			if name == "movsd" {
+				m.xmm[dst.reg][0] = m.xmm[src.reg][0]
+			} else {
+				m.xmm[dst.reg] = [2]uint64{m.xmm[src.reg][0] & mask(size), 0}
+			}
+			return nil
+		}
+		if dst.kind == operandXMM {
+			v, err := m.get(src, size)
+			if err != nil {
+				return err
+			}
+			m.xmm[dst.reg] = [2]uint64{v, 0}
+			return nil
+		}
+		return m.set(dst, size, m.xmm[src.reg][0]&mask(size))
+	case "movapd", "movupd", "movaps", "movups", "movdqa", "movdqu":
+		v, err := m.xmmValue(src)
+		if err != nil {
+			return err
+		}
+		return m.setXMMValue(dst, v)
+	case "cvtsi2sd":
+		size := m.operandSize(src)
+		v, err := m.get(src, size)
+		if err != nil {
+			return err
+		}
+		m.xmm[dst.reg][0] = b(float64(signExtend(v, size)))
+		return nil
+	case "cvtsd2si", "cvttsd2si":
+		v, err := m.get(src, 8)
+		if err != nil {
+			return err
+		}
+		return m.set(dst, dst.size, floatToInt(f(v), dst.size, name == "cvttsd2si"))
+	case "cvtss2sd":
+		v, err := m.get(src, 4)
+		if err != nil {
+			return err
+		}
+		m.xmm[dst.reg][0] = b(float64(math.Float32frombits(uint32(v))))
+		return nil
+	case "cvtsd2ss":
+		v, err := m.get(src, 8)
+		if err != nil {
+			return err
+		}
+		m.xmm[dst.reg][0] = (m.xmm[dst.reg][0] &^ 0xffffffff) | uint64(math.Float32bits(float32(f(v))))
+		return nil
+	case "comisd", "ucomisd":
+		v, err := m.get(src, 8)
+		if err != nil {
+			return err
+		}
+		m.compareFloats(f(m.xmm[dst.reg][0]), f(v))
+		return nil
+	}
+	if dst.kind != operandXMM {
+		return fmt.Errorf("the destination of %s must be an xmm register", name)
+	}
+	if strings.HasSuffix(name, "sd") {
+		// Scalar, only the lower lane
+		v, err := m.get(src, 8)
+		if err != nil {
+			return err
+		}
+		m.xmm[dst.reg][0] = b(sseInstructions[name[:len(name)-2]](f(m.xmm[dst.reg][0]), f(v)))
+		return nil
+	}
+	v, err := m.xmmValue(src)
+	if err != nil {
+		return err
+	}
+	d := m.xmm[dst.reg]
+	switch name {
+	case "xorpd", "xorps", "pxor":
+		d = [2]uint64{d[0] ^ v[0], d[1] ^ v[1]}
+	case "andpd":
+		d = [2]uint64{d[0] & v[0], d[1] & v[1]}
+	case "andnpd":
+		d = [2]uint64{^d[0] & v[0], ^d[1] & v[1]}
+	case "orpd":
+		d = [2]uint64{d[0] | v[0], d[1] | v[1]}
+	case "unpcklpd":
+		d = [2]uint64{d[0], v[0]}
+	case "unpckhpd":
+		d = [2]uint64{d[1], v[1]}
+	case "haddpd":
+		d = [2]uint64{b(f(d[0]) + f(d[1])), b(f(v[0]) + f(v[1]))}
+	default:
+		// Packed, both lanes
+		op := sseInstructions[name[:len(name)-2]]
+		d = [2]uint64{b(op(f(d[0]), f(v[0]))), b(op(f(d[1]), f(v[1])))}
+	}
+	m.xmm[dst.reg] = d
+	return nil
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestInterpretProgram(t *testing.T) {
+	tests := []struct {
+		filename string
+		bits     int
+		stdout   string
+		exitCode int
+	}{
+		{"../samples/test02.bts", 64, "", 99},
+		{"../samples/test03.bts", 32, "Commence jump prep.\nWe're going to the ionian nebula.\n", 2},
+		{"../samples/test03.bts", 64, "Commence jump prep.\nWe're going to the ionian nebula.\n", 2},
+		{"../samples/test11.bts", 32, "JELLO\n", 0},
+		{"../fizzbuzz/fizzbuzz.bts", 64, "FizzBuzz\n1\n2\nFizz\n4\nBuzz\nFizz\n7\n8\nFizz\nBuzz\n11\nFizz\n13\n14\nFizzBuzz\n16\n", 0},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		exitCode, err := InterpretProgram(string(data), test.filename, test.bits, nil, nil, &stdout, nil)
+		if err != nil {
+			t.Errorf("%s (%d-bit): %v", test.filename, test.bits, err)
+			continue
+		}
+		if !strings.HasPrefix(stdout.String(), test.stdout) {
+			t.Errorf("%s (%d-bit): expected the output to start with:\n%s\nGot:\n%s", test.filename, test.bits, test.stdout, stdout.String())
+		}
+		if exitCode != test.exitCode {
+			t.Errorf("%s (%d-bit): expected exit code %d, got %d", test.filename, test.bits, test.exitCode, exitCode)
+		}
+	}
+}
+
+// exitCodeTest is the body of a main function, with 64-bit registers, and the exit code it should give
+type exitCodeTest struct {
+	name     string
+	code     string
+	exitCode int
+}
+
+// testExitCodes runs the given main functions on 32-bit, with the 32-bit registers, and on 64-bit.
+// The definitions are placed before the main function.
+func testExitCodes(t *testing.T, definitions string, tests []exitCodeTest) {
+	registers := strings.NewReplacer("rax", "eax", "rbx", "ebx", "rcx", "ecx", "rdx", "edx", "rsi", "esi", "rdi", "edi")
+	for _, bits := range []int{32, 64} {
+		for _, test := range tests {
+			source := definitions + "fun main\n    " + strings.Replace(test.code, "\n", "\n    ", -1) + "\nend\n"
+			if bits == 32 {
+				source = registers.Replace(source)
+			}
+			exitCode, err := InterpretProgram(source, "test.bts", bits, nil, nil, nil, nil)
+			if err != nil {
+				t.Errorf("%s (%d-bit): %v", test.name, bits, err)
+				continue
+			}
+			if exitCode != test.exitCode {
+				t.Errorf("%s (%d-bit): expected exit code %d, got %d", test.name, bits, test.exitCode, exitCode)
+			}
+		}
+	}
+}
+
+func TestInterpretRound(t *testing.T) {
+	source := "const half = 0.5\nvar x f64\n\nfun main\n    rax = 7\n    x = rax\n    x *= half\n    x = sqrt(x)\n    x *= x\n    rbx = round(x)\n    exit(rbx)\nend\n"
+	for _, bits := range []int{32, 64} {
+		if bits == 32 {
+			source = strings.Replace(strings.Replace(source, "rax", "eax", -1), "rbx", "ebx", -1)
+		}
+		exitCode, err := InterpretProgram(source, "floats.bts", bits, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("%d-bit: %v", bits, err)
+		}
+		// sqrt(3.5)^2 is rounded to 4
+		if exitCode != 4 {
+			t.Errorf("%d-bit: expected exit code 4, got %d", bits, exitCode)
+		}
+	}
+}
+
+func TestInterpreterRead(t *testing.T) {
+	asmcode := "bits 64\nsection .bss\nbuf: resb 16\nsection .text\nglobal _start\n_start:\n" +
+		"\tmov rax, 0\n\tmov rdi, 0\n\tmov rsi, buf\n\tmov rdx, 16\n\tsyscall\n" +
+		"\tmov rdx, rax\n\tmov rax, 1\n\tmov rdi, 1\n\tsyscall\n" +
+		"\tmov rdi, rax\n\tmov rax, 60\n\tsyscall\n"
+	m, err := NewInterpreter(asmcode, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	m.Stdin, m.Stdout = strings.NewReader("echo"), &stdout
+	exitCode, err := m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (stdout.String() != "echo") || (exitCode != 4) {
+		t.Errorf("Expected \"echo\" and exit code 4, got %q and exit code %d", stdout.String(), exitCode)
+	}
+}
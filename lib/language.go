@@ -1,29 +1,35 @@
 package lib
 
 import (
-	"log"
 	"strconv"
 	"strings"
 )
 
 var (
-	// TODO: Several of the operators (and the difference between signed/unsigned)
-	operators = []string{"=", "+=", "-=", "*=", "/=", "&=", "|=", "^=", "->", "<<<", ">>>", "<<", ">>", "<->", "==>", "<=="}
+	// The operators that end with "s" are the signed versions, the others are unsigned.
+	// "+c=" and "-c=" add with carry and subtract with borrow.
+	operators = []string{"=", "+=", "-=", "*=", "/=", "&=", "|=", "^=", "->", "<<<", ">>>", "<<", ">>", "<->", "==>", "<==", "%=", "=s", "*s=", "/s=", "%s=", ">>s", "/", "%", "/s", "%s", "+c=", "-c=", ".."}
 
 	comparisons = []string{"==", "!=", "<", ">", "<=", ">="}
 
-	// TODO: "use" and make the bootable kernel work somehow
-	keywords = []string{"fun", "ret", "const", "call", "extern", "end", "bootable", "counter", "address", "value", "loopwrite", "rawloop", "loop", "break", "continue", "use", "asm", "mem", "readbyte", "readword", "readdouble", "membyte", "memword", "memdouble", "var", "write", "noret"}
+	// The conditional jumps for when a comparison is true and for when it is false
+	comparisonJumps = map[string][2]string{"==": {"je", "jne"}, "!=": {"jne", "je"}, "<": {"jl", "jge"}, ">": {"jg", "jle"}, "<=": {"jle", "jg"}, ">=": {"jge", "jl"}}
+
+	// The conditional jumps for comparing f64 values, where ucomisd and fcomip set the flags like an unsigned comparison
+	floatComparisonJumps = map[string][2]string{"==": {"je", "jne"}, "!=": {"jne", "je"}, "<": {"jb", "jae"}, ">": {"ja", "jbe"}, "<=": {"jbe", "ja"}, ">=": {"jae", "jb"}}
+
+	// TODO: Make the bootable kernel work somehow
+	keywords = []string{"fun", "ret", "const", "call", "extern", "end", "bootable", "counter", "address", "value", "loopwrite", "rawloop", "loop", "break", "continue", "use", "import", "asm", "mem", "readbyte", "readword", "readdouble", "membyte", "memword", "memdouble", "var", "write", "noret", "macro", "if", "not", "for", "in", "step", "while", "do", "until", "and", "or", "struct"}
 
 	// TODO: "read"
-	builtins = []string{"len", "int", "exit", "halt", "chr", "print", "read", "syscall"} // built-in functions
+	builtins = []string{"len", "int", "exit", "halt", "chr", "print", "read", "syscall", "sizeof", "sqrt", "sin", "cos", "round", "alloc", "free"} // built-in functions
 
 	reserved = []string{"funparam", "sysparam", "a", "b", "c", "d"} // built-in lists that can be accessed with [index], or register aliases
 )
 
 func qualifier(s string) bool {
 	switch s {
-	case "byte", "BYTE", "word", "WORD", "dword", "DWORD", "ptr", "PTR", "short", "SHORT", "long", "LONG":
+	case "byte", "BYTE", "word", "WORD", "dword", "DWORD", "qword", "QWORD", "tword", "TWORD", "ptr", "PTR", "short", "SHORT", "long", "LONG":
 		return true
 	}
 	return false
@@ -72,10 +78,15 @@ func removecomments(s string) string {
 	return s
 }
 
-// Replace \n, \t, \r and \0 with the appropriate values
+// Replace \n, \t, \r and \0 with the appropriate values.
+// The replacements are done in a fixed order, so that the generated code is the same every time.
 func stringReplacements(s string) string {
-	rtable := map[string]int{"\\t": 9, "\\n": 10, "\\r": 13, "\\0": 0}
-	for key, value := range rtable {
+	rtable := []struct {
+		key   string
+		value int
+	}{{"\\t", 9}, {"\\n", 10}, {"\\r", 13}, {"\\0", 0}}
+	for _, r := range rtable {
+		key, value := r.key, r.value
 		if strings.Contains(s, key) {
 			if strings.Contains(s, key+"\"") {
 				s = strings.Replace(s, key+"\"", "\", "+strconv.Itoa(value), -1)
@@ -96,22 +107,22 @@ func (config *TargetConfig) reservedAndValue(st Statement) string {
 	if st[0].Value == "funparam" {
 		paramoffset, err := strconv.Atoi(st[1].Value)
 		if err != nil {
-			log.Fatalln("Error: Invalid offset for", st[0].Value+":", st[1].Value)
+			fatal("Error: Invalid offset for", st[0].Value+":", st[1].Value)
 		}
 		return config.paramnum2reg(paramoffset)
 	} else if st[0].Value == "sysparam" {
 		paramoffset, err := strconv.Atoi(st[1].Value)
 		if err != nil {
-			log.Fatalln("Error: Invalid offset for", st[0].Value+":", st[1].Value)
+			fatal("Error: Invalid offset for", st[0].Value+":", st[1].Value)
 		}
 		if paramoffset >= len(config.interruptParameterRegisters) {
-			log.Fatalln("Error: Invalid offset for", st[0].Value+":", st[1].Value, "(too high)")
+			fatal("Error: Invalid offset for", st[0].Value+":", st[1].Value, "(too high)")
 		}
 		return config.interruptParameterRegisters[paramoffset]
 	} else {
 		// TODO: Implement support for other lists
-		log.Fatalln("Error: Can only handle \"funparam\" and \"sysparam\" reserved words.")
+		fatal("Error: Can only handle \"funparam\" and \"sysparam\" reserved words.")
 	}
-	log.Fatalln("Error: Unable to handle reserved word and value:", st[0].Value, st[1].Value)
+	fatal("Error: Unable to handle reserved word and value:", st[0].Value, st[1].Value)
 	return ""
 }
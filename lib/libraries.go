@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CLibrary is a C library that has been pulled in with "use" or "import",
+// with the compilation and linking flags that were found by pkg-config.
+type CLibrary struct {
+	Name   string
+	CFlags string
+	Libs   string
+}
+
+// findLibrary looks up a C library by name. It is replaced when testing.
+var findLibrary = pkgConfig
+
+// pkgConfig looks up a C library with pkg-config
+func pkgConfig(name string) (*CLibrary, error) {
+	if _, err := exec.LookPath("pkg-config"); err != nil {
+		return nil, err
+	}
+	if err := exec.Command("pkg-config", "--exists", name).Run(); err != nil {
+		return nil, fmt.Errorf("pkg-config could not find %s", name)
+	}
+	cflags, err := exec.Command("pkg-config", "--cflags", name).Output()
+	if err != nil {
+		return nil, err
+	}
+	libs, err := exec.Command("pkg-config", "--libs", name).Output()
+	if err != nil {
+		return nil, err
+	}
+	return &CLibrary{name, strings.TrimSpace(string(cflags)), strings.TrimSpace(string(libs))}, nil
+}
+
+// LibraryFlags returns the combined compilation and linking flags for the given C libraries,
+// as lines that can be sourced by a shell script.
+func LibraryFlags(libraries []*CLibrary) string {
+	var cflags, libs, names []string
+	for _, library := range libraries {
+		names = append(names, library.Name)
+		if library.CFlags != "" {
+			cflags = append(cflags, library.CFlags)
+		}
+		if library.Libs != "" {
+			libs = append(libs, library.Libs)
+		}
+	}
+	return fmt.Sprintf("# C libraries: %s\nBTS_CFLAGS=\"%s\"\nBTS_LIBS=\"%s\"\n", strings.Join(names, " "), strings.Join(cflags, " "), strings.Join(libs, " "))
+}
+
+// AddMissingExterns declares every function that is called, but not defined, as an external symbol.
+// This is used when C libraries are pulled in, so that their functions can be called directly,
+// with or without arguments. The defined function is used for checking if a name is defined elsewhere.
+func (config *TargetConfig) AddMissingExterns(tokens []Token, defined func(string) bool) []Token {
+	var externs []Token
+	// First find the names that are already declared with "extern"
+	declared := externNames(tokens)
+	// Then find the names that are called, but not declared or defined
+	for _, called := range calledNames(tokens) {
+		if has(declared, called.Value) || defined(called.Value) {
+			continue
+		}
+		declared = append(declared, called.Value)
+		externs = append(externs, Token{KEYWORD, "extern", called.Line, ""}, called, Token{SEP, ";", called.Line, ""})
+	}
+	return append(externs, tokens...)
+}
+
+// calledNames returns the names of the functions that are called in the given tokens, like "name", "call name"
+// and "name(rax, 5)". The fields of structs that are declared over several lines, like "x u16", are skipped,
+// since they have the same shape as a call with one argument.
+func calledNames(tokens []Token) []Token {
+	var (
+		called    []Token
+		statement []Token
+		inStruct  bool
+	)
+	for _, t := range tokens {
+		if t.T != SEP {
+			statement = append(statement, t)
+			continue
+		}
+		switch {
+		case len(statement) == 0:
+		case (statement[0].T == KEYWORD) && (statement[0].Value == "struct"):
+			last := statement[len(statement)-1]
+			inStruct = (len(statement) > 1) && ((last.T != KEYWORD) || (last.Value != "end"))
+		case inStruct:
+			inStruct = (statement[0].T != KEYWORD) || (statement[0].Value != "end")
+		case (statement[0].T == VALIDNAME) && ((len(statement) == 1) || callArgument(statement[1])):
+			called = append(called, statement[0])
+		case (len(statement) == 2) && (statement[0].T == KEYWORD) && (statement[0].Value == "call") && (statement[1].T == VALIDNAME):
+			called = append(called, statement[1])
+		}
+		statement = []Token{}
+	}
+	return called
+}
+
+// externNames returns the names that are declared with "extern" in the given tokens
+func externNames(tokens []Token) []string {
+	var (
+		declared  []string
+		statement []Token
+	)
+	for _, t := range tokens {
+		if t.T != SEP {
+			statement = append(statement, t)
+			continue
+		}
+		if (len(statement) == 2) && (statement[0].T == KEYWORD) && (statement[0].Value == "extern") {
+			declared = append(declared, statement[1].Value)
+		}
+		statement = []Token{}
+	}
+	return declared
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAddMissingExterns(t *testing.T) {
+	defer quiet()()
+	findLibrary = func(name string) (*CLibrary, error) {
+		if name != "sdl2" {
+			return nil, fmt.Errorf("pkg-config could not find %s", name)
+		}
+		return &CLibrary{name, "-I/usr/include/SDL2", "-lSDL2"}, nil
+	}
+	defer func() { findLibrary = pkgConfig }()
+
+	source := `use sdl2
+
+struct Point
+    x u16
+    y u16
+end
+
+fun main
+    SDL_Init(0x20)
+    SDL_Delay(rbx)
+    SDL_Quit
+end
+`
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, _, _, flags, err := config.Compile(source, "sdl.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"extern SDL_Init", "extern SDL_Delay", "extern SDL_Quit", "call SDL_Init"} {
+		if !strings.Contains(asmcode, expected) {
+			t.Errorf("expected %q in the assembly:\n%s", expected, asmcode)
+		}
+	}
+	if strings.Contains(asmcode, "extern x") || strings.Contains(asmcode, "extern y") {
+		t.Errorf("expected no external symbols for the fields of the struct:\n%s", asmcode)
+	}
+	if !strings.Contains(flags, "BTS_LIBS=\"-lSDL2\"") {
+		t.Errorf("expected the flags for the library, got:\n%s", flags)
+	}
+}
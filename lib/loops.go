@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// The longest possible x86 instruction, in bytes
+	maxInstructionLength = 15
+	// How far back the loop instruction can jump, in bytes
+	shortJumpRange = 128
+)
+
+// instructionBytes returns the largest possible size of the given assembly code, in bytes
+func instructionBytes(asmcode string) int {
+	size := 0
+	for _, line := range strings.Split(asmcode, "\n") {
+		if pos := strings.Index(line, ";"); pos != -1 {
+			line = line[:pos]
+		}
+		line = strings.TrimSpace(line)
+		if (line == "") || strings.HasSuffix(line, ":") {
+			continue
+		}
+		size += maxInstructionLength
+	}
+	return size
+}
+
+// inStructuredLoop checks if the current loop is a for, while or do loop,
+// which has a label for "continue" and keeps the code for the next round in loopTail
+func (ps *ProgramState) inStructuredLoop() bool {
+	for _, prefix := range []string{forPrefix, forListPrefix, whilePrefix, doPrefix} {
+		if strings.HasPrefix(ps.inLoop, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listIndexRegister returns the register that is used as the index when looping over a list
+func (config *TargetConfig) listIndexRegister() string {
+	if config.PlatformBits == 16 {
+		// cx can not be used in 16-bit memory expressions
+		return "si"
+	}
+	return config.counterRegister()
+}
+
+// loopSavedRegister returns the register that the current loop keeps on the stack, if any
+func (config *TargetConfig) loopSavedRegister(ps *ProgramState) string {
+	switch {
+	case strings.HasPrefix(ps.inLoop, rawloopPrefix), strings.HasPrefix(ps.inLoop, endlessloopPrefix), strings.HasPrefix(ps.inLoop, forPrefix),
+		strings.HasPrefix(ps.inLoop, whilePrefix), strings.HasPrefix(ps.inLoop, doPrefix):
+		return ""
+	case strings.HasPrefix(ps.inLoop, forListPrefix):
+		return config.listIndexRegister()
+	}
+	return config.counterRegister()
+}
+
+// wrapValue returns the given value as it is represented in a register with the given number of bits
+func wrapValue(value int64, bits int) string {
+	if bits == 64 {
+		return strconv.FormatInt(value, 10)
+	}
+	max := int64(1) << uint(bits)
+	return strconv.FormatInt(((value%max)+max)%max, 10)
+}
+
+// parseNumber parses a number in a range, or fails with an error message
+func parseNumber(s string) int64 {
+	i, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		fatal("Error: Not a number in a for loop:", s)
+	}
+	return i
+}
+
+// emitForRange starts a loop over a range of numbers, like: for rbx in 1..10 step 2
+func emitForRange(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inLoop != "" {
+		fatal("Error: Already in a loop (nested loops are to be implemented)")
+	}
+	reg := st[1].Value
+	bits := registerBits(reg)
+	if bits == 0 {
+		fatal("Error: Can only loop with general purpose registers, not", reg)
+	}
+	first, last, step := parseNumber(st[3].Value), parseNumber(st[5].Value), int64(1)
+	description := reg + " in " + st[3].Value + ".." + st[5].Value
+	if len(st) == 8 {
+		step = parseNumber(st[7].Value)
+		description += " step " + st[7].Value
+	}
+	descending := first > last
+	switch {
+	case step == 0:
+		fatal("Error: The step can not be 0 in: for", description)
+	case (step < 0) && (first < last):
+		fatal("Error: The step is negative, but the range is ascending in: for", description)
+	case step < 0:
+		step = -step
+	}
+	for _, value := range []int64{first, last} {
+		if (bits < 64) && ((value < -(int64(1) << uint(bits-1))) || (value >= int64(1)<<uint(bits))) {
+			fatal("Error:", value, "does not fit in", reg, "in: for", description)
+		}
+	}
+	// Find the last value that is reached, in case the range does not end on a step
+	span := last - first
+	if descending {
+		span = first - last
+	}
+	rounds := span / step
+	after := first + (rounds+1)*step
+	if descending {
+		last = first - rounds*step
+		after = first - (rounds+1)*step
+	} else {
+		last = first + rounds*step
+	}
+
+	label := forPrefix + ps.newLoopLabel()
+	ps.inLoop = label
+	ps.loopSize = 0
+
+	asmcode := "\t;--- for " + description + " ---\n"
+	if first == 0 {
+		asmcode += "\txor " + reg + ", " + reg + "\t\t; " + reg + " = 0\n"
+	} else {
+		asmcode += "\tmov " + reg + ", " + strconv.FormatInt(first, 10) + "\t\t; " + reg + " = " + strconv.FormatInt(first, 10) + "\n"
+	}
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+
+	// Find the code for taking the next step and checking if the loop is done
+	stepcode := ""
+	switch {
+	case descending && (step == 1):
+		stepcode = "\tdec " + reg + "\t\t\t\t; " + reg + "--\n"
+	case descending:
+		stepcode = "\tsub " + reg + ", " + strconv.FormatInt(step, 10) + "\t\t\t; " + reg + " -= " + strconv.FormatInt(step, 10) + "\n"
+	case step == 1:
+		stepcode = "\tinc " + reg + "\t\t\t\t; " + reg + "++\n"
+	default:
+		stepcode = "\tadd " + reg + ", " + strconv.FormatInt(step, 10) + "\t\t\t; " + reg + " += " + strconv.FormatInt(step, 10) + "\n"
+	}
+	end := wrapValue(after, bits)
+	if end != "0" {
+		// The flags are already set if the value after the last one is 0
+		if (bits == 64) && ((after < -(int64(1) << 31)) || (after >= int64(1)<<31)) {
+			fatal("Error: The range is too large to compare with a 64-bit register in: for", description)
+		}
+		stepcode += "\tcmp " + reg + ", " + end + "\t\t\t; check if the loop is done\n"
+	}
+	ps.loopTail = stepcode + "\tjne " + label + "\t\t\t\t; loop until " + reg + " is " + end + "\n"
+	ps.loopTailShort = ""
+	if (reg == config.counterRegister()) && descending && (step == 1) && (last == 1) {
+		// Counting down to 1 is what the loop instruction does
+		ps.loopTailShort = "\tloop " + label + "\t\t\t\t; loop until " + reg + " is zero\n"
+	}
+	return asmcode
+}
+
+// emitForList starts a loop over the elements of a constant, like: for rax in primes
+func emitForList(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inLoop != "" {
+		fatal("Error: Already in a loop (nested loops are to be implemented)")
+	}
+	reg, name := st[1].Value, st[3].Value
+	if registerBits(reg) == 0 {
+		fatal("Error: Can only loop with general purpose registers, not", reg)
+	}
+	if _, ok := ps.variables[name]; ok || !has(ps.definedNames, name) {
+		fatal("Error: Can only loop over the elements of a constant, not", name)
+	}
+	index := config.listIndexRegister()
+	if regFamily(reg) == regFamily(index) {
+		fatal("Error:", index, "is used as the index when looping over", name+", use another register than", reg)
+	}
+	// The size of each element, the same as when declaring the constant
+	elementBits := 8
+	if !has(ps.dataNotValueTypes, name) {
+		switch config.PlatformBits {
+		case 64:
+			elementBits = 64
+		case 32:
+			elementBits = 16
+		}
+	}
+	elementSize := strconv.Itoa(elementBits / 8)
+	address := "[" + name + "+" + index + "*" + elementSize + "]"
+	count := "_length_of_" + name + "/" + elementSize
+	if elementBits == 8 {
+		address = "[" + name + "+" + index + "]"
+		count = "_length_of_" + name
+	}
+
+	label := forListPrefix + ps.newLoopLabel()
+	ps.inLoop = label
+	ps.loopSize = 0
+
+	asmcode := "\t;--- for " + reg + " in " + name + " ---\n"
+	asmcode += "\txor " + index + ", " + index + "\t\t; index = 0\n"
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+	if registerBits(reg) > elementBits {
+		asmcode += "\tmovzx " + reg + ", " + sizeQualifier(elementBits) + " " + address + "\t\t; " + reg + " = " + name + "[" + index + "]\n"
+	} else {
+		asmcode += "\tmov " + reg + ", " + sizeQualifier(registerBits(reg)) + " " + address + "\t\t; " + reg + " = " + name + "[" + index + "]\n"
+	}
+	asmcode += "\tpush " + index + "\t\t\t; save the index\n"
+
+	ps.loopTail = "\tpop " + index + "\t\t\t\t; restore the index\n"
+	ps.loopTail += "\tinc " + index + "\t\t\t\t; next element\n"
+	ps.loopTail += "\tcmp " + index + ", " + count + "\t; check if the loop is done\n"
+	ps.loopTail += "\tjne " + label + "\t\t\t\t; loop until all elements have been used\n"
+	ps.loopTailShort = ""
+	return asmcode
+}
+
+// endLoop ends the current for or while loop, using the loop instruction if the loop body is short enough
+func (config *TargetConfig) endLoop(ps *ProgramState) string {
+	asmcode := ps.inLoop + "_continue:\t\t\t\t; next round of loop " + ps.inLoop + "\n"
+	if (ps.loopTailShort != "") && (ps.loopSize+instructionBytes(ps.loopTailShort) <= shortJumpRange) {
+		asmcode += ps.loopTailShort
+	} else {
+		asmcode += ps.loopTail
+	}
+	asmcode += ps.inLoop + "_end:\t\t\t\t; end of loop " + ps.inLoop + "\n"
+	asmcode += "\t;--- end of loop " + ps.inLoop + " ---\n"
+	ps.inLoop = ""
+	ps.loopTail = ""
+	ps.loopTailShort = ""
+	ps.loopSize = 0
+	return asmcode
+}
+
+// conditionGroups splits a condition like "rax > 1 and rbx < 2 or rcx == 3" into groups of comparisons
+// that are joined with "and", where the groups are joined with "or". "and" binds tighter than "or".
+func conditionGroups(condition []Token) [][]Statement {
+	groups := [][]Statement{{}}
+	tokens := condition
+	for len(tokens) > 0 {
+		if (len(tokens) < 3) || (tokens[1].T != COMPARISON) {
+			fatal("Error: Expected a comparison, like \"rax > 3\", in the condition:", tokensString(condition))
+		}
+		last := len(groups) - 1
+		groups[last] = append(groups[last], Statement(tokens[:3]))
+		tokens = tokens[3:]
+		if len(tokens) == 0 {
+			break
+		}
+		if (len(tokens) == 1) || (tokens[0].T != KEYWORD) || ((tokens[0].Value != "and") && (tokens[0].Value != "or")) {
+			fatal("Error: Expected \"and\" or \"or\" between the comparisons in the condition:", tokensString(condition))
+		}
+		if tokens[0].Value == "or" {
+			groups = append(groups, []Statement{})
+		}
+		tokens = tokens[1:]
+	}
+	return groups
+}
+
+// tokensString returns the values of the given tokens, separated by spaces
+func tokensString(tokens []Token) string {
+	var values []string
+	for _, t := range tokens {
+		values = append(values, t.Value)
+	}
+	return strings.Join(values, " ")
+}
+
+// jumpUnless returns code that jumps to the given label if the condition is false, and continues
+// after the code if it is true. The comparisons are only made until the outcome is known.
+func (config *TargetConfig) jumpUnless(ps *ProgramState, tokens []Token, label string) string {
+	groups := conditionGroups(tokens)
+	asmcode := ""
+	trueLabel := ""
+	for i, group := range groups {
+		lastGroup := i == len(groups)-1
+		// Where to go if a comparison in this group is false
+		next := label
+		if !lastGroup {
+			next = ps.newIfLabel() + "_or"
+		}
+		for j, cmp := range group {
+			jumps := config.comparisonJumps(ps, cmp[0], cmp[1].Value, cmp[2])
+			asmcode += config.compare(ps, cmp[0], cmp[2], tokensString(cmp))
+			if !lastGroup && (j == len(group)-1) {
+				// All the comparisons in this group are true, so the whole condition is true
+				if trueLabel == "" {
+					trueLabel = ps.newIfLabel() + "_true"
+				}
+				asmcode += "\t" + jumps[0] + " " + trueLabel + "\n"
+			} else {
+				asmcode += "\t" + jumps[1] + " " + next + "\n"
+			}
+		}
+		if !lastGroup {
+			asmcode += next + ":\n"
+		}
+	}
+	if trueLabel != "" {
+		asmcode += trueLabel + ":\n"
+	}
+	return asmcode
+}
+
+// emitWhile starts a loop that runs as long as the condition is true, like: while rax < 10
+func emitWhile(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inLoop != "" {
+		fatal("Error: Already in a loop (nested loops are to be implemented)")
+	}
+	label := whilePrefix + ps.newLoopLabel()
+	ps.inLoop = label
+	ps.loopSize = 0
+
+	asmcode := "\t;--- while " + tokensString(st[1:]) + " ---\n"
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+	asmcode += config.jumpUnless(ps, st[1:], label+"_end")
+
+	// "continue" jumps to the tail, which jumps back to the condition at the top
+	ps.loopTail = "\tjmp " + label + "\t\t\t\t; check the condition again\n"
+	ps.loopTailShort = ""
+	return asmcode
+}
+
+// emitDo starts a loop that runs until the condition after "until" is true
+func emitDo(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inLoop != "" {
+		fatal("Error: Already in a loop (nested loops are to be implemented)")
+	}
+	label := doPrefix + ps.newLoopLabel()
+	ps.inLoop = label
+	ps.loopSize = 0
+
+	asmcode := "\t;--- do ---\n"
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+	return asmcode
+}
+
+// emitUntil ends a do loop, which runs again unless the condition is true
+func emitUntil(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if !strings.HasPrefix(ps.inLoop, doPrefix) {
+		fatal("Error: \"until\" can only be used to end a loop that was started with \"do\"")
+	}
+	label := ps.inLoop
+	asmcode := label + "_continue:\t\t\t\t; check if loop " + label + " is done\n"
+	asmcode += config.jumpUnless(ps, st[1:], label)
+	asmcode += label + "_end:\t\t\t\t; end of loop " + label + "\n"
+	asmcode += "\t;--- end of loop " + label + " ---\n"
+	ps.inLoop = ""
+	ps.loopSize = 0
+	return asmcode
+}
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestInterpretLoops(t *testing.T) {
+	defer quiet()()
+	testExitCodes(t, "const primes = 2, 3, 5, 7, 11\n", []exitCodeTest{
+		{"for range", "rdx = 0\nfor rbx in 1..10\n    rdx += rbx\nend\nexit(rdx)", 55},
+		{"for range, counting down", "rdx = 0\nfor rbx in 10..0 step 2\n    rdx += rbx\nend\nexit(rdx)", 30},
+		{"for range with break", "rdx = 0\nfor rbx in 1..10\n    break (rbx > 4)\n    rdx += rbx\nend\nexit(rdx)", 10},
+		{"for range with continue", "rdx = 0\nfor rbx in 1..10\n    continue (rbx < 9)\n    rdx += rbx\nend\nexit(rdx)", 19},
+		{"for list", "rdx = 0\nfor rax in primes\n    rdx += rax\nend\nexit(rdx)", 28},
+		{"for list with break", "rdx = 0\nfor rax in primes\n    break (rax == 7)\n    rdx += rax\nend\nexit(rdx)", 10},
+		{"while", "rax = 0\nrbx = 0\nwhile rax < 10\n    rax++\n    rbx += 2\nend\nexit(rbx)", 20},
+		{"while, never entered", "rax = 10\nrbx = 0\nwhile rax < 10\n    rax++\n    rbx += 2\nend\nexit(rbx)", 0},
+		{"while with and", "rax = 0\nrbx = 5\nwhile rax < 10 and rbx != 0\n    rax++\n    rbx--\nend\nrcx = rax\nexit(rcx)", 5},
+		{"while with or", "rax = 0\nrbx = 0\nwhile rax < 3 or rbx == 1\n    rax++\nend\nrcx = rax\nexit(rcx)", 3},
+		{"do until", "rax = 0\ndo\n    rax += 3\nuntil rax == 9\nrbx = rax\nexit(rbx)", 9},
+		{"do until, runs once", "rax = 10\ndo\n    rax++\nuntil rax > 5\nrbx = rax\nexit(rbx)", 11},
+	})
+}
@@ -0,0 +1,287 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// How deep macros can expand other macros before giving up
+const maxMacroDepth = 32
+
+// Macro is a named sequence of statements that is expanded where it is used:
+//
+//	macro name(arg1, arg2)
+//	    ...
+//	end
+type Macro struct {
+	Name   string
+	Params []string
+	Body   []string
+}
+
+// macroExpander keeps track of the defined macros while the macros in a program are expanded
+type macroExpander struct {
+	macros     map[string]*Macro
+	defining   *Macro // the macro that is currently being defined, if any
+	depth      int    // block depth within the macro that is currently being defined
+	nesting    int    // how many macro expansions deep we currently are
+	expansions int    // used for generating unique label names
+}
+
+func newMacroExpander() *macroExpander {
+	return &macroExpander{macros: make(map[string]*Macro)}
+}
+
+// opensBlock checks if the given words (from one line) start a block that is closed with "end" or "until"
+func opensBlock(words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	if has([]string{"fun", "loop", "rawloop", "macro", "if", "for", "while", "do"}, words[0]) || inlineKeyword(words[0]) {
+		return true
+	}
+	if words[0] == "struct" {
+		// A struct can also be declared on one line, like: struct Point x u16, y u16 end
+		return words[len(words)-1] != "end"
+	}
+	// A comparison on its own starts an if block, like: a > 3
+	return (len(words) == 3) && has(comparisons, words[1])
+}
+
+// closesBlock checks if the given words (from one line) end a block, with "end" or with "until" and a condition
+func closesBlock(words []string) bool {
+	return ((len(words) == 1) && (words[0] == "end")) || ((len(words) > 1) && (words[0] == "until"))
+}
+
+// splitArgs splits "name(a, b)" into "name" and ["a", "b"]
+func splitArgs(s string) (string, []string, error) {
+	s = strings.TrimSpace(s)
+	pos := strings.Index(s, "(")
+	if pos == -1 {
+		return s, []string{}, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return "", nil, errors.New("Missing \")\" in: " + s)
+	}
+	name := strings.TrimSpace(s[:pos])
+	inner := strings.TrimSpace(s[pos+1 : len(s)-1])
+	if inner == "" {
+		return name, []string{}, nil
+	}
+	args := maps(strings.Split(inner, ","), strings.TrimSpace)
+	return name, args, nil
+}
+
+// define starts a new macro definition, given the line that follows the "macro" keyword
+func (mx *macroExpander) define(header string) error {
+	name, params, err := splitArgs(header)
+	if err != nil {
+		return err
+	}
+	if !validName(name) {
+		return errors.New("Invalid macro name: " + name)
+	}
+	if _, isFlag := flagJumps[name]; isFlag || has(keywords, name) || has(builtins, name) || has(registers, name) || has(reserved, name) {
+		return errors.New("Can not use " + name + " as a macro name, it is already taken")
+	}
+	if _, ok := mx.macros[name]; ok {
+		return errors.New("Macro is already defined: " + name)
+	}
+	for _, param := range params {
+		if !validName(param) {
+			return errors.New("Invalid parameter name for macro " + name + ": " + param)
+		}
+	}
+	mx.defining = &Macro{name, params, []string{}}
+	mx.depth = 0
+	return nil
+}
+
+// collect adds a line to the macro that is being defined.
+// Returns true if the line was the "end" of the macro definition.
+func (mx *macroExpander) collect(line string) (bool, error) {
+	words := strings.Fields(line)
+	if len(words) > 0 && words[0] == "macro" {
+		return false, errors.New("Macros can not be defined within macro " + mx.defining.Name)
+	}
+	if len(words) > 0 && words[0] == "fun" {
+		return false, errors.New("Functions can not be defined within macro " + mx.defining.Name)
+	}
+	if (mx.depth == 0) && (len(words) == 1) && (words[0] == "end") {
+		mx.macros[mx.defining.Name] = mx.defining
+		mx.defining = nil
+		return true, nil
+	} else if (mx.depth > 0) && closesBlock(words) {
+		mx.depth--
+	} else if opensBlock(words) {
+		mx.depth++
+	}
+	mx.defining.Body = append(mx.defining.Body, line)
+	return false, nil
+}
+
+// invocation checks if the given line is a call to a defined macro
+func (mx *macroExpander) invocation(line string) (*Macro, bool) {
+	name := line
+	if pos := strings.IndexAny(line, "( "); pos != -1 {
+		name = line[:pos]
+	}
+	m, ok := mx.macros[name]
+	return m, ok
+}
+
+// isWordChar checks if the given byte can be a part of a name or a label
+func isWordChar(c byte) bool {
+	return (c == '_') || (c == '.') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// replaceWords replaces whole words in a line, outside of strings, according to the given map
+func replaceWords(line string, replacements map[string]string) string {
+	var (
+		sb       strings.Builder
+		instring bool
+	)
+	for i := 0; i < len(line); {
+		c := line[i]
+		if c == '"' {
+			instring = !instring
+		}
+		if instring || !isWordChar(c) {
+			sb.WriteByte(c)
+			i++
+			continue
+		}
+		j := i
+		for j < len(line) && isWordChar(line[j]) {
+			j++
+		}
+		word := line[i:j]
+		if replacement, ok := replacements[word]; ok {
+			sb.WriteString(replacement)
+		} else {
+			sb.WriteString(word)
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+// expand returns the lines of the given macro, with the parameters substituted by the arguments
+// found in the line and with labels renamed so that they are unique for this expansion.
+func (mx *macroExpander) expand(m *Macro, line string) ([]string, error) {
+	_, args, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(m.Params) {
+		return nil, fmt.Errorf("Macro %s takes %d argument(s), but was given %d: %s", m.Name, len(m.Params), len(args), line)
+	}
+	mx.expansions++
+	replacements := make(map[string]string)
+	// Rename the labels that are defined within the macro body
+	suffix := "_m" + strconv.Itoa(mx.expansions)
+	for _, bodyline := range m.Body {
+		for _, word := range strings.Fields(bodyline) {
+			if strings.HasSuffix(word, ":") && validName(strings.TrimPrefix(word[:len(word)-1], ".")) {
+				label := word[:len(word)-1]
+				replacements[label] = label + suffix
+			}
+		}
+	}
+	for i, param := range m.Params {
+		replacements[param] = args[i]
+	}
+	// Find the indentation that all the lines in the body have in common
+	indentation := -1
+	for _, bodyline := range m.Body {
+		if strings.TrimSpace(bodyline) == "" {
+			continue
+		}
+		if n := len(bodyline) - len(strings.TrimLeft(bodyline, " \t")); indentation == -1 || n < indentation {
+			indentation = n
+		}
+	}
+	lines := make([]string, len(m.Body))
+	for i, bodyline := range m.Body {
+		if len(bodyline) >= indentation && indentation > 0 {
+			bodyline = bodyline[indentation:]
+		}
+		lines[i] = replaceWords(bodyline, replacements)
+	}
+	return lines, nil
+}
+
+// ExpandMacros takes Battlestar source code and returns the same code, but with
+// the macro definitions removed and all macro invocations expanded.
+// The same expansion is done by Tokenize, when compiling.
+func ExpandMacros(code string) (string, error) {
+	lines, _, err := newMacroExpander().expandLines(strings.Split(code, "\n"))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// expandLines expands the macros in the given lines, recursively. For each of the returned lines,
+// the index of the line it comes from is also returned, which is the invocation for expanded macros.
+func (mx *macroExpander) expandLines(lines []string) ([]string, []int, error) {
+	var (
+		expanded []string
+		origins  []int
+		inline   = inlineLines(lines)
+	)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(removecomments(line))
+		words := strings.Fields(trimmed)
+		firstword := ""
+		if len(words) > 0 {
+			firstword = words[0]
+		}
+		if mx.defining != nil {
+			// Keep the indentation of the macro body, for readability
+			if _, err := mx.collect(strings.TrimRight(removecomments(line), " \t")); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		// Leave inline C, inline assembly and the other inline languages alone
+		switch {
+		case inline[i]:
+		case firstword == "macro":
+			if err := mx.define(strings.TrimSpace(trimmed[len("macro"):])); err != nil {
+				return nil, nil, err
+			}
+			continue
+		default:
+			if m, ok := mx.invocation(trimmed); ok {
+				body, err := mx.expand(m, trimmed)
+				if err != nil {
+					return nil, nil, err
+				}
+				mx.nesting++
+				if mx.nesting > maxMacroDepth {
+					return nil, nil, errors.New("Macros are nested too deeply when expanding " + m.Name)
+				}
+				body, _, err = mx.expandLines(body)
+				mx.nesting--
+				if err != nil {
+					return nil, nil, err
+				}
+				indentation := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+				for _, bodyline := range body {
+					expanded = append(expanded, indentation+bodyline)
+					origins = append(origins, i)
+				}
+				continue
+			}
+		}
+		expanded = append(expanded, line)
+		origins = append(origins, i)
+	}
+	if mx.defining != nil && mx.nesting == 0 {
+		return nil, nil, errors.New("Missing \"end\" for macro " + mx.defining.Name)
+	}
+	return expanded, origins, nil
+}
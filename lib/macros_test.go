@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandMacros(t *testing.T) {
+	code := `macro twice(reg)
+    reg++
+    asm 16 again:
+    reg++
+end
+
+twice(ax)
+twice(bx)`
+	expanded, err := ExpandMacros(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(expanded, "macro") || strings.Count(expanded, "ax++") != 2 || strings.Count(expanded, "bx++") != 2 {
+		t.Errorf("Unexpected macro expansion:\n%s\n", expanded)
+	}
+	if !strings.Contains(expanded, "again_m1:") || !strings.Contains(expanded, "again_m2:") {
+		t.Errorf("Labels within macros should be unique for every expansion:\n%s\n", expanded)
+	}
+	if _, err := ExpandMacros("macro oops\nax = 1\n"); err == nil {
+		t.Errorf("A macro without an end should be an error\n")
+	}
+	for _, name := range []string{"carry", "if", "not", "print", "rax"} {
+		if _, err := ExpandMacros("macro " + name + "(reg)\nreg = 1\nend\n"); err == nil {
+			t.Errorf("%s should not be allowed as a macro name\n", name)
+		}
+	}
+}
+
+func TestExpandMacrosLikeCompile(t *testing.T) {
+	defer quiet()()
+	// Tokenize and ExpandMacros (for -E) expand the macros in the same way,
+	// so compiling the output of ExpandMacros must give the same assembly
+	source := `macro clear(reg)
+    reg = 0
+end
+
+macro count(reg, n)
+    clear(reg)
+    loop n
+        reg++ // one more
+        if reg == 3
+            asm 64 done:
+            break
+        end
+    end
+end
+
+const msg = "macro count(rax, 2)"
+
+fun main
+    count(a, 5)
+        count(b, 2)
+    print_it(a)
+end
+
+inline_c
+    void print_it(long x) {
+        int count = 1; // not the macro
+    }
+end
+`
+	expanded, err := ExpandMacros(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bits := range []int{32, 64} {
+		config, err := NewTargetConfig(bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asmcode, ccode, _, _, err := config.Compile(source, "macros.bts", nil, false, NewProgramState())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expandedAsmcode, expandedCcode, _, _, err := config.Compile(expanded, "macros.bts", nil, false, NewProgramState())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (bits == 64) && (!strings.Contains(asmcode, "done_m1:") || !strings.Contains(asmcode, "done_m3:")) {
+			t.Errorf("expected the labels of both expansions of count in the assembly:\n%s", asmcode)
+		}
+		if asmcode != expandedAsmcode {
+			t.Errorf("The %d-bit assembly differs when compiling the expanded macros:\n%s", bits, firstDifference(asmcode, expandedAsmcode))
+		}
+		if ccode != expandedCcode {
+			t.Errorf("The %d-bit C code differs when compiling the expanded macros:\n%s", bits, firstDifference(ccode, expandedCcode))
+		}
+	}
+}
+
+func TestMacroErrorLine(t *testing.T) {
+	defer quiet()()
+	source := "macro oops(reg)\n    reg = 1\n    reg [<-] 2\nend\n\nfun main\n    oops(rax)\nend\n"
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _, err = config.Compile(source, "oops.bts", nil, false, NewProgramState())
+	// The statements of an expanded macro have the line of the invocation
+	if compileError, ok := err.(*CompileError); !ok || (compileError.Line != 7) {
+		t.Errorf("expected an error at line 7, got %#v", err)
+	}
+}
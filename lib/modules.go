@@ -0,0 +1,360 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Module is a Battlestar source file that has been pulled in with "use"
+type Module struct {
+	Name     string   // the name that was given to "use"
+	Filename string   // where the module was found
+	Code     string   // the fun, const and var definitions of the module that are in use
+	Names    []string // the names that are defined by the module and are in use
+
+	lines       []string
+	definitions []definition
+}
+
+// definition is a fun, const or var definition in a module
+type definition struct {
+	name        string
+	first, last int // the first and last line of the definition
+}
+
+// ModuleLoader finds and loads the modules that are pulled in with "use" or "import".
+// If no Battlestar module is found, pkg-config is asked for a C library by the same name.
+type ModuleLoader struct {
+	config     *TargetConfig
+	searchPath []string
+	modules    []*Module          // in the order they should be compiled
+	libraries  []*CLibrary        // C libraries, in the order they were used
+	loaded     map[string]*Module // modules that are loaded, by filename
+	loading    map[string]bool    // for detecting circular imports
+	definedBy  map[string]string  // which file defines which name
+}
+
+// ModuleSearchPath returns the given include directories, followed by the directories in $BTSPATH
+func ModuleSearchPath(includeDirs []string) []string {
+	searchPath := append([]string{}, includeDirs...)
+	if btspath := os.Getenv("BTSPATH"); btspath != "" {
+		searchPath = append(searchPath, filepath.SplitList(btspath)...)
+	}
+	return searchPath
+}
+
+// NewModuleLoader returns a new ModuleLoader that looks for modules in the given directories.
+// The target config is used for selecting the right implementation of the bundled modules.
+func NewModuleLoader(config *TargetConfig, searchPath []string) *ModuleLoader {
+	return &ModuleLoader{config, searchPath, []*Module{}, []*CLibrary{}, make(map[string]*Module), make(map[string]bool), make(map[string]string)}
+}
+
+// Modules returns the loaded modules that are in use, in a deterministic order
+// where every module comes after the modules it uses.
+func (ml *ModuleLoader) Modules() []*Module {
+	return ml.modules
+}
+
+// Libraries returns the C libraries that have been pulled in, in the order they were used
+func (ml *ModuleLoader) Libraries() []*CLibrary {
+	return ml.libraries
+}
+
+// Defined checks if the given name is defined by the main program or by one of the modules
+func (ml *ModuleLoader) Defined(name string) bool {
+	_, ok := ml.definedBy[name]
+	return ok
+}
+
+// useStatement checks if the given line is a "use" or "import" statement and returns the name of the module
+func useStatement(line string) (string, bool) {
+	words := strings.Fields(strings.TrimSpace(removecomments(line)))
+	if (len(words) != 2) || ((words[0] != "use") && (words[0] != "import")) {
+		return "", false
+	}
+	return words[1], true
+}
+
+// find returns the filename of the given module, relative to the directory
+// of the file that uses it, or found in the search path.
+func (ml *ModuleLoader) find(name, fromDir string) (string, error) {
+	filename := strings.Trim(name, "\"")
+	if !strings.HasSuffix(filename, ".bts") {
+		filename += ".bts"
+	}
+	if filepath.IsAbs(filename) {
+		return filename, nil
+	}
+	for _, dir := range append([]string{fromDir}, ml.searchPath...) {
+		candidate := filepath.Join(dir, filename)
+		if _, err := os.Stat(candidate); err == nil {
+			return filepath.Clean(candidate), nil
+		}
+	}
+	return "", fmt.Errorf("Could not find module %s (searched in %s)", name, strings.Join(append([]string{fromDir}, ml.searchPath...), ", "))
+}
+
+// Load finds the "use" statements in the given code and loads the modules,
+// recursively. The code is returned with the "use" statements blanked out.
+// The filename is used for finding modules relative to the given code.
+// Definitions in the modules that are not used by the program are left out.
+func (ml *ModuleLoader) Load(code, filename string) (string, error) {
+	code, err := ml.loadUses(code, filename)
+	if err != nil {
+		return "", err
+	}
+	_, definitions := moduleDefinitions(code)
+	for _, def := range definitions {
+		if err := ml.claim(def.name, filename); err != nil {
+			return "", err
+		}
+	}
+	if err := ml.checkDuplicates(); err != nil {
+		return "", err
+	}
+	if err := ml.prune(code); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// checkDuplicates returns an error if a name is defined by two modules, also if it is not used.
+// The names that are defined by the main program are skipped, since those definitions are used instead.
+func (ml *ModuleLoader) checkDuplicates() error {
+	definedBy := make(map[string]string)
+	for _, module := range ml.modules {
+		for _, def := range module.definitions {
+			if ml.Defined(def.name) {
+				continue
+			}
+			if otherFilename, ok := definedBy[def.name]; ok && otherFilename != module.Filename {
+				return fmt.Errorf("%s is defined in both %s and %s", def.name, otherFilename, module.Filename)
+			}
+			definedBy[def.name] = module.Filename
+		}
+	}
+	return nil
+}
+
+// loadUses loads all modules that are used by the given code
+func (ml *ModuleLoader) loadUses(code, filename string) (string, error) {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		name, ok := useStatement(line)
+		if !ok {
+			continue
+		}
+		if err := ml.use(name, filepath.Dir(filename)); err != nil {
+			if compileError, ok := err.(*CompileError); ok {
+				if compileError.Filename == "" {
+					compileError.Filename, compileError.Line = filename, i+1
+				}
+				return "", compileError
+			}
+			return "", fmt.Errorf("%s:%d: %s", filename, i+1, err)
+		}
+		// Keep the line numbers intact
+		lines[i] = ""
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// use loads the given module, if it has not already been loaded
+func (ml *ModuleLoader) use(name, fromDir string) error {
+	var data []byte
+	filename, err := ml.find(name, fromDir)
+	if err != nil && validName(name) {
+		code, ok := ml.config.bundledModule(name)
+		if ok && (ml.config.macOS || ml.config.BootableKernel || ml.config.BootSector) {
+			// The std module uses the system calls of Linux and the interrupts of DOS
+			return &CompileError{Message: "The " + name + " module is only available for Linux and DOS"}
+		}
+		if !ok {
+			// Not a Battlestar module, try looking for a C library instead
+			return ml.useLibrary(name, err)
+		}
+		// One of the modules that comes with Battlestar
+		data, filename = []byte(code), "<"+name+">"
+	} else if err != nil {
+		return err
+	}
+	if ml.loading[filename] {
+		return errors.New("Circular use of module " + name)
+	}
+	if _, ok := ml.loaded[filename]; ok {
+		return nil
+	}
+	if data == nil {
+		if data, err = ioutil.ReadFile(filename); err != nil {
+			return err
+		}
+	}
+	ml.loading[filename] = true
+	defer delete(ml.loading, filename)
+	code, err := ml.loadUses(string(data), filename)
+	if err != nil {
+		return err
+	}
+	module := &Module{Name: strings.Trim(name, "\""), Filename: filename}
+	module.lines, module.definitions = moduleDefinitions(code)
+	ml.loaded[filename] = module
+	ml.modules = append(ml.modules, module)
+	return nil
+}
+
+// useLibrary looks up a C library with pkg-config, if it has not already been used.
+// moduleErr is the error from looking for a Battlestar module by the same name.
+func (ml *ModuleLoader) useLibrary(name string, moduleErr error) error {
+	for _, library := range ml.libraries {
+		if library.Name == name {
+			return nil
+		}
+	}
+	library, err := findLibrary(name)
+	if err != nil {
+		return fmt.Errorf("%s, and %s", moduleErr, err)
+	}
+	ml.libraries = append(ml.libraries, library)
+	return nil
+}
+
+// prune leaves out the module definitions that are not used by the given main program,
+// directly or indirectly, so that tiny programs stay tiny. Names that are defined by
+// the main program are not looked up in the modules.
+func (ml *ModuleLoader) prune(code string) error {
+	used := make(map[string]bool)
+	for name := range identifiers(code) {
+		if !ml.Defined(name) {
+			used[name] = true
+		}
+	}
+	kept := make(map[*Module][]definition)
+	for changed := true; changed; {
+		changed = false
+		for _, module := range ml.modules {
+			for _, def := range module.definitions {
+				if !used[def.name] || hasDefinition(kept[module], def.name) {
+					continue
+				}
+				kept[module] = append(kept[module], def)
+				for name := range identifiers(strings.Join(module.lines[def.first:def.last+1], "\n")) {
+					used[name] = true
+				}
+				changed = true
+			}
+		}
+	}
+	var modules []*Module
+	for _, module := range ml.modules {
+		if len(kept[module]) == 0 {
+			continue
+		}
+		code := make([]string, len(module.lines))
+		for _, def := range module.definitions {
+			if !hasDefinition(kept[module], def.name) {
+				continue
+			}
+			if err := ml.claim(def.name, module.Filename); err != nil {
+				return err
+			}
+			copy(code[def.first:def.last+1], module.lines[def.first:def.last+1])
+			module.Names = append(module.Names, def.name)
+		}
+		module.Code = strings.Join(code, "\n")
+		modules = append(modules, module)
+	}
+	ml.modules = modules
+	return nil
+}
+
+// hasDefinition checks if a slice of definitions has a definition with the given name
+func hasDefinition(definitions []definition, name string) bool {
+	for _, def := range definitions {
+		if def.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// identifiers returns all the words in the given code that could be names
+func identifiers(code string) map[string]bool {
+	words := make(map[string]bool)
+	for _, line := range strings.Split(code, "\n") {
+		line = removecomments(line)
+		for i := 0; i < len(line); {
+			if !isWordChar(line[i]) {
+				i++
+				continue
+			}
+			j := i
+			for j < len(line) && isWordChar(line[j]) {
+				j++
+			}
+			words[line[i:j]] = true
+			i = j
+		}
+	}
+	return words
+}
+
+// claim registers that a name is defined in the given file, and
+// returns an error if it has already been defined elsewhere.
+func (ml *ModuleLoader) claim(name, filename string) error {
+	if otherFilename, ok := ml.definedBy[name]; ok && otherFilename != filename {
+		return fmt.Errorf("%s is defined in both %s and %s", name, otherFilename, filename)
+	}
+	ml.definedBy[name] = filename
+	return nil
+}
+
+// moduleDefinitions finds the fun, const, var and struct definitions in the given code.
+// Returns the lines of the code and the definitions.
+func moduleDefinitions(code string) ([]string, []definition) {
+	var (
+		lines       = strings.Split(code, "\n")
+		definitions []definition
+		inFunction  bool
+		afterExit   bool // the previous function ended with "exit", an "end" may follow
+		inline      = inlineLines(lines)
+		depth       int
+	)
+	for i, line := range lines {
+		words := strings.Fields(strings.TrimSpace(removecomments(line)))
+		// Skip inline C, inline assembly and the other inline languages
+		if (len(words) == 0) || inline[i] {
+			continue
+		}
+		if inFunction {
+			definitions[len(definitions)-1].last = i
+			if depth == 0 && len(words) == 1 && words[0] == "end" {
+				inFunction = false
+			} else if depth > 0 && closesBlock(words) {
+				depth--
+			} else if depth == 0 && (words[0] == "ret" || words[0] == "exit") {
+				inFunction = false
+				afterExit = words[0] == "exit"
+			} else if opensBlock(words) {
+				depth++
+			}
+			continue
+		}
+		if afterExit && len(words) == 1 && words[0] == "end" {
+			definitions[len(definitions)-1].last = i
+			afterExit = false
+			continue
+		}
+		afterExit = false
+		if len(words) > 1 && has([]string{"fun", "const", "var", "struct"}, words[0]) {
+			definitions = append(definitions, definition{words[1], i, i})
+			// The fields of a struct are kept together with it, in the same way as the body of a function
+			inFunction = (words[0] == "fun") || ((words[0] == "struct") && opensBlock(words))
+			depth = 0
+		}
+	}
+	return lines, definitions
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModuleLoader(t *testing.T) {
+	dir := filepath.Join("testdata", "modules")
+	tests := []struct {
+		name    string
+		code    string
+		include []string
+		btspath string
+		names   map[string][]string // the names that are kept, by module
+		err     string
+	}{
+		{"next to the program", "use local\nfun main\n    local_hello\nend\n", nil, "",
+			map[string][]string{"local": {"local_hello"}}, ""},
+		{"with -I", "use greet\nfun main\n    greet\nend\n", []string{filepath.Join(dir, "include")}, "",
+			map[string][]string{"greet": {"greeting", "greet", "helper"}}, ""},
+		{"with BTSPATH", "use answer\nfun main\n    exit(answer)\nend\n", nil, filepath.Join(dir, "btspath"),
+			map[string][]string{"answer": {"answer"}}, ""},
+		{"-I before BTSPATH", "use answer\nfun main\n    ask\nend\n", []string{filepath.Join(dir, "include")}, filepath.Join(dir, "btspath"),
+			map[string][]string{"answer": {"ask"}}, ""},
+		{"unused module", "use local\nfun main\n    ret\nend\n", nil, "",
+			map[string][]string{}, ""},
+		{"defined by the program", "use local\nfun local_hello\n    ret\nend\nfun main\n    local_hello\nend\n", nil, "",
+			map[string][]string{}, ""},
+		{"not found", "use nothere.bts\nfun main\n    ret\nend\n", nil, "",
+			nil, "main.bts:1: Could not find module nothere.bts"},
+		{"defined twice", "use \"../dup/first\"\nuse \"../dup/second\"\nfun main\n    twice\nend\n", nil, "",
+			nil, "twice is defined in both " + filepath.Join(dir, "dup", "first.bts") + " and " + filepath.Join(dir, "dup", "second.bts")},
+		{"defined twice, but not used", "use \"../dup/first\"\nuse \"../dup/second\"\nfun main\n    ret\nend\n", nil, "",
+			nil, "twice is defined in both " + filepath.Join(dir, "dup", "first.bts") + " and " + filepath.Join(dir, "dup", "second.bts")},
+		{"defined twice, and by the program", "use \"../dup/first\"\nuse \"../dup/second\"\nfun twice\n    ax = 1\nend\nfun main\n    twice\nend\n", nil, "",
+			map[string][]string{}, ""},
+	}
+	defer os.Setenv("BTSPATH", os.Getenv("BTSPATH"))
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		os.Setenv("BTSPATH", test.btspath)
+		ml := NewModuleLoader(config, ModuleSearchPath(test.include))
+		code, err := ml.Load(test.code, filepath.Join(dir, "app", "main.bts"))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected the error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if strings.Contains(code, "use ") {
+			t.Errorf("%s: expected the use statements to be blanked out:\n%s", test.name, code)
+		}
+		if len(strings.Split(code, "\n")) != len(strings.Split(test.code, "\n")) {
+			t.Errorf("%s: expected the line numbers to be kept", test.name)
+		}
+		names := make(map[string][]string)
+		for _, module := range ml.Modules() {
+			names[module.Name] = module.Names
+		}
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s: expected the modules %v, got %v", test.name, test.names, names)
+		}
+	}
+}
+
+func TestModulePruning(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := NewModuleLoader(config, []string{filepath.Join("testdata", "modules", "include")})
+	if _, err := ml.Load("use greet\nfun main\n    greet\nend\n", "main.bts"); err != nil {
+		t.Fatal(err)
+	}
+	modules := ml.Modules()
+	if len(modules) != 1 {
+		t.Fatalf("expected one module, got %d", len(modules))
+	}
+	code := modules[0].Code
+	for _, expected := range []string{"const greeting", "fun greet", "fun helper"} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("expected %q to be kept:\n%s", expected, code)
+		}
+	}
+	if strings.Contains(code, "never_called") {
+		t.Errorf("expected never_called to be left out:\n%s", code)
+	}
+	// The lines are kept in place, so that errors point to the right line of the module
+	if lines := strings.Split(code, "\n"); len(lines) != len(modules[0].lines) || !strings.HasPrefix(lines[1], "const greeting") {
+		t.Errorf("expected the line numbers of the module to be kept:\n%s", code)
+	}
+}
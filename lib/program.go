@@ -10,16 +10,30 @@ type (
 
 	// ProgramState is the state of the current position in this program, when compiling
 	ProgramState struct {
-		variables              map[string]int // map of variable names and reserved bytes
-		inFunction             string         // name of the function we are currently in
-		inLoop                 string         // name of the loop we are currently in
-		inIfBlock              string         // name of the if block we are currently in
-		definedNames           []string       // all defined variables/constants/functions
-		ifNameCounter          int            // To keep track of which generated label names have already been used
-		loopStep               int            // To keep track of if rep should use stosb or stosw (and stepsize in loops in general)
-		loopNameCounter        int            // To keep track of which generated label names have already been used
-		surpriseEndingWithExit bool           // To keep track of function blocks that are ended with "exit"
-		endless                bool           // ending the program with endless keyword?
+		variables              map[string]int            // map of variable names and reserved bytes
+		inFunction             string                    // name of the function we are currently in
+		inLoop                 string                    // name of the loop we are currently in
+		inIfBlock              string                    // name of the if block we are currently in
+		definedNames           []string                  // all defined variables/constants/functions
+		functions              []string                  // the defined and external functions, that can be called with arguments
+		ifNameCounter          int                       // To keep track of which generated label names have already been used
+		loopStep               int                       // To keep track of if rep should use stosb or stosw (and stepsize in loops in general)
+		loopNameCounter        int                       // To keep track of which generated label names have already been used
+		surpriseEndingWithExit bool                      // To keep track of function blocks that are ended with "exit"
+		endless                bool                      // ending the program with endless keyword?
+		loopTail               string                    // the code that ends each round of the current for loop
+		loopTailShort          string                    // the same, but with the loop instruction, or empty if it can not be used
+		loopSize               int                       // the largest possible size of the current loop body, in bytes
+		structs                map[string]*structType    // the declared structs, by name
+		typedVariables         map[string]*typedVariable // variables that are declared with a type, like [100]Point
+		inStruct               *structType               // the struct that is being declared, if any
+		floatScratchNeeded     bool                      // if memory for moving values between the FPU and registers is used
+		floatScratchReserved   bool                      // if that memory has already been reserved in the .bss section
+		heapNeeded             bool                      // if alloc or free is used
+		heapEmitted            bool                      // if the routines for alloc and free have already been emitted
+		cFunctions             map[string]CFunction      // the C functions in the inline C, by name
+		dataNotValueTypes      []string                  // all defined constants that are data (x: db 1,2,3,4...)
+		carryFollows           bool                      // if the next statement reads the carry flag, which inc and dec do not change
 	}
 )
 
@@ -28,11 +42,14 @@ const (
 	rawloopPrefix = "r_"
 	// For the types of loops that loop forever
 	endlessloopPrefix = "e_"
-)
-
-var (
-	// Global program state
-	dataNotValueTypes []string // all defined constants that are data (x: db 1,2,3,4...)
+	// For loops over a range of numbers, that keeps nothing on the stack
+	forPrefix = "f_"
+	// For loops over the elements of a constant, that keeps the index on the stack
+	forListPrefix = "fl_"
+	// For loops that check a condition at the top
+	whilePrefix = "w_"
+	// For loops that check a condition at the bottom, with "until"
+	doPrefix = "d_"
 )
 
 // NewProgramState returns a new state struct that is used when the program is compiled
@@ -41,6 +58,10 @@ func NewProgramState() *ProgramState {
 	// Initialize global maps and slices
 	ps.definedNames = make([]string, 0)
 	ps.variables = make(map[string]int)
+	ps.structs = make(map[string]*structType)
+	ps.typedVariables = make(map[string]*typedVariable)
+	ps.cFunctions = make(map[string]CFunction)
+	ps.addVectorTypes()
 	return &ps
 }
 
@@ -0,0 +1,404 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// emitter returns assembly code for a statement that matches a rule
+type emitter func(config *TargetConfig, ps *ProgramState, st Statement) string
+
+// alternative is one of the tokens that can be at a given position in a pattern.
+// The value is empty if any value is fine, and any is true for the "*" wildcard.
+type alternative struct {
+	T     TokenType
+	Value string
+	any   bool
+}
+
+// pattern is the shape of a statement, like: REGISTER ASSIGNMENT (VALUE|VALIDNAME)
+type pattern struct {
+	source string          // the pattern, as written in the rule table
+	tokens [][]alternative // the alternatives for each position
+	rest   bool            // if the pattern ends with "...", any number of tokens may follow
+}
+
+// rule pairs statement patterns with the code that outputs assembly for the matching statements
+type rule struct {
+	patterns    []*pattern
+	targets     []int // the platform bits the rule is for, or nil for all of them
+	description string
+	emit        emitter
+	guard       guard // if not nil, the rule only matches when the guard returns true
+}
+
+// guard checks if a rule applies to a statement, beyond the shape of the statement
+type guard func(ps *ProgramState, st Statement) bool
+
+// tokenTypeNames maps the names that can be used in patterns to token types
+var tokenTypeNames = map[string]TokenType{
+	"REGISTER": REGISTER, "ASSIGNMENT": ASSIGNMENT, "VALUE": VALUE, "KEYWORD": KEYWORD, "BUILTIN": BUILTIN,
+	"VALIDNAME": VALIDNAME, "STRING": STRING, "DISREGARD": DISREGARD, "RESERVED": RESERVED, "VARIABLE": VARIABLE,
+	"ADDITION": ADDITION, "SUBTRACTION": SUBTRACTION, "MULTIPLICATION": MULTIPLICATION, "DIVISION": DIVISION,
+	"AND": AND, "OR": OR, "XOR": XOR, "COMPARISON": COMPARISON, "ARROW": ARROW, "MEMEXP": MEMEXP,
+	"ASMLABEL": ASMLABEL, "ROL": ROL, "ROR": ROR, "SEGOFS": SEGOFS, "CONCAT": CONCAT, "SHL": SHL, "SHR": SHR,
+	"QUAL": QUAL, "XCHG": XCHG, "OUT": OUT, "IN": IN, "SIGNEDMUL": SIGNEDMUL, "SIGNEDDIV": SIGNEDDIV, "SAR": SAR,
+	"SIGNEDASSIGN": SIGNEDASSIGN, "MODULO": MODULO, "SIGNEDMOD": SIGNEDMOD, "BINOP": BINOP,
+	"FLAG": FLAG, "ADDCARRY": ADDCARRY, "SUBBORROW": SUBBORROW, "RANGE": RANGE,
+	"ELEMENT": ELEMENT, "FLOATREG": FLOATREG,
+}
+
+// parsePattern parses a pattern like "REGISTER ASSIGNMENT (VALUE|VALIDNAME)" or "KEYWORD:asm VALUE ...".
+// "*" matches any token and "..." at the end matches any number of tokens.
+func parsePattern(source string) (*pattern, error) {
+	p := &pattern{source: source}
+	words := strings.Fields(source)
+	for i, word := range words {
+		if word == "..." {
+			if i != len(words)-1 {
+				return nil, fmt.Errorf("\"...\" must be at the end of the pattern: %s", source)
+			}
+			p.rest = true
+			break
+		}
+		var alternatives []alternative
+		for _, alt := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(word, "("), ")"), "|") {
+			if alt == "*" {
+				alternatives = append(alternatives, alternative{any: true})
+				continue
+			}
+			name, value := alt, ""
+			if pos := strings.Index(alt, ":"); pos != -1 {
+				name, value = alt[:pos], alt[pos+1:]
+			}
+			t, ok := tokenTypeNames[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown token type %s in pattern: %s", name, source)
+			}
+			alternatives = append(alternatives, alternative{T: t, Value: value})
+		}
+		p.tokens = append(p.tokens, alternatives)
+	}
+	return p, nil
+}
+
+// matches checks if a token matches this alternative
+func (a alternative) matches(t Token) bool {
+	return a.any || ((a.T == t.T) && ((a.Value == "") || (a.Value == t.Value)))
+}
+
+// covers checks if every token that matches the other alternative also matches this one
+func (a alternative) covers(other alternative) bool {
+	if a.any {
+		return true
+	}
+	return !other.any && (a.T == other.T) && ((a.Value == "") || (a.Value == other.Value))
+}
+
+// overlaps checks if there is a token that matches both alternatives
+func (a alternative) overlaps(other alternative) bool {
+	return a.any || other.any || ((a.T == other.T) && ((a.Value == "") || (other.Value == "") || (a.Value == other.Value)))
+}
+
+// matches checks if the given statement has the shape of this pattern
+func (p *pattern) matches(st Statement) bool {
+	if (len(st) < len(p.tokens)) || (!p.rest && (len(st) != len(p.tokens))) {
+		return false
+	}
+	for i, alternatives := range p.tokens {
+		found := false
+		for _, alt := range alternatives {
+			if alt.matches(st[i]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// at returns the alternatives for the given position, where a "..." at the end matches anything
+func (p *pattern) at(i int) []alternative {
+	if i < len(p.tokens) {
+		return p.tokens[i]
+	}
+	return []alternative{{any: true}}
+}
+
+// subsetOf checks if every statement that matches this pattern also matches the other pattern
+func (p *pattern) subsetOf(other *pattern) bool {
+	if (p.rest && !other.rest) || (len(p.tokens) < len(other.tokens)) || (!other.rest && (len(p.tokens) != len(other.tokens))) {
+		return false
+	}
+	for i := range other.tokens {
+		for _, alt := range p.at(i) {
+			covered := false
+			for _, otherAlt := range other.at(i) {
+				if otherAlt.covers(alt) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// overlaps checks if there is a statement that matches both patterns
+func (p *pattern) overlaps(other *pattern) bool {
+	if (!p.rest && (len(p.tokens) < len(other.tokens))) || (!other.rest && (len(other.tokens) < len(p.tokens))) {
+		return false
+	}
+	if !p.rest && !other.rest && (len(p.tokens) != len(other.tokens)) {
+		return false
+	}
+	n := len(p.tokens)
+	if len(other.tokens) > n {
+		n = len(other.tokens)
+	}
+	for i := 0; i < n; i++ {
+		found := false
+		for _, alt := range p.at(i) {
+			for _, otherAlt := range other.at(i) {
+				if alt.overlaps(otherAlt) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// distance returns how many tokens would have to change for the statement to match the pattern,
+// and how many of the tokens that already match, not counting wildcards
+func (p *pattern) distance(st Statement) (int, int) {
+	dist, matched := 0, 0
+	for i, alternatives := range p.tokens {
+		if i >= len(st) {
+			dist++
+			continue
+		}
+		found, wildcard := false, false
+		for _, alt := range alternatives {
+			if alt.matches(st[i]) {
+				found, wildcard = true, alt.any
+				break
+			}
+		}
+		if !found && (i == 0) {
+			// The first token tells what kind of statement it is, so it counts double
+			dist += 2
+		} else if !found {
+			dist++
+		} else if !wildcard {
+			matched++
+		}
+	}
+	if !p.rest && (len(st) > len(p.tokens)) {
+		dist += len(st) - len(p.tokens)
+	}
+	return dist, matched
+}
+
+// forTarget checks if the rule applies to the given platform
+func (r *rule) forTarget(platformBits int) bool {
+	return (r.targets == nil) || hasi(r.targets, platformBits)
+}
+
+// sharesTarget checks if two rules apply to at least one common platform
+func (r *rule) sharesTarget(other *rule) bool {
+	for _, bits := range []int{16, 32, 64} {
+		if r.forTarget(bits) && other.forTarget(bits) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRule creates a rule from the given patterns. Panics if a pattern is invalid,
+// since the rules are a part of the compiler.
+func newRule(patterns []string, targets []int, description string, emit emitter) *rule {
+	r := &rule{targets: targets, description: description, emit: emit}
+	for _, source := range patterns {
+		p, err := parsePattern(source)
+		if err != nil {
+			panic(err)
+		}
+		r.patterns = append(r.patterns, p)
+	}
+	return r
+}
+
+// when makes the rule only match the statements that the given guard returns true for.
+// A guarded rule may come before the rules that it overlaps with.
+func (r *rule) when(g guard) *rule {
+	r.guard = g
+	return r
+}
+
+// applies checks if the guard of the rule, if any, allows the given statement
+func (r *rule) applies(ps *ProgramState, st Statement) bool {
+	return (r.guard == nil) || r.guard(ps, st)
+}
+
+// checkRules returns an error if a pattern can never match, because an earlier pattern matches
+// everything it matches, or if two patterns match some of the same statements without one of them
+// being more specific than the other. A specific or guarded pattern may come before a more general one.
+func checkRules(rules []*rule) error {
+	type entry struct {
+		p *pattern
+		r *rule
+	}
+	var entries []entry
+	for _, r := range rules {
+		for _, p := range r.patterns {
+			entries = append(entries, entry{p, r})
+		}
+	}
+	for j, later := range entries {
+		for _, earlier := range entries[:j] {
+			if (earlier.r.guard != nil) || !earlier.r.sharesTarget(later.r) || !earlier.p.overlaps(later.p) {
+				continue
+			}
+			if later.p.subsetOf(earlier.p) {
+				return fmt.Errorf("the statement pattern %q can never match, since %q comes first", later.p.source, earlier.p.source)
+			}
+			if !earlier.p.subsetOf(later.p) {
+				return fmt.Errorf("the statement patterns %q and %q overlap", earlier.p.source, later.p.source)
+			}
+		}
+	}
+	return nil
+}
+
+// findRule returns the first rule with a pattern that matches the given statement, for the current platform
+func (config *TargetConfig) findRule(ps *ProgramState, st Statement) (*rule, bool) {
+	for _, r := range statementRules {
+		if !r.forTarget(config.PlatformBits) || !r.applies(ps, st) {
+			continue
+		}
+		for _, p := range r.patterns {
+			if p.matches(st) {
+				return r, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// shape returns the token types of a statement, in the same format as the patterns
+func (st Statement) shape() string {
+	names := make(map[TokenType]string, len(tokenTypeNames))
+	for name, t := range tokenTypeNames {
+		names[t] = name
+	}
+	var words []string
+	for _, t := range st {
+		switch t.T {
+		case KEYWORD, BUILTIN:
+			words = append(words, names[t.T]+":"+t.Value)
+		default:
+			words = append(words, names[t.T])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// didYouMean returns the patterns that are the closest to the given statement, for the current platform,
+// followed by the patterns for other platforms that are at least as close, labeled with their platform bits
+func (config *TargetConfig) didYouMean(ps *ProgramState, st Statement) []string {
+	type candidate struct {
+		p       *pattern
+		r       *rule
+		dist    int
+		matched int
+	}
+	var candidates, others []candidate
+	for _, r := range statementRules {
+		if !r.applies(ps, st) {
+			continue
+		}
+		for _, p := range r.patterns {
+			dist, matched := p.distance(st)
+			if r.forTarget(config.PlatformBits) {
+				candidates = append(candidates, candidate{p, r, dist, matched})
+			} else {
+				others = append(others, candidate{p, r, dist, matched})
+			}
+		}
+	}
+	closest := func(candidates []candidate) {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].dist != candidates[j].dist {
+				return candidates[i].dist < candidates[j].dist
+			}
+			return candidates[i].matched > candidates[j].matched
+		})
+	}
+	closest(candidates)
+	closest(others)
+	var nearest []string
+	for i := 0; (i < len(candidates)) && (i < 3); i++ {
+		nearest = append(nearest, candidates[i].p.source+"\t("+candidates[i].r.description+")")
+	}
+	for i := 0; (i < len(others)) && (i < 3); i++ {
+		if (len(candidates) > 0) && (others[i].dist > candidates[0].dist) {
+			break
+		}
+		var platforms []string
+		for _, bits := range others[i].r.targets {
+			platforms = append(platforms, strconv.Itoa(bits)+"-bit")
+		}
+		nearest = append(nearest, others[i].p.source+"\t("+others[i].r.description+", "+strings.Join(platforms, " and ")+" only)")
+	}
+	return nearest
+}
+
+// unfamiliar stops with an error for a statement that matches no rule, listing the closest patterns
+func (config *TargetConfig) unfamiliar(ps *ProgramState, st Statement) {
+	var msg string
+	switch {
+	case (st[0].T == KEYWORD) && (st[0].Value == "const"):
+		msg = "Incomprehensible constant: " + tokensString(st)
+	case st[0].T == BUILTIN:
+		msg = "Unhandled builtin: " + st[0].Value
+	case st[0].T == KEYWORD:
+		msg = "Unhandled keyword: " + st[0].Value
+	default:
+		msg = "Unfamiliar statement layout: " + tokensString(st)
+	}
+	msg += "\nThe statement has this shape: " + st.shape()
+	msg += "\nDid you mean one of these?"
+	for _, nearest := range config.didYouMean(ps, st) {
+		msg += "\n\t" + nearest
+	}
+	fatal(msg)
+}
+
+// Rules returns a description of every statement form that is supported for the given platform
+func Rules(platformBits int) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	for _, r := range statementRules {
+		if !r.forTarget(platformBits) {
+			continue
+		}
+		for _, p := range r.patterns {
+			fmt.Fprintf(w, "%s\t%s\n", p.source, r.description)
+		}
+	}
+	w.Flush()
+	return sb.String()
+}
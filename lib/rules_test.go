@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckRules(t *testing.T) {
+	emit := func(config *TargetConfig, ps *ProgramState, st Statement) string { return "" }
+	specific := newRule([]string{"REGISTER ASSIGNMENT VALUE"}, nil, "specific", emit)
+	general := newRule([]string{"REGISTER ASSIGNMENT *"}, nil, "general", emit)
+	overlapping := newRule([]string{"(REGISTER|VALUE) ASSIGNMENT VALUE"}, nil, "overlapping", emit)
+	only16 := newRule([]string{"REGISTER ASSIGNMENT VALUE"}, []int{16}, "16-bit", emit)
+	only64 := newRule([]string{"REGISTER ASSIGNMENT VALUE"}, []int{64}, "64-bit", emit)
+	if err := checkRules([]*rule{specific, general}); err != nil {
+		t.Errorf("A specific rule should be allowed before a general one: %s\n", err)
+	}
+	if err := checkRules([]*rule{general, specific}); err == nil {
+		t.Errorf("A specific rule after a general one should be unreachable\n")
+	}
+	if err := checkRules([]*rule{overlapping, general}); err == nil {
+		t.Errorf("Overlapping rules should be an error\n")
+	}
+	if err := checkRules([]*rule{only16, only64}); err != nil {
+		t.Errorf("Rules for different platforms should not overlap: %s\n", err)
+	}
+	guarded := newRule([]string{"REGISTER ASSIGNMENT VALUE"}, nil, "guarded", emit).when(func(ps *ProgramState, st Statement) bool { return false })
+	if err := checkRules([]*rule{guarded, specific}); err != nil {
+		t.Errorf("A guarded rule should be allowed before the rules it overlaps with: %s\n", err)
+	}
+	if err := checkRules(statementRules); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFindRule(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := Statement{Token{REGISTER, "rax", 0, ""}, Token{ASSIGNMENT, "=", 0, ""}, Token{VALUE, "42", 0, ""}}
+	if r, ok := config.findRule(NewProgramState(), st); !ok || r.description != "assign a value to a register" {
+		t.Errorf("Could not find the rule for: rax = 42\n")
+	}
+	st = Statement{Token{REGISTER, "rax", 0, ""}, Token{MEMEXP, "[<-]", 0, ""}, Token{VALUE, "3", 0, ""}}
+	if _, ok := config.findRule(NewProgramState(), st); ok {
+		t.Errorf("No rule should match: rax [<-] 3\n")
+	}
+	if nearest := config.didYouMean(NewProgramState(), st); len(nearest) == 0 {
+		t.Errorf("There should be suggestions for: rax [<-] 3\n")
+	}
+}
+
+func TestDidYouMeanOtherTargets(t *testing.T) {
+	st := Statement{Token{KEYWORD, "write", 0, ""}}
+	for _, bits := range []int{16, 64} {
+		config, err := NewTargetConfig(bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nearest := strings.Join(config.didYouMean(NewProgramState(), st), "\n")
+		if (bits == 64) && !strings.Contains(nearest, "KEYWORD:write\t(write the value, 16-bit only)") {
+			t.Errorf("write should be suggested for 16-bit, when compiling for 64-bit:\n%s\n", nearest)
+		}
+		if (bits == 16) && strings.Contains(nearest, " only)") {
+			t.Errorf("No other platforms should be suggested when compiling write for 16-bit:\n%s\n", nearest)
+		}
+	}
+}
@@ -0,0 +1,1359 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// statementRules are the statement patterns that are recognized, in the order they are tried.
+// The rules are checked for overlapping and unreachable patterns when the program starts.
+var statementRules []*rule
+
+func init() {
+	var (
+		all     []int // nil means all platforms
+		only16  = []int{16}
+		operand = "(REGISTER|VALUE|MEMEXP)"
+		float   = "(FLOATREG|VALIDNAME|ELEMENT)"
+		fop     = "(ADDITION|SUBTRACTION|MULTIPLICATION|DIVISION)"
+		ffun    = "(BUILTIN:sqrt|BUILTIN:sin|BUILTIN:cos)"
+		vec     = "(VALIDNAME|ELEMENT)"
+		vfun    = "(VALIDNAME:dot|VALIDNAME:cross|VALIDNAME:rotate)"
+	)
+	statementRules = []*rule{
+		newRule([]string{"BUILTIN:int ..."}, all, "call an interrupt", emitInterrupt),
+		newRule([]string{"BUILTIN:syscall ..."}, all, "make a system call", emitSyscall),
+		newRule([]string{"KEYWORD:var VALIDNAME VALIDNAME", "KEYWORD:var VALIDNAME VALUE VALIDNAME"}, all, "reserve memory for a struct or an array", emitTypedVariable),
+		newRule([]string{"KEYWORD:var * * ..."}, all, "reserve memory for a variable", emitVariable),
+		newRule([]string{"KEYWORD:const * * * ..."}, all, "declare constant data", emitConstant),
+		newRule([]string{"FLOATREG ASSIGNMENT " + float, "VALIDNAME ASSIGNMENT (FLOATREG|ELEMENT)", "ELEMENT ASSIGNMENT " + float}, all, "assign an f64 value", emitFloatAssign),
+		newRule([]string{"FLOATREG " + fop + " " + float, "VALIDNAME " + fop + " (FLOATREG|ELEMENT)", "VALIDNAME (SUBTRACTION|MULTIPLICATION|DIVISION) VALIDNAME", "ELEMENT " + fop + " " + float}, all, "calculate with f64 values", emitFloatArithmetic),
+		newRule([]string{"FLOATREG ASSIGNMENT " + ffun + " " + float, "VALIDNAME ASSIGNMENT " + ffun + " " + float, "ELEMENT ASSIGNMENT " + ffun + " " + float}, all, "calculate sqrt, sin or cos of an f64 value", emitFloatFunction),
+		newRule([]string{"FLOATREG ASSIGNMENT REGISTER", "VALIDNAME ASSIGNMENT REGISTER"}, all, "convert a register to an f64 value", emitIntegerToFloat),
+		newRule([]string{"FLOATREG ASSIGNMENT VALIDNAME:dot " + vec + " " + vec, "VALIDNAME ASSIGNMENT " + vfun + " " + vec + " " + vec, "ELEMENT ASSIGNMENT " + vfun + " " + vec + " " + vec, "VALIDNAME ASSIGNMENT VALIDNAME:normalize " + vec, "ELEMENT ASSIGNMENT VALIDNAME:normalize " + vec}, all, "calculate dot, cross, normalize or rotate with vectors", emitVectorFunction),
+		newRule([]string{"REGISTER ASSIGNMENT BUILTIN:alloc (REGISTER|VALUE)"}, all, "allocate memory", emitAlloc),
+		newRule([]string{"BUILTIN:free REGISTER"}, all, "free memory from alloc", emitFree),
+		newRule([]string{"REGISTER ASSIGNMENT FLOATREG", "REGISTER ASSIGNMENT BUILTIN:round " + float}, all, "round an f64 value to an integer", emitFloatToInteger),
+		newRule([]string{"VALIDNAME ASSIGNMENT * ..."}, all, "copy data from a constant to a variable", emitCopyData),
+		newRule([]string{"VALIDNAME ADDITION VALIDNAME ..."}, all, "append data from a constant to a variable", emitAppendData),
+		newRule([]string{"BUILTIN:halt ..."}, all, "stop the CPU", emitHalt),
+		newRule([]string{"BUILTIN:print VALIDNAME ..."}, only16, "output a string", emitPrint),
+		newRule([]string{"KEYWORD:ret ...", "BUILTIN:exit ..."}, all, "return from a function or exit the program", emitReturn),
+		newRule([]string{"KEYWORD:mem (VALUE|VALIDNAME|REGISTER) ASSIGNMENT (VALUE|VALIDNAME|REGISTER)"}, all, "write to memory", emitMemoryAssignment),
+		newRule([]string{"KEYWORD:membyte (VALUE|VALIDNAME|REGISTER) ASSIGNMENT (VALUE|VALIDNAME|REGISTER)"}, all, "write a byte to memory", emitMemoryByteAssignment),
+		newRule([]string{"KEYWORD:memword (VALUE|VALIDNAME|REGISTER) ASSIGNMENT (VALUE|VALIDNAME|REGISTER)"}, all, "write a word to memory", emitMemoryWordAssignment),
+		newRule([]string{"KEYWORD:memdouble (VALUE|VALIDNAME|REGISTER) ASSIGNMENT (VALUE|VALIDNAME|REGISTER)"}, all, "write a double word to memory", emitMemoryDoubleAssignment),
+		newRule([]string{"REGISTER ASSIGNMENT KEYWORD:mem (VALUE|VALIDNAME|REGISTER)"}, all, "read from memory", emitMemoryRead),
+		newRule([]string{"REGISTER ASSIGNMENT KEYWORD:readbyte (VALUE|VALIDNAME|REGISTER)"}, all, "read a byte from memory", emitMemoryByteRead),
+		newRule([]string{"REGISTER ASSIGNMENT KEYWORD:readword (VALUE|VALIDNAME|REGISTER)"}, all, "read a word from memory", emitMemoryWordRead),
+		newRule([]string{"REGISTER ASSIGNMENT KEYWORD:readdouble (VALUE|VALIDNAME|REGISTER)"}, all, "read a double word from memory", emitMemoryDoubleRead),
+		newRule([]string{"REGISTER COMPARISON *"}, all, "start an if block", emitIfBlock),
+		newRule([]string{"KEYWORD:if * COMPARISON *"}, all, "start an if block", emitIf),
+		newRule([]string{"KEYWORD:if * COMPARISON * (KEYWORD:and|KEYWORD:or) ..."}, all, "start an if block that is run if all or any of the comparisons are true", emitIfCondition),
+		newRule([]string{"KEYWORD:if FLAG", "KEYWORD:if KEYWORD:not FLAG"}, all, "start an if block that is run if the flag condition is true", emitIfFlag),
+		newRule([]string{"KEYWORD:struct VALIDNAME ..."}, all, "declare a struct", emitStruct),
+		newRule([]string{"VALIDNAME VALIDNAME"}, all, "call a function with a name as the argument", emitArgumentCall).when(callable),
+		newRule([]string{"VALIDNAME VALIDNAME"}, all, "declare a field in a struct", emitField),
+		newRule([]string{"ELEMENT ASSIGNMENT (REGISTER|VALUE)"}, all, "assign to an element or a field", emitStoreElement),
+		newRule([]string{"ELEMENT (ADDITION|SUBTRACTION|AND|OR|XOR) (REGISTER|VALUE)"}, all, "change an element or a field", emitElementArithmetic),
+		newRule([]string{"REGISTER ASSIGNMENT ELEMENT"}, all, "read an element or a field", emitLoadElement),
+		newRule([]string{"REGISTER ASSIGNMENT (VALUE|VALIDNAME)"}, all, "assign a value to a register", emitAssignValue),
+		newRule([]string{"REGISTER ASSIGNMENT REGISTER"}, all, "assign a register to a register", emitAssignRegister),
+		newRule([]string{"REGISTER SIGNEDASSIGN (REGISTER|VALUE)"}, all, "assign a register to a register, with sign extension", emitSignedAssignment),
+		newRule([]string{"DISREGARD * *"}, all, "disregard a value", emitDisregard),
+		newRule([]string{"(REGISTER|VALUE|VALIDNAME:stack) ARROW (REGISTER|VALIDNAME:stack)"}, all, "push to or pop from the stack", emitStack),
+		newRule([]string{"REGISTER ADDITION " + operand}, all, "add", emitAddition),
+		newRule([]string{"REGISTER SUBTRACTION " + operand}, all, "subtract", emitSubtraction),
+		newRule([]string{"REGISTER ADDCARRY " + operand}, all, "add, with carry", instruction("adc", "%s += %s + carry")),
+		newRule([]string{"REGISTER SUBBORROW " + operand}, all, "subtract, with borrow", instruction("sbb", "%s -= %s + carry")),
+		newRule([]string{"REGISTER MULTIPLICATION " + operand}, all, "multiply", emitMultiplication),
+		newRule([]string{"REGISTER SIGNEDMUL " + operand}, all, "multiply, signed", emitSignedMultiplication),
+		newRule([]string{"REGISTER DIVISION (REGISTER|VALUE|MEMEXP|VALIDNAME)"}, all, "divide", emitDivision),
+		newRule([]string{"REGISTER MODULO (REGISTER|VALUE|MEMEXP|VALIDNAME)"}, all, "remainder", emitModulo),
+		newRule([]string{"REGISTER SIGNEDDIV (REGISTER|VALUE|MEMEXP|VALIDNAME)"}, all, "divide, signed", emitSignedDivision),
+		newRule([]string{"REGISTER SIGNEDMOD (REGISTER|VALUE|MEMEXP|VALIDNAME)"}, all, "remainder, signed", emitSignedModulo),
+		newRule([]string{"REGISTER AND " + operand}, all, "bitwise and", instruction("and", "%s &= %s")),
+		newRule([]string{"REGISTER OR " + operand}, all, "bitwise or", instruction("or", "%s |= %s")),
+		newRule([]string{"REGISTER XOR " + operand}, all, "bitwise xor", instruction("xor", "%s ^= %s")),
+		newRule([]string{"REGISTER ROL " + operand}, all, "rotate left", instruction("rol", "rotate %s left %s")),
+		newRule([]string{"REGISTER ROR " + operand}, all, "rotate right", instruction("ror", "rotate %s right %s")),
+		newRule([]string{"REGISTER SHL " + operand}, all, "shift left", instruction("shl", "shift %s left %s")),
+		newRule([]string{"REGISTER SHR " + operand}, all, "shift right", instruction("shr", "shift %s right %s")),
+		newRule([]string{"REGISTER SAR " + operand}, all, "shift right, keeping the sign", instruction("sar", "shift %s right %s, keeping the sign")),
+		newRule([]string{"REGISTER XCHG " + operand}, all, "exchange", instruction("xchg", "exchange %s and %s")),
+		newRule([]string{"REGISTER OUT " + operand}, all, "output to an IO port", instruction("out", "output %s to IO port %s")),
+		newRule([]string{"REGISTER IN (REGISTER|MEMEXP)"}, all, "input from an IO port", emitIn),
+		newRule([]string{"RESERVED VALUE ASSIGNMENT (VALIDNAME|VALUE|REGISTER)"}, all, "assign to a list element", emitListAssignment),
+		newRule([]string{"REGISTER ASSIGNMENT RESERVED VALUE"}, all, "read a list element", emitListRead),
+		newRule([]string{"REGISTER ASSIGNMENT (REGISTER|VALUE|MEMEXP) BINOP (REGISTER|VALUE|MEMEXP|VALIDNAME)"}, all, "divide and assign the quotient or remainder", emitBinaryOperation),
+		newRule([]string{"RESERVED VALUE ASSIGNMENT RESERVED VALUE"}, all, "copy a list element", emitListCopy),
+		newRule([]string{"KEYWORD:asm VALUE ..."}, all, "inline assembly for the given platform", emitInlineAssembly),
+		newRule([]string{"KEYWORD:fun VALIDNAME ..."}, all, "start a function", emitFunction),
+		newRule([]string{"KEYWORD:call *"}, all, "call a function", emitCall),
+		newRule([]string{"KEYWORD:counter *"}, all, "set the loop counter", emitCounter),
+		newRule([]string{"KEYWORD:value *"}, all, "set the value for write and loopwrite", emitValue),
+		newRule([]string{"KEYWORD:loopwrite"}, all, "write the value, counter times", emitLoopwrite),
+		newRule([]string{"KEYWORD:write"}, only16, "write the value", emitWrite),
+		newRule([]string{"(KEYWORD:rawloop|KEYWORD:loop)", "(KEYWORD:rawloop|KEYWORD:loop) *"}, all, "start a loop", emitLoop),
+		newRule([]string{"KEYWORD:for REGISTER KEYWORD:in VALUE RANGE VALUE", "KEYWORD:for REGISTER KEYWORD:in VALUE RANGE VALUE KEYWORD:step VALUE"}, all, "loop over a range of numbers", emitForRange),
+		newRule([]string{"KEYWORD:for REGISTER KEYWORD:in VALIDNAME"}, all, "loop over the elements of a constant", emitForList),
+		newRule([]string{"KEYWORD:while * COMPARISON * ..."}, all, "start a loop that runs while the condition is true", emitWhile),
+		newRule([]string{"KEYWORD:do"}, all, "start a loop that ends with until", emitDo),
+		newRule([]string{"KEYWORD:until * COMPARISON * ..."}, all, "end a do loop, that runs again unless the condition is true", emitUntil),
+		newRule([]string{"KEYWORD:address *"}, all, "set the address for write and loopwrite", emitAddress),
+		newRule([]string{"KEYWORD:bootable"}, all, "make a bootable kernel", emitBootable),
+		newRule([]string{"KEYWORD:extern *"}, all, "declare an external symbol", emitExtern),
+		newRule([]string{"KEYWORD:break * COMPARISON *"}, all, "break out of a loop if the comparison is true", emitBreakIf),
+		newRule([]string{"KEYWORD:break FLAG", "KEYWORD:break KEYWORD:not FLAG"}, all, "break out of a loop if the flag condition is true", emitBreakIfFlag),
+		newRule([]string{"KEYWORD:break"}, all, "break out of a loop", emitBreak),
+		newRule([]string{"KEYWORD:continue * COMPARISON *"}, all, "continue from the top of a loop if the comparison is true", emitContinueIf),
+		newRule([]string{"KEYWORD:continue FLAG", "KEYWORD:continue KEYWORD:not FLAG"}, all, "continue from the top of a loop if the flag condition is true", emitContinueIfFlag),
+		newRule([]string{"KEYWORD:continue"}, all, "continue from the top of a loop", emitContinue),
+		newRule([]string{"KEYWORD:endless"}, all, "mark the program as never returning", emitEndless),
+		newRule([]string{"KEYWORD:end"}, all, "end an if block, a loop or a function", emitEnd),
+		newRule([]string{"VALIDNAME"}, all, "call a function", emitNameCall),
+		newRule([]string{"VALIDNAME (REGISTER|VALUE|VALIDNAME) ..."}, all, "call a function with arguments", emitArgumentCall).when(callable),
+		newRule([]string{"KEYWORD:noret ..."}, all, "end a function without returning", emitNoret),
+		newRule([]string{"KEYWORD:inline_c ..."}, all, "start a block of inline C", emitInlineC),
+		newRule([]string{"KEYWORD:inline_asm", "KEYWORD:inline_asm VALUE"}, all, "a block of assembly for all platforms, or for the given platform", emitInlineAsmBlock),
+	}
+	if err := checkRules(statementRules); err != nil {
+		panic(err)
+	}
+}
+
+// emitInterrupt calls an interrupt
+func emitInterrupt(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return config.syscallOrInterrupt(ps, st, false)
+}
+
+// emitSyscall calls a system call
+func emitSyscall(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return config.syscallOrInterrupt(ps, st, true)
+}
+
+// emitVariable reserves memory in the .bss section
+func emitVariable(config *TargetConfig, ps *ProgramState, st Statement) string {
+	varname := ""
+	if st[1].T == VALIDNAME {
+		varname = st[1].Value
+	} else {
+		fatal("Error: "+st[1].Value, "is not a valid name for a variable")
+	}
+	bsscode := ""
+	if (st[1].T == VALIDNAME) && ((st[2].T == VALUE) || (strings.HasPrefix(st[2].Value, "_length_of_"))) {
+		if has(ps.definedNames, varname) {
+			fatal("Error: Can not declare variable, name is already defined: " + varname)
+		}
+		ps.definedNames = append(ps.definedNames, varname)
+		// Store the name of the declared variable in variables + the length
+		if !strings.HasPrefix(st[2].Value, "_length_of_") {
+			var err error
+			ps.variables[varname], err = strconv.Atoi(st[2].Value)
+			if err != nil {
+				fatal("Error: " + st[2].Value + " is not a valid number of bytes to reserve")
+			}
+		}
+		// Will be placed in the .bss section at the end
+		bsscode += varname + ": resb " + st[2].Value + "\t\t\t\t; reserve " + st[2].Value + " bytes as " + varname + "\n"
+		bsscode += "_capacity_of_" + varname + " equ " + st[2].Value + "\t\t; size of reserved memory\n"
+		bsscode += "_length_of_" + varname + ": "
+		switch config.PlatformBits {
+		case 64:
+			bsscode += "resd 1"
+		case 32:
+			bsscode += "resw 1"
+		case 16:
+			bsscode += "resb 1"
+		}
+		bsscode += "\t\t; current length of contents (points to after the data)\n"
+		return bsscode
+	}
+	fatalf("Error: Variable statements are on the form: \"var x 1024\" for reserving 1024 bytes, not: %s %s %s\nInvalid parameters for variable string statement: %s", st[0].Value, st[1].Value, st[2].Value, tokensString(st))
+	return ""
+}
+
+// emitConstant declares constant data
+func emitConstant(config *TargetConfig, ps *ProgramState, st Statement) string {
+	constname := ""
+	if st[1].T == VALIDNAME {
+		constname = st[1].Value
+	} else {
+		fatal("Error: "+st[1].Value, " (or a,b,c,d) is not a valid name for a constant")
+	}
+	asmcode := ""
+	if (st[1].T == VALIDNAME) && (st[2].T == ASSIGNMENT) && ((st[3].T == STRING) || (st[3].T == VALUE) || (st[3].T == VALIDNAME)) {
+		if has(ps.definedNames, constname) {
+			fatal("Error: Can not declare constant, name is already defined: " + constname)
+		}
+		if (st[3].T == VALIDNAME) && !has(ps.definedNames, st[3].Value) {
+			fatal("Error: Can't assign", st[3].Value, "to", st[1].Value, "because", st[3].Value, "is undefined.")
+		}
+		// Store the name of the declared constant in defined_names
+		ps.definedNames = append(ps.definedNames, constname)
+		// For the .DATA section (recognized by the keyword)
+		if floats := floatConstant(st[3:]); floats > 0 {
+			// f64 values, which can be used like f64 variables
+			asmcode += constname + ":\tdq "
+			ps.typedVariables[constname] = &typedVariable{"f64", floats}
+			if floats == 1 {
+				ps.typedVariables[constname].count = 0
+			}
+		} else if st[3].T == VALUE {
+			switch config.PlatformBits {
+			case 64:
+				asmcode += constname + ":\tdq "
+			case 32:
+				asmcode += constname + ":\tdw "
+			case 16:
+				asmcode += constname + ":\tdb "
+			}
+		} else {
+			asmcode += constname + ":\tdb "
+			ps.dataNotValueTypes = append(ps.dataNotValueTypes, constname)
+		}
+		for i := 3; i < len(st); i++ {
+			asmcode += st[i].Value
+			// Add a comma between every element but the last one
+			if (i + 1) != len(st) {
+				asmcode += ", "
+			}
+		}
+		if st[3].T == STRING {
+			asmcode += "\t\t; constant string\n"
+			//if config.platformBits == 16 {
+			// Add an extra $, for safety, if on a 16-bit platform. Needed for print().
+			// TODO: Remove, use a different int 21h call instead!
+			//asmcode += "\tdb \"$\"\t\t\t; end of string, for when using ah=09/int 21h\n"
+			//}
+		} else {
+			asmcode += "\t\t; constant value\n"
+		}
+		// Special naming for storing the length for later
+		asmcode += "_length_of_" + constname + " equ $ - " + constname + "\t; size of constant value\n"
+		return asmcode
+	}
+	fatal("Error: Invalid parameters for constant string statement: " + tokensString(st))
+	return ""
+}
+
+// emitCopyData copies data from a constant to a variable
+func emitCopyData(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (len(st) == 3) && (ps.isFloatName(st[0]) || (ps.vectorType(st[0]) != "")) {
+		return emitFloatAssign(config, ps, st)
+	}
+	// Copying data from constants to variables (reserved memory in the .bss section)
+	asmcode := ""
+	from := st[2].Value
+	to := st[0].Value
+	lengthexpr := "_length_of_" + from
+	toPosition := "[_length_of_" + to + "]"
+	// TODO: Make this a lot smarter and handle copying ranges of data, adr or value
+	// TODO: Actually, redesign the whole language
+	switch config.PlatformBits {
+	case 64:
+		asmcode += "\tmov rdi, " + to + "\t\t\t; copy bytes from " + from + " to " + to + "\n"
+		asmcode += "\tmov rsi, " + from + "\n"
+		asmcode += "\tmov rcx, " + lengthexpr + "\n"
+		//asmcode += "\tmov QWORD " + toPosition + ", " + to + "\n"
+		asmcode += "\tmov " + toPosition + ", rcx" + "\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n" // optimized ok on 64-bit CPUs
+	case 32:
+		asmcode += "\tmov edi, " + to + "\t\t\t; copy bytes from " + from + " to " + to + "\n"
+		asmcode += "\tmov esi, " + from + "\n"
+		asmcode += "\tmov ecx, " + lengthexpr + "\n"
+		asmcode += "\tmov " + toPosition + ", ecx\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n" // optimized ok on 32-bit CPUs
+	case 16:
+		// TODO: Test this
+		asmcode += "\tmov di, " + to + "\t\t\t; copy bytes from " + from + " to " + to + "\n"
+		asmcode += "\tmov si, " + from + "\n"
+		asmcode += "\tmov cx, " + lengthexpr + "\n"
+		asmcode += "\tmov " + toPosition + ", cx\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	}
+	return asmcode
+}
+
+// emitAppendData appends data from a constant to a variable
+func emitAppendData(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (len(st) == 3) && (ps.isFloatName(st[0]) || (ps.vectorType(st[0]) != "")) {
+		return emitFloatArithmetic(config, ps, st)
+	}
+	// Copying data from constants to variables (reserved memory in the .bss section)
+	asmcode := ""
+	from := st[2].Value
+	to := st[0].Value
+	lengthAddr := "[_length_of_" + to + "]"
+	// TODO: Make this a lot smarter and handle copying ranges of data, adr or value
+	// TODO: Actually, redesign the whole language
+	switch config.PlatformBits {
+	case 64:
+		asmcode += "\tmov rdi, " + to + "\t\t; add bytes from \"" + from + "\" to " + to + "\n"
+		asmcode += "\tadd rdi, " + lengthAddr + "\n"
+		asmcode += "\tmov rsi, " + from + "\n"
+		asmcode += "\tmov rcx, _length_of_" + from + "\n"
+		asmcode += "\tadd " + lengthAddr + ", rcx" + "\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	case 32:
+		asmcode += "\tmov edi, " + to + "\t\t; add bytes from \"" + from + "\" to " + to + "\n"
+		asmcode += "\tadd edi, " + lengthAddr + "\n"
+		asmcode += "\tmov esi, " + from + "\n"
+		asmcode += "\tmov ecx, _length_of_" + from + "\n"
+		asmcode += "\tadd " + lengthAddr + ", ecx" + "\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	case 16:
+		// TODO: Test this
+		asmcode += "\tmov di, " + to + "\t\t; add bytes from \"" + from + "\" to " + to + "\n"
+		asmcode += "\tadd di, " + lengthAddr + "\n"
+		asmcode += "\tmov si, " + from + "\n"
+		asmcode += "\tmov cx, _length_of_" + from + "\n"
+		asmcode += "\tadd " + lengthAddr + ", cx" + "\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	}
+	return asmcode
+}
+
+// emitHalt stops the CPU
+func emitHalt(config *TargetConfig, ps *ProgramState, st Statement) string {
+	asmcode := "\t; --- full stop ---\n"
+	asmcode += "\tcli\t\t; clear interrupts\n"
+	asmcode += ".hang:\n"
+	asmcode += "\thlt\n"
+	asmcode += "\tjmp .hang\t; loop forever\n\n"
+	return asmcode
+}
+
+// emitPrint outputs a string with DOS interrupt 21h
+func emitPrint(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if config.BootSector {
+		fatal("Error: print uses DOS, which is not available in a boot sector. Call int(10) with ah = 0x0e for each character instead.")
+	}
+	asmcode := "\t; --- output string of given length ---\n"
+	asmcode += "\tmov dx, " + st[1].Value + "\n"
+	if _, ok := ps.variables[st[1].Value]; ok {
+		// A variable in .bss
+		asmcode += "\tmov cx, [_length_of_" + st[1].Value + "]\n"
+	} else {
+		asmcode += "\tmov cx, _length_of_" + st[1].Value + "\n"
+	}
+	asmcode += "\tmov bx, 1\n"
+	asmcode += "\tmov ah, 0x40\t\t; prepare to call \"Write File or Device\"\n"
+	asmcode += "\tint 0x21\n\n"
+	return asmcode
+}
+
+// emitReturn returns from a function or exits the program
+func emitReturn(config *TargetConfig, ps *ProgramState, st Statement) string {
+	asmcode := ""
+	if st[0].Value == "ret" {
+		if (ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction) {
+			//log.Println("Not taking down stack frame in the main/_start/start function.")
+		} else {
+			switch config.PlatformBits {
+			case 64:
+				asmcode += "\t;--- takedown stack frame ---\n"
+				asmcode += "\tmov rsp, rbp\t\t\t; use base pointer as new stack pointer\n"
+				asmcode += "\tpop rbp\t\t\t\t; get the old base pointer\n\n"
+			case 32:
+				asmcode += "\t;--- takedown stack frame ---\n"
+				asmcode += "\tmov esp, ebp\t\t\t; use base pointer as new stack pointer\n"
+				asmcode += "\tpop ebp\t\t\t\t; get the old base pointer\n\n"
+			}
+		}
+	}
+	if ps.inFunction != "" {
+		if !config.BootableKernel && !ps.endless && (ps.inFunction == "main") {
+			asmcode += "\n\t;--- return from \"" + ps.inFunction + "\" ---\n"
+		}
+	} else if st[0].Value == "exit" {
+		asmcode += "\t;--- exit program ---\n"
+	} else {
+		asmcode += "\t;--- return ---\n"
+	}
+	if (st[0].Value == "exit") || (ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction) {
+		// Not returning from main/_start/start function, but exiting properly
+		exitCode := "0"
+		if (len(st) == 2) && ((st[1].T == VALUE) || (st[1].T == REGISTER)) {
+			exitCode = st[1].Value
+		}
+		if !config.BootableKernel {
+			switch config.PlatformBits {
+			case 64:
+				asmcode += "\tmov rax, 60\t\t\t; function call: 60\n\t"
+				if exitCode == "0" {
+					asmcode += "xor rdi, rdi"
+				} else {
+					asmcode += "mov rdi, " + exitCode
+				}
+				asmcode += "\t\t\t; return code " + exitCode + "\n"
+				asmcode += "\tsyscall\t\t\t\t; exit program\n"
+			case 32:
+				if config.macOS {
+					asmcode += "\tpush dword " + exitCode + "\t\t\t; exit code " + exitCode + "\n"
+					asmcode += "\tsub esp, 4\t\t\t; the BSD way, push then subtract before calling\n"
+				}
+				asmcode += "\tmov eax, 1\t\t\t; function call: 1\n"
+				if !config.macOS {
+					asmcode += "\t"
+					if exitCode == "0" {
+						asmcode += "xor ebx, ebx"
+					} else {
+						asmcode += "mov ebx, " + exitCode
+					}
+					asmcode += "\t\t\t; exit code " + exitCode + "\n"
+				}
+				asmcode += "\tint 0x80\t\t\t; exit program\n"
+			case 16:
+				// Unless "exit" or "noret" is specified explicitly, use "ret"
+				if config.BootSector && ((st[0].Value == "exit") || ((st[0].Value != "noret") && !ps.endless)) {
+					asmcode += bootSectorHalt
+				} else if st[0].Value == "exit" {
+					// Since we are not building a kernel, calling DOS interrupt 21h makes sense
+					asmcode += "\tmov ah, 0x4c\t\t\t; function 4C\n"
+					if exitCode == "0" {
+						asmcode += "\txor al, al\t\t\t; exit code " + exitCode + "\n"
+					} else {
+						asmcode += "\tmov al, " + exitCode + "\t\t\t; exit code " + exitCode + "\n"
+					}
+					asmcode += "\tint 0x21\t\t\t; exit program\n"
+				} else if st[0].Value == "noret" {
+					asmcode += "\t; there is no return\n"
+				} else {
+					if !ps.endless {
+						asmcode += "\tret\t\t\t; exit program\n"
+					} else {
+						asmcode += "\t; endless loop, there is no return\n"
+					}
+				}
+			}
+		} else {
+			// For bootable kernels, main does not return. Hang instead.
+			log.Println("Warning: Bootable kernels has nowhere to return after the main function. You might want to use the \"halt\" builtin at the end of the main function.")
+			//asmcode += Statement{Token{BUILTIN, "halt", st[0].line, ""}}.String()
+		}
+	} else {
+		log.Println("function ", ps.inFunction)
+		// Do not return eax=0/rax=0 if no return value is explicitly provided, by design
+		// This allows the return value from the previous call to be returned instead
+		asmcode += "\tret\t\t\t\t; Return\n"
+	}
+	if ps.inFunction != "" {
+		// Exiting from the function definition
+		ps.inFunction = ""
+		// If the function was ended with "exit", don't freak out if an "end" is encountered
+		if st[0].Value == "exit" {
+			ps.surpriseEndingWithExit = true
+		}
+	}
+	return asmcode
+}
+
+// emitMemoryAssignment writes to memory
+func emitMemoryAssignment(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// memory assignment
+	return "\tmov [" + st[1].Value + "], " + st[3].Value + "\t\t; " + "memory assignment" + "\n"
+}
+
+// emitMemoryByteAssignment writes a byte to memory
+func emitMemoryByteAssignment(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// memory assignment (byte)
+	val := st[3].Value
+	if st[3].T == REGISTER {
+		val = downgradeToByte(val)
+	}
+	return "\tmov BYTE [" + st[1].Value + "], " + val + "\t\t; " + "memory assignment" + "\n"
+}
+
+// emitMemoryWordAssignment writes a word to memory
+func emitMemoryWordAssignment(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// memory assignment (word)
+	val := st[3].Value
+	if st[3].T == REGISTER {
+		val = regToWord(val)
+	}
+	return "\tmov WORD [" + st[1].Value + "], " + val + "\t\t; " + "memory assignment" + "\n"
+}
+
+// emitMemoryDoubleAssignment writes a double word to memory
+func emitMemoryDoubleAssignment(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// memory assignment (double)
+	val := st[3].Value
+	if st[3].T == REGISTER {
+		val = regToDouble(val)
+	}
+	return "\tmov DOUBLE [" + st[1].Value + "], " + val + "\t\t; " + "memory assignment" + "\n"
+}
+
+// emitMemoryRead reads from memory into a register
+func emitMemoryRead(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// assignment from memory to register
+	return "\tmov " + st[0].Value + ", [" + st[3].Value + "]\t\t; memory assignment\n"
+}
+
+// emitMemoryByteRead reads a byte from memory into a register
+func emitMemoryByteRead(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// assignment from memory to register (byte)
+	val := st[0].Value
+	if st[0].T == REGISTER {
+		val = downgradeToByte(val)
+	}
+	return "\tmov BYTE " + val + ", [" + st[3].Value + "]\t\t; memory assignment (byte)\n"
+}
+
+// emitMemoryWordRead reads a word from memory into a register
+func emitMemoryWordRead(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// assignment from memory to register (byte)
+	val := st[0].Value
+	if st[0].T == REGISTER {
+		val = regToWord(val)
+	}
+	return "\tmov WORD " + val + ", [" + st[3].Value + "]\t\t; memory assignment (word)\n"
+}
+
+// emitMemoryDoubleRead reads a double word from memory into a register
+func emitMemoryDoubleRead(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// assignment from memory to register (byte)
+	val := st[0].Value
+	if st[0].T == REGISTER {
+		val = regToDouble(val)
+	}
+	return "\tmov DOUBLE " + val + ", [" + st[3].Value + "]\t\t; memory assignment (double)\n"
+}
+
+// emitListAssignment assigns to an element of a built-in list, like sysparam[1]
+func emitListAssignment(config *TargetConfig, ps *ProgramState, st Statement) string {
+	retval := "\tmov " + config.reservedAndValue(st[:2]) + ", " + st[3].Value + "\t\t\t; "
+	if (config.PlatformBits == 32) && (st[3].T != REGISTER) {
+		retval = strings.Replace(retval, "mov", "mov DWORD", 1)
+	}
+	pointercomment := ""
+	if st[3].T == VALIDNAME {
+		pointercomment = "&"
+	}
+	retval += fmt.Sprintf("%s[%s] = %s%s\n", st[0].Value, st[1].Value, pointercomment, st[3].Value)
+	return retval
+}
+
+// emitListRead reads an element of a built-in list, like funparam[0]
+func emitListRead(config *TargetConfig, ps *ProgramState, st Statement) string {
+	retval := "\tmov " + st[0].Value + ", " + config.reservedAndValue(st[2:]) + "\t\t\t; "
+	retval += fmt.Sprintf("%s = %s[%s]\n", st[0].Value, st[2].Value, st[3].Value)
+	return retval
+}
+
+// emitBinaryOperation divides a register or value by another and places the result in a register
+func emitBinaryOperation(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// Statements like "a = b % c" or "a = b /s c"
+	signed := strings.HasSuffix(st[3].Value, "s")
+	return config.division(st[0].Value, st[2], st[4], signed, strings.HasPrefix(st[3].Value, "%"))
+}
+
+// emitListCopy copies an element of a built-in list to another
+func emitListCopy(config *TargetConfig, ps *ProgramState, st Statement) string {
+	retval := ""
+	if config.PlatformBits != 32 {
+		retval = "\tmov " + config.reservedAndValue(st[:2]) + ", " + config.reservedAndValue(st[3:]) + "\t\t\t; "
+	} else {
+		retval = "\tmov eax, " + config.reservedAndValue(st[3:]) + "\t\t\t; Uses eax as a temporary variable\n"
+		retval += "\tmov " + config.reservedAndValue(st[:2]) + ", ebx\t\t\t; "
+	}
+	retval += fmt.Sprintf("%s[%s] = %s[%s]\n", st[0].Value, st[1].Value, st[3].Value, st[4].Value)
+	return retval
+}
+
+// emitInlineAssembly outputs a line of assembly, if it is for the current platform
+func emitInlineAssembly(config *TargetConfig, ps *ProgramState, st Statement) string {
+	targetBits, err := strconv.Atoi(st[1].Value)
+	if err != nil {
+		fatal("Error: " + st[1].Value + " is not a valid platform bit size (like 32 or 64)")
+	}
+	if config.PlatformBits == targetBits {
+		// Add the rest of the line as a regular assembly expression
+		if len(st) == 7 {
+			comma1 := " "
+			comma2 := ", "
+			if st[4].T == QUAL {
+				comma1 = ", "
+				comma2 = " "
+			}
+			// with address calculations
+			if strings.Contains(st[5].Value, "+") || strings.Contains(st[5].Value, "-") {
+				return "\t" + st[2].Value + " " + st[3].Value + " " + st[4].Value + " " + st[5].Value + " " + st[6].Value + "\t\t\t; asm with address calculation\n"
+			} else if strings.HasPrefix(st[2].Value, "i") {
+				comma1 = ", "
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + " " + st[6].Value + "\t\t\t; asm with integer maths\n"
+			} else {
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + " " + st[6].Value + "\t\t\t; asm with floating point instructions\n"
+			}
+		} else if len(st) == 6 {
+			comma1 := " "
+			comma2 := ", "
+			if st[4].T == QUAL {
+				comma1 = ", "
+				comma2 = " "
+			}
+			// with address calculations
+			if strings.Contains(st[5].Value, "+") || strings.Contains(st[5].Value, "-") {
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + "\t\t\t; asm with address calculation\n"
+			} else if strings.HasPrefix(st[2].Value, "i") {
+				comma1 = ", "
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + "\t\t\t; asm with integer maths\n"
+			} else {
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + "\t\t\t; asm with floating point instructions\n"
+			}
+		} else if len(st) == 5 {
+			comma2 := ", "
+			if st[3].T == QUAL {
+				comma2 = " "
+			}
+			// with address calculations
+			if strings.Contains(st[4].Value, "+") || strings.Contains(st[4].Value, "-") {
+				return "\t" + st[2].Value + " " + st[3].Value + comma2 + st[4].Value + "\t\t\t; asm with address calculation\n"
+			} else if st[3].Value == "st" {
+				return "\t" + st[2].Value + " " + st[3].Value + " (" + st[4].Value + ")\t\t\t; asm\n"
+			} else {
+				return "\t" + st[2].Value + " " + st[3].Value + comma2 + st[4].Value + "\t\t\t; asm\n"
+			}
+		} else if len(st) == 4 {
+			return "\t" + st[2].Value + " " + st[3].Value + "\t\t\t; asm\n"
+		} else if len(st) == 3 {
+			// a label or keyword like "stosb"
+			if strings.Contains(st[2].Value, ":") {
+				return "\t" + st[2].Value + "\t\t\t; asm label\n"
+			}
+			return "\t" + st[2].Value + "\t\t\t; asm\n"
+		} else {
+			fatal("Error: Unrecognized length of assembly expression:", len(st)-2, "in:", tokensString(st[2:]))
+		}
+	}
+	// Not the target bits, skip
+	return ""
+}
+
+// emitFunction starts a function
+func emitFunction(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inFunction != "" {
+		fatalf("Error: Missing \"ret\" or \"end\"? Already in a function named %s when declaring function %s.\n", ps.inFunction, st[1].Value)
+	}
+	asmcode := ";--- function " + st[1].Value + " ---\n"
+	ps.inFunction = st[1].Value
+	// Store the name of the declared function in defined_names
+	if has(ps.definedNames, ps.inFunction) {
+		fatal("Error: Can not declare function, name is already defined:", ps.inFunction)
+	}
+	ps.definedNames = append(ps.definedNames, ps.inFunction)
+	ps.functions = append(ps.functions, ps.inFunction)
+	if config.PlatformBits != 16 {
+		asmcode += "global " + ps.inFunction + "\t\t\t; make label available to the linker\n"
+	}
+	asmcode += ps.inFunction + ":\t\t\t\t; name of the function\n\n"
+	if (ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction) {
+		//log.Println("Not setting up stack frame in the main/_start/start function.")
+		return asmcode
+	}
+	switch config.PlatformBits {
+	case 64:
+		asmcode += "\t;--- setup stack frame ---\n"
+		asmcode += "\tpush rbp\t\t\t; save old base pointer\n"
+		asmcode += "\tmov rbp, rsp\t\t\t; use stack pointer as new base pointer\n"
+	case 32:
+		asmcode += "\t;--- setup stack frame ---\n"
+		asmcode += "\tpush ebp\t\t\t; save old base pointer\n"
+		asmcode += "\tmov ebp, esp\t\t\t; use stack pointer as new base pointer\n"
+	}
+	return asmcode
+}
+
+// emitCall calls a function
+func emitCall(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if st[1].T == VALIDNAME {
+		return "\t;--- call the \"" + st[1].Value + "\" function ---\n\tcall " + st[1].Value + "\n"
+	}
+	fatal("Error: Calling an invalid name:", st[1].Value)
+	// TODO: Find a shorter format to describe matching tokens.
+	// Something along the lines of: if match(st, [KEYWORD:"extern"], 2)
+	return ""
+}
+
+// emitCounter sets the loop counter
+func emitCounter(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return "\tmov " + config.counterRegister() + ", " + st[1].Value + "\t\t\t; set (loop) counter\n"
+}
+
+// emitValue sets the value that is written by loopwrite and write
+func emitValue(config *TargetConfig, ps *ProgramState, st Statement) string {
+	asmcode := ""
+	switch config.PlatformBits {
+	case 64:
+		asmcode = "\tmov rax, " + st[1].Value + "\t\t\t; set value, in preparation for looping\n"
+		ps.loopStep = 8
+	case 32:
+		asmcode = "\tmov eax, " + st[1].Value + "\t\t\t; set value, in preparation for looping\n"
+		ps.loopStep = 4
+	case 16:
+		// Find out if the value is a byte or a word, then set a global variable to keep track of if the nest loop should be using stosb or stosw
+		if st[1].T == VALUE {
+			if (strings.HasPrefix(st[1].Value, "0x") && (len(st[1].Value) == 6)) || (numbits(st[1].Value) > 8) {
+				asmcode += "\tmov ax, " + st[1].Value + "\t\t\t; set value, in preparation for stosw\n"
+				ps.loopStep = 2
+			} else if (strings.HasPrefix(st[1].Value, "0x") && (len(st[1].Value) == 4)) || (numbits(st[1].Value) <= 8) {
+				asmcode += "\tmov al, " + st[1].Value + "\t\t\t; set value, in preparation for stosb\n"
+				ps.loopStep = 1
+			} else {
+				fatal("Error: Unable to tell if this is a word or a byte:", st[1].Value)
+			}
+		} else if st[1].T == REGISTER {
+			switch st[1].Value {
+			// TODO: Introduce a function for checking if a register is 8-bit, 16-bit, 32-bit or 64-bit
+			case "al", "ah", "bl", "bh", "cl", "ch", "dl", "dh":
+				asmcode += "\tmov al, " + st[1].Value + "\t\t\t; set value from register, in preparation for stosb\n"
+				ps.loopStep = 1
+			default:
+				asmcode += "\tmov ax, " + st[1].Value + "\t\t\t; Set value from register, in preparation for stosw\n"
+				ps.loopStep = 2
+			}
+		} else {
+			fatal("Error: Unable to tell if this is a word or a byte:", st[1].Value)
+		}
+	default:
+		fatal("Error: Unimplemented: the", st[0].Value, "keyword for", config.PlatformBits, "bit platforms")
+	}
+	return asmcode
+}
+
+// emitLoopwrite writes a value to memory, as many times as the loop counter says
+func emitLoopwrite(config *TargetConfig, ps *ProgramState, st Statement) string {
+	asmcode := ""
+	switch config.PlatformBits {
+	case 16:
+		if ps.loopStep == 2 {
+			asmcode += "\trep stosw\t\t\t; write the value in ax, cx times, starting at es:di\n"
+		} else { // if ps.loop_step == 1 {
+			asmcode += "\trep stosb\t\t\t; write the value in al, cx times, starting at es:di\n"
+		}
+	default:
+		asmcode += "\tcld\n\trep stosb\t\t\t; write the value in eax/rax, ecx/rcx times, starting at edi/rdi\n"
+	}
+	return asmcode
+}
+
+// emitWrite writes a value to memory
+func emitWrite(config *TargetConfig, ps *ProgramState, st Statement) string {
+	asmcode := ""
+	switch config.PlatformBits {
+	case 16:
+		if ps.loopStep == 2 {
+			asmcode += "\tstosw\t\t\t; write the value in ax, starting at es:di\n"
+		} else { // if ps.loop_step == 1 {
+			asmcode += "\tstosb\t\t\t; write the value in al, starting at es:di\n"
+		}
+		//else fatal("Error: Unrecognized step size. Defaulting to 1.")
+	default:
+		fatal("Error: Unimplemented: the", st[0].Value, "keyword for", config.PlatformBits, "bit platforms")
+	}
+	return asmcode
+}
+
+// emitLoop starts a loop or a rawloop
+func emitLoop(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// TODO: Make every instruction and call declare which registers they will change. This allows for better use of the registers.
+
+	// The start of a rawloop or loop, that have an optional counter value and ends with "end"
+	rawloop := (st[0].Value == "rawloop")
+	hascounter := (len(st) == 2)
+	endlessloop := !rawloop && !hascounter
+
+	// Find a suitable label
+	label := ""
+	if rawloop {
+		label = rawloopPrefix + ps.newLoopLabel()
+	} else {
+		if endlessloop {
+			label = endlessloopPrefix + ps.newLoopLabel()
+		} else {
+			label = ps.newLoopLabel()
+		}
+	}
+
+	// Now in the loop, in_loop is global
+	ps.inLoop = label
+
+	asmcode := ""
+
+	// Initialize the loop, if it was given a number
+	if !hascounter {
+		asmcode += "\t;--- loop ---\n"
+	} else {
+		if endlessloop {
+			asmcode += "\t;--- endless loop ---\n"
+		} else {
+			asmcode += "\t;--- loop " + st[1].Value + " times ---\n"
+			asmcode += "\tmov " + config.counterRegister() + ", " + st[1].Value
+			asmcode += "\t\t\t; initialize loop counter\n"
+		}
+	}
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+
+	// If it's not a raw loop (or endless loop), take care of the counter
+	if (!rawloop) && (!endlessloop) {
+		asmcode += "\tpush " + config.counterRegister() + "\t\t\t; save the counter\n"
+	}
+	return asmcode
+}
+
+// emitAddress sets the address that is written to by loopwrite and write
+func emitAddress(config *TargetConfig, ps *ProgramState, st Statement) string {
+	asmcode := ""
+	switch config.PlatformBits {
+	case 16:
+		segmentOffset := st[1].Value
+		if !strings.Contains(segmentOffset, ":") {
+			fatal("Error: address takes a segment:offset value")
+		}
+		sl := strings.SplitN(segmentOffset, ":", 2)
+		if len(sl) != 2 {
+			fatal("Error: Unrecognized segment:offset address:", segmentOffset)
+		}
+		segment := sl[0]
+		offset := sl[1]
+		log.Println("Found segment", segment, "and offset", offset)
+		asmcode += "\tpush " + segment + "\t\t\t; can not mov directly into es\n"
+		asmcode += "\tpop es\t\t\t\t; segment = " + segment + "\n"
+		// TODO: Introduce a function that checks of 0, 0x0, 0x00, 0x0000 and all other variations of zero
+		if offset == "0" {
+			asmcode += "\txor di, di\t\t\t; offset = " + offset + "\n"
+		} else {
+			asmcode += "\tmov di, " + offset + "\t\t\t; di = " + offset + "\n"
+		}
+	case 32:
+		asmcode += "\tmov edi, " + st[1].Value + "\t\t\t; set address/offset\n"
+	case 64:
+		asmcode += "\tmov rdi, " + st[1].Value + "\t\t\t; set address/offset\n"
+	default:
+		fatal("Error: Unimplemented: the", st[0].Value, "keyword for", config.PlatformBits, "bit platforms")
+	}
+	return asmcode
+}
+
+// emitBootable outputs a multiboot header, for bootable kernels
+func emitBootable(config *TargetConfig, ps *ProgramState, st Statement) string {
+	config.BootableKernel = true
+	// This program is supposed to be bootable
+	return `
+; Thanks to http://wiki.osdev.org/Bare_Bones_with_NASM
+
+; Declare constants used for creating a multiboot header.
+MBALIGN     equ  1<<0                   ; align loaded modules on page boundaries
+MEMINFO     equ  1<<1                   ; provide memory map
+FLAGS       equ  MBALIGN | MEMINFO      ; this is the Multiboot 'flag' field
+MAGIC       equ  0x1BADB002             ; 'magic number' lets bootloader find the header
+CHECKSUM    equ -(MAGIC + FLAGS)        ; checksum of above, to prove we are multiboot
+
+; Declare a header as in the Multiboot Standard. We put this into a special
+; section so we can force the header to be in the start of the final program.
+; You don't need to understand all these details as it is just magic values that
+; is documented in the multiboot standard. The bootloader will search for this
+; magic sequence and recognize us as a multiboot kernel.
+section .multiboot
+align 4
+	dd MAGIC
+	dd FLAGS
+	dd CHECKSUM
+
+; Currently the stack pointer register (esp) points at anything and using it may
+; cause massive harm. Instead, we'll provide our own stack. We will allocate
+; room for a small temporary stack by creating a symbol at the bottom of it,
+; then allocating 16384 bytes for it, and finally creating a symbol at the top.
+section .bootstrap_stack
+align 4
+stack_bottom:
+times 16384 db 0
+stack_top:
+
+section .text
+`
+	//'
+}
+
+// emitExtern declares an external symbol
+func emitExtern(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if st[1].T == VALIDNAME {
+		extname := st[1].Value
+		// Declare the external name
+		if has(ps.definedNames, extname) {
+			fatal("Error: Can not declare external symbol, name is already defined: " + extname)
+		}
+		// Store the name of the declared constant in defined_names
+		ps.definedNames = append(ps.definedNames, extname)
+		ps.functions = append(ps.functions, extname)
+		// Return a comment
+		return "extern " + extname + "\t\t\t; external symbol\n"
+	}
+	fatal("Error: extern with invalid name:", st[1].Value)
+	return ""
+}
+
+// emitBreakIf breaks out of a loop if a comparison is true
+func emitBreakIf(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// breakif
+	if ps.inStructuredLoop() {
+		jumps := config.comparisonJumps(ps, st[1], st[2].Value, st[3])
+		asmcode := config.compare(ps, st[1], st[3], "compare")
+		return asmcode + config.breakIf(ps, jumps[0], jumps[1], st[1].Value+" "+st[2].Value+" "+st[3].Value)
+	}
+	if ps.inLoop != "" {
+		asmcode := ""
+		rawloop := strings.HasPrefix(ps.inLoop, rawloopPrefix)     // Is it a rawloop?
+		endless := strings.HasPrefix(ps.inLoop, endlessloopPrefix) // Is it endless?
+		if !rawloop && !endless {
+			asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+		}
+
+		// Break if something comparison something
+		asmcode += config.compare(ps, st[1], st[3], "compare")
+
+		// Conditional jump
+		asmcode += "\t" + config.comparisonJumps(ps, st[1], st[2].Value, st[3])[0]
+
+		// Which label to jump to (out of the loop)
+		asmcode += " " + ps.inLoop + "_end\t\t\t; break\n"
+		return asmcode
+	}
+	fatal("Error: Unclear which loop one should break out of.")
+	return ""
+}
+
+// emitBreak breaks out of a loop
+func emitBreak(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inLoop != "" {
+		asmcode := ""
+		if saved := config.loopSavedRegister(ps); saved != "" {
+			asmcode += "\tpop " + saved + "\t\t\t\t; restore counter\n"
+		}
+		asmcode += "\tjmp " + ps.inLoop + "_end\t\t\t; break\n"
+		return asmcode
+	}
+	fatal("Error: Unclear which loop one should break out of.")
+	return ""
+}
+
+// emitContinueIf continues from the top of a loop if a comparison is true
+func emitContinueIf(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// continueif
+	if ps.inStructuredLoop() {
+		jumps := config.comparisonJumps(ps, st[1], st[2].Value, st[3])
+		asmcode := config.compare(ps, st[1], st[3], "compare")
+		return asmcode + config.continueIf(ps, st[0], jumps[0], jumps[1], st[1].Value+" "+st[2].Value+" "+st[3].Value)
+	}
+	if ps.inLoop != "" {
+		asmcode := ""
+		rawloop := strings.HasPrefix(ps.inLoop, rawloopPrefix)     // Is it a rawloop?
+		endless := strings.HasPrefix(ps.inLoop, endlessloopPrefix) // Is it endless?
+		if !rawloop && !endless {
+			asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+		}
+
+		// Continue looping if the counter is greater than zero
+		//asmcode += "\tloop " + in_loop + "\t\t\t; continue\n"
+		// loop can only jump <= 127 bytes away. Use dec and jnz instead
+		if !endless {
+			asmcode += "\tdec " + config.counterRegister() + "\t\t\t\t; decrease counter\n"
+			asmcode += "\tjz " + ps.inLoop + "_end\t\t\t; jump out if the loop is done\n"
+		}
+
+		// Continue if something comparison something
+		asmcode += config.compare(ps, st[1], st[3], "compare")
+
+		// Conditional jump
+		asmcode += "\t" + config.comparisonJumps(ps, st[1], st[2].Value, st[3])[0]
+
+		// Jump to the top if the condition is true
+		asmcode += " " + ps.inLoop + "\t\t\t; continue\n"
+
+		return asmcode
+	}
+	fatal("Error: Unclear which loop one should continue to the top of.")
+	return ""
+}
+
+// emitContinue continues from the top of a loop
+func emitContinue(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inStructuredLoop() {
+		return "\tjmp " + ps.inLoop + "_continue\t\t\t; continue\n"
+	}
+	if ps.inLoop != "" {
+		asmcode := ""
+		rawloop := strings.HasPrefix(ps.inLoop, rawloopPrefix)     // Is it a rawloop?
+		endless := strings.HasPrefix(ps.inLoop, endlessloopPrefix) // Is it endless?
+		if !rawloop && !endless {
+			asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+		}
+		// Continue looping if the counter is greater than zero
+		//asmcode += "\tloop " + in_loop + "\t\t\t; continue\n"
+		// loop can only jump <= 127 bytes away. Using dec and jnz instead
+		if !endless {
+			asmcode += "\tdec " + config.counterRegister() + "\t\t\t\t; decrease counter\n"
+			asmcode += "\tjnz " + ps.inLoop + "\t\t\t; continue if not zero\n"
+			// If the counter is zero after restoring the counter, jump out of the loop
+			asmcode += "\tjz " + ps.inLoop + "_end\t\t\t; jump out if the loop is done\n"
+		} else {
+			asmcode += "\tjmp " + ps.inLoop + "\t\t\t; continue\n"
+		}
+		return asmcode
+	}
+	fatal("Error: Unclear which loop one should continue to the top of.")
+	return ""
+}
+
+// emitEndless marks the program as never returning
+func emitEndless(config *TargetConfig, ps *ProgramState, st Statement) string {
+	//ps.in_loop = ""
+	//ps.in_function = ""
+	ps.endless = true
+	return "; there is no return\n"
+}
+
+// emitEnd ends an if block, a loop or a function
+func emitEnd(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inStruct != nil {
+		return config.endStruct(ps)
+	} else if ps.inIfBlock != "" {
+		// End the if block
+		asmcode := ""
+		asmcode += ps.inIfBlock + "_end:\t\t\t\t; end of if block " + ps.inIfBlock + "\n"
+		ps.inIfBlock = ""
+		return asmcode
+	} else if strings.HasPrefix(ps.inLoop, doPrefix) {
+		fatal("Error: A do loop is ended with \"until\" and a condition, not with \"end\"")
+	} else if ps.inStructuredLoop() {
+		return config.endLoop(ps)
+	} else if ps.inLoop != "" {
+		asmcode := ""
+		rawloop := strings.HasPrefix(ps.inLoop, rawloopPrefix)     // Is it a rawloop?
+		endless := strings.HasPrefix(ps.inLoop, endlessloopPrefix) // Is it endless?
+		if !rawloop && !endless {
+			asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+		}
+		if endless {
+			asmcode += "\tjmp " + ps.inLoop + "\t\t\t\t; loop forever\n"
+			ps.endless = true
+		} else {
+			//asmcode += "\tloop " + in_loop + "\t\t\t\t; loop until " + config.counter_register() + " is zero\n"
+			asmcode += "\tdec " + config.counterRegister() + "\t\t\t\t; decrease counter\n"
+			asmcode += "\tjnz " + ps.inLoop + "\t\t\t\t; loop until " + config.counterRegister() + " is zero\n"
+		}
+		asmcode += ps.inLoop + "_end:\t\t\t\t; end of loop " + ps.inLoop + "\n"
+		asmcode += "\t;--- end of loop " + ps.inLoop + " ---\n"
+		ps.inLoop = ""
+		return asmcode
+	} else if ps.inFunction != "" {
+		// Return from the function if "end" is encountered
+		ret := Token{KEYWORD, "ret", st[0].Line, ""}
+		newstatement := Statement{ret}
+		return newstatement.String(ps, config)
+	} else {
+		// If the function was already ended with "exit", don't freak out when encountering an "end"
+		if !ps.surpriseEndingWithExit && !ps.endless {
+			fatal("Error: Not in a function or block of inline C, hard to tell what should be ended with \"end\". Statement nr:", st[0].Line)
+		} else {
+			// Prepare for more surprises
+			ps.surpriseEndingWithExit = false
+			// Ignore this "end"
+			return ""
+		}
+	}
+	return ""
+}
+
+// emitNameCall calls a function by name
+func emitNameCall(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// Just a name, assume it's a function call
+	if has(ps.definedNames, st[0].Value) {
+		call := Token{KEYWORD, "call", st[0].Line, ""}
+		newstatement := Statement{call, st[0]}
+		return newstatement.String(ps, config)
+	}
+	fatal("Error: No function named:", st[0].Value)
+	return ""
+}
+
+// callArgument checks if the token can be an argument in a function call, like: kmain(rax, 5)
+func callArgument(t Token) bool {
+	return (t.T == REGISTER) || (t.T == VALUE) || (t.T == VALIDNAME)
+}
+
+// callable checks if the statement starts with the name of a defined or external function, outside of a struct declaration
+func callable(ps *ProgramState, st Statement) bool {
+	return (ps.inStruct == nil) && has(ps.functions, st[0].Value)
+}
+
+// emitArgumentCall calls a function with arguments, like: kmain(rax, 5)
+// The arguments are passed like for C functions, and calls to C functions in the inline C are checked against their signatures.
+// Names are passed as addresses.
+func emitArgumentCall(config *TargetConfig, ps *ProgramState, st Statement) string {
+	name, args := st[0].Value, st[1:]
+	for _, arg := range args {
+		if !callArgument(arg) {
+			fatal("Error: Arguments to functions can be registers, values or names, not:", arg.Value)
+		}
+	}
+	if f, ok := ps.cFunctions[name]; ok {
+		f.checkArguments(args)
+	}
+	call := Statement{Token{KEYWORD, "call", st[0].Line, ""}, st[0]}
+	asmcode := ""
+	switch config.PlatformBits {
+	case 64:
+		if len(args) > 6 {
+			fatal("Error: At most 6 arguments can be passed in registers, not", len(args), "to", name)
+		}
+		// If a register argument is overwritten by an earlier argument, the arguments are moved via the stack
+		viaStack := false
+		for i, arg := range args {
+			for j := 0; (j < i) && (arg.T == REGISTER); j++ {
+				if (arg.Value == config.paramnum2reg(j)) || (upgrade(arg.Value) == config.paramnum2reg(j)) {
+					viaStack = true
+				}
+			}
+		}
+		if viaStack {
+			for i, arg := range args {
+				if (arg.T == REGISTER) && (registerBits(arg.Value) != 64) {
+					fatal("Error: Only 64-bit registers can be passed in the registers of other arguments, not:", arg.Value)
+				}
+				asmcode += "\tpush " + arg.Value + "\t\t\t; parameter #" + strconv.Itoa(i) + "\n"
+			}
+			for i := len(args) - 1; i >= 0; i-- {
+				asmcode += "\tpop " + config.paramnum2reg(i) + "\t\t\t\t; parameter #" + strconv.Itoa(i) + "\n"
+			}
+		} else {
+			for i, arg := range args {
+				assignment := Statement{Token{REGISTER, config.paramnum2reg(i), arg.Line, ""}, Token{ASSIGNMENT, "=", arg.Line, ""}, arg}
+				asmcode += strings.TrimSuffix(assignment.String(ps, config), "\n") + "\n"
+			}
+		}
+		return asmcode + call.String(ps, config)
+	case 32:
+		// The arguments are pushed in reverse order, and removed from the stack by the caller
+		for i := len(args) - 1; i >= 0; i-- {
+			switch {
+			case args[i].T != REGISTER:
+				asmcode += "\tpush dword " + args[i].Value + "\t\t; parameter #" + strconv.Itoa(i) + "\n"
+			case registerBits(args[i].Value) == 32:
+				asmcode += "\tpush " + args[i].Value + "\t\t\t; parameter #" + strconv.Itoa(i) + "\n"
+			default:
+				fatal("Error: Only 32-bit registers can be passed as arguments, not:", args[i].Value)
+			}
+		}
+		asmcode += call.String(ps, config)
+		return asmcode + "\tadd esp, " + strconv.Itoa(len(args)*4) + "\t\t\t; remove the arguments from the stack\n"
+	}
+	fatal("Error: Calling functions with arguments is not implemented for 16-bit, yet")
+	return ""
+}
+
+// emitNoret marks the end of a function that does not return
+func emitNoret(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return "; end without a return\n"
+}
+
+// emitInlineC marks the start of a block of inline C
+func emitInlineC(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return "; start of inline C block\n"
+}
+
+// emitInlineAsmBlock outputs a block of assembly as it is, if it is for all platforms or for the current platform
+func emitInlineAsmBlock(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if len(st) > 1 {
+		targetBits, err := strconv.Atoi(st[1].Value)
+		if (err != nil) || !hasi([]int{16, 32, 64}, targetBits) {
+			fatal("Error: " + st[1].Value + " is not a valid platform bit size (like 32 or 64)")
+		}
+		if config.PlatformBits != targetBits {
+			// Not the target bits, skip
+			return ""
+		}
+	}
+	return "\t;--- inline assembly ---\n" + st[0].extra + "\t;--- end of inline assembly ---\n"
+}
+
+// emitIfBlock starts an if block that is run if the comparison is true
+func emitIfBlock(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inIfBlock != "" {
+		fatal("Error: Already in an if-block (nested block are to be implemented)")
+	}
+	ps.inIfBlock = ps.newIfLabel()
+
+	asmcode := "\t;--- " + ps.inIfBlock + " ---\n"
+
+	// Start an if block that is run if the comparison is true
+	// Break if something comparison something
+	asmcode += config.compare(ps, st[0], st[2], "compare")
+
+	// Conditional jump if NOT true
+	asmcode += "\t" + config.comparisonJumps(ps, st[0], st[1].Value, st[2])[1]
+
+	// Which label to jump to (out of the if block)
+	// TODO: Nested if blocks
+	asmcode += " " + ps.inIfBlock + "_end\t\t\t; break\n"
+	return asmcode
+}
+
+// emitIf starts an if block that is run if the comparison after "if" is true
+func emitIf(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return emitIfBlock(config, ps, st[1:])
+}
+
+// emitIfCondition starts an if block that is run if a condition with "and" or "or" is true
+func emitIfCondition(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inIfBlock != "" {
+		fatal("Error: Already in an if-block (nested block are to be implemented)")
+	}
+	ps.inIfBlock = ps.newIfLabel()
+	asmcode := "\t;--- " + ps.inIfBlock + " ---\n"
+	return asmcode + config.jumpUnless(ps, st[1:], ps.inIfBlock+"_end")
+}
+
+// emitAssignValue assigns a value or the address of a name to a register
+func emitAssignValue(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if st[2].Value == "0" {
+		return "\txor " + st[0].Value + ", " + st[0].Value + "\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value
+	}
+	a := st[0].Value
+	b := st[2].Value
+	if is32bit(a) && is64bit(b) {
+		log.Println("Warning: Using", b, "as a 32-bit register when assigning.")
+		return "\tmov " + a + ", " + downgrade(b) + "\t\t; " + a + " " + st[1].Value + " " + b
+	} else if is64bit(a) && is32bit(b) {
+		log.Println("Warning: Using", a, "as a 32-bit register when assigning.")
+		asmcode := "\txor rax, rax\t\t; clear rax\n"
+		asmcode += "\tmov " + downgrade(a) + ", " + b + "\t\t; " + a + " " + st[1].Value + " " + b
+		return asmcode
+	}
+	return "\tmov " + st[0].Value + ", " + st[2].Value + "\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value
+}
+
+// emitAssignRegister assigns a register to a register, zero extending it if it is smaller
+func emitAssignRegister(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if registerBits(st[0].Value) > registerBits(st[2].Value) && registerBits(st[2].Value) > 0 {
+		// Zero extend the smaller register
+		return extendedAssignment(st[0].Value, st[2].Value, false)
+	}
+	return "\tmov " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value
+}
+
+// emitSignedAssignment assigns a register to a register, sign extending it if it is smaller
+func emitSignedAssignment(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if st[2].T == VALUE {
+		return "\tmov " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value
+	}
+	return extendedAssignment(st[0].Value, st[2].Value, true)
+}
+
+// emitDisregard throws away a value
+func emitDisregard(config *TargetConfig, ps *ProgramState, st Statement) string {
+	// TODO: If st[2] is a function, one wishes to call it, then disregard afterwards
+	return "\t\t\t\t; Disregarding: " + st[2].Value + "\n"
+}
+
+// emitStack pushes to and pops from the stack
+func emitStack(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (st[0].Value == "stack") && (st[2].Value == "stack") {
+		fatal("Error: can't pop and push to stack at the same time")
+	} else if st[2].Value == "stack" {
+		// something -> stack (push)
+		return "\tpush " + st[0].Value + "\t\t\t; " + st[0].Value + " -> stack\n"
+	} else if st[0].Value == "stack" {
+		// stack -> something (pop)
+		return "\tpop " + st[2].Value + "\t\t\t\t; stack -> " + st[2].Value + "\n"
+	} else if (st[0].T == REGISTER) && (st[2].T == REGISTER) {
+		// reg -> reg (push and then pop)
+		return "\tpush " + st[0].Value + "\t\t\t; " + st[0].Value + " -> " + st[2].Value + "\n\tpop " + st[2].Value + "\t\t\t\t;\n"
+	}
+	fatal("Error: Unrecognized stack expression: " + tokensString(st))
+	return ""
+}
+
+// emitAddition adds to a register, with inc if the value is 1 and the carry flag is not used afterwards
+func emitAddition(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (st[2].T == VALUE) && (st[2].Value == "1") && !ps.carryFollows {
+		return "\tinc " + st[0].Value + "\t\t\t; " + st[0].Value + "++"
+	}
+	return "\tadd " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " += " + st[2].Value
+}
+
+// emitSubtraction subtracts from a register, with dec if the value is 1 and the carry flag is not used afterwards
+func emitSubtraction(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (st[2].T == VALUE) && (st[2].Value == "1") && !ps.carryFollows {
+		return "\tdec " + st[0].Value + "\t\t\t; " + st[0].Value + "--"
+	}
+	return "\tsub " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " -= " + st[2].Value
+}
+
+// emitMultiplication multiplies a register, with shl if the value is a power of two
+func emitMultiplication(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if st[2].T == VALUE {
+		if n, ok := powerOfTwo(st[2].Value); ok {
+			// TODO: Check that it works with signed numbers and/or introduce signed/unsigned operations
+			return "\tshl " + st[0].Value + ", " + strconv.Itoa(n) + "\t\t\t; " + st[0].Value + " *= " + st[2].Value
+		}
+	}
+	if registerA(st[0].Value) {
+		return "\tmul " + st[2].Value + "\t\t\t; " + st[0].Value + " *= " + st[2].Value
+	}
+	if st[0].Value == st[2].Value {
+		return "\timul " + st[0].Value + "\t\t\t; " + st[0].Value + " *= " + st[0].Value
+	}
+	return "\timul " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " *= " + st[2].Value
+}
+
+// emitSignedMultiplication multiplies a register with a signed value
+func emitSignedMultiplication(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return config.signedMultiplication(st[0].Value, st[2])
+}
+
+// emitDivision divides a register, with shr if the value is a power of two
+func emitDivision(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if n, ok := powerOfTwo(st[2].Value); ok && (st[2].T == VALUE) {
+		return "\tshr " + st[0].Value + ", " + strconv.Itoa(n) + "\t\t; " + st[0].Value + " /= " + st[2].Value
+	}
+	return config.division(st[0].Value, st[0], st[2], false, false)
+}
+
+// emitModulo places the remainder of dividing a register in the register, with and if the value is a power of two
+func emitModulo(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if n, ok := powerOfTwo(st[2].Value); ok && (st[2].T == VALUE) {
+		return "\tand " + st[0].Value + ", " + strconv.Itoa((1<<uint(n))-1) + "\t\t\t; " + st[0].Value + " %= " + st[2].Value
+	}
+	return config.division(st[0].Value, st[0], st[2], false, true)
+}
+
+// emitSignedDivision divides a register by a signed value
+func emitSignedDivision(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return config.division(st[0].Value, st[0], st[2], true, false)
+}
+
+// emitSignedModulo places the remainder of dividing a register by a signed value in the register
+func emitSignedModulo(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return config.division(st[0].Value, st[0], st[2], true, true)
+}
+
+// instruction returns an emitter for operators that are a single instruction, like "a &= b" for "and a, b".
+// The comment is placed after the register and before the operand.
+func instruction(name, comment string) emitter {
+	return func(config *TargetConfig, ps *ProgramState, st Statement) string {
+		return "\t" + name + " " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + fmt.Sprintf(comment, st[0].Value, st[2].Value)
+	}
+}
+
+// emitIn reads from an IO port
+func emitIn(config *TargetConfig, ps *ProgramState, st Statement) string {
+	return "\tin " + st[2].Value + ", " + st[0].Value + "\t\t\t; input " + st[2].Value + " from IO port " + st[0].Value
+}
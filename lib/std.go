@@ -0,0 +1,211 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The functions in the std module that are the same for all platforms, except for the register sizes.
+// The arguments are given in registers and the result, if any, is returned in the a register.
+const stdCommon = `
+// memset fills c bytes at di with the value in al
+fun memset
+    asm {bits} cld
+    asm {bits} rep stosb
+    ret
+
+// memcpy copies c bytes from si to di
+fun memcpy
+    asm {bits} cld
+    asm {bits} rep movsb
+    ret
+
+// strlen returns the length of the zero terminated string at di in a
+fun strlen
+    asm {bits} cld
+    asm {bits} xor {a}, {a}
+    asm {bits} mov {c}, -1
+    asm {bits} repne scasb
+    asm {bits} not {c}
+    asm {bits} dec {c}
+    asm {bits} mov {a}, {c}
+    ret
+
+// itoa writes the decimal digits of the unsigned number in a to di, and returns the number of digits in c
+fun itoa
+    asm {bits} mov {b}, 10
+    asm {bits} xor {c}, {c}
+    asm {bits} itoa_divide:
+    asm {bits} xor {d}, {d}
+    asm {bits} div {b}
+    asm {bits} push {d}
+    asm {bits} inc {c}
+    asm {bits} test {a}, {a}
+    asm {bits} jnz itoa_divide
+    asm {bits} mov {d}, {c}
+    asm {bits} cld
+    asm {bits} itoa_write:
+    asm {bits} pop {a}
+    asm {bits} add al, 48
+    asm {bits} stosb
+    asm {bits} loop itoa_write
+    asm {bits} mov {c}, {d}
+    ret
+
+// atoi returns the number in a, given the decimal digits at si
+fun atoi
+    asm {bits} xor {a}, {a}
+    asm {bits} atoi_next:
+    asm {bits} xor {c}, {c}
+    asm {bits} mov cl, [{si}+0]
+    asm {bits} sub cl, 48
+    asm {bits} cmp cl, 9
+    asm {bits} ja atoi_done
+    asm {bits} imul {a}, {a}, 10
+    asm {bits} add {a}, {c}
+    asm {bits} inc {si}
+    asm {bits} jmp atoi_next
+    asm {bits} atoi_done:
+    ret
+
+var random_seed {bytes}
+
+// random returns a pseudo-random number from 0 to 32767 in a
+fun random
+    a = mem random_seed
+    asm {bits} imul {a}, {a}, 25173
+    asm {bits} add {a}, 13849
+    mem random_seed = a
+    asm {bits} shr {a}, 1
+    asm {bits} and {a}, 32767
+    ret
+`
+
+// The functions in the std module that are implemented with Linux system calls, on 64-bit x86
+const stdLinux64 = `
+// sleep waits for the number of seconds given in a
+fun sleep
+    asm 64 push 0
+    asm 64 push rax
+    asm 64 mov rdi, rsp
+    asm 64 xor esi, esi
+    asm 64 mov eax, 35
+    asm 64 syscall
+    asm 64 add rsp, 16
+    ret
+
+// time returns the number of seconds since 1970 in a
+fun time
+    asm 64 xor edi, edi
+    asm 64 mov eax, 201
+    asm 64 syscall
+    ret
+
+// quit exits the program with the exit code given in a
+fun quit
+    asm 64 mov rdi, rax
+    asm 64 mov eax, 60
+    asm 64 syscall
+    ret
+`
+
+// The functions in the std module that are implemented with Linux interrupts, on 32-bit x86
+const stdLinux32 = `
+// sleep waits for the number of seconds given in a
+fun sleep
+    asm 32 push 0
+    asm 32 push eax
+    asm 32 mov ebx, esp
+    asm 32 xor ecx, ecx
+    asm 32 mov eax, 162
+    asm 32 int 0x80
+    asm 32 add esp, 8
+    ret
+
+// time returns the number of seconds since 1970 in a
+fun time
+    asm 32 xor ebx, ebx
+    asm 32 mov eax, 13
+    asm 32 int 0x80
+    ret
+
+// quit exits the program with the exit code given in a
+fun quit
+    asm 32 mov ebx, eax
+    asm 32 mov eax, 1
+    asm 32 int 0x80
+    ret
+`
+
+// The functions in the std module that are implemented with DOS interrupts, on 16-bit x86
+const stdDOS = `
+// sleep waits for the number of seconds given in a
+fun sleep
+    asm 16 mov si, ax
+    asm 16 test si, si
+    asm 16 jz sleep_done
+    asm 16 mov ah, 0x2c
+    asm 16 int 0x21
+    asm 16 mov bl, dh
+    asm 16 sleep_wait:
+    asm 16 mov ah, 0x2c
+    asm 16 int 0x21
+    asm 16 cmp dh, bl
+    asm 16 je sleep_wait
+    asm 16 mov bl, dh
+    asm 16 dec si
+    asm 16 jnz sleep_wait
+    asm 16 sleep_done:
+    ret
+
+// time returns the number of seconds since the start of the hour in a
+fun time
+    asm 16 mov ah, 0x2c
+    asm 16 int 0x21
+    asm 16 mov al, cl
+    asm 16 mov bl, 60
+    asm 16 mul bl
+    asm 16 mov dl, dh
+    asm 16 xor dh, dh
+    asm 16 add ax, dx
+    ret
+
+// quit exits the program with the exit code given in al
+fun quit
+    asm 16 mov ah, 0x4c
+    asm 16 int 0x21
+    ret
+`
+
+// bundledModule returns the Battlestar source code for one of the modules
+// that comes with Battlestar, for the current platform, if there is one
+// by the given name. Currently, the only bundled module is "std".
+func (config *TargetConfig) bundledModule(name string) (string, bool) {
+	if name != "std" {
+		return "", false
+	}
+	var platform string
+	switch config.PlatformBits {
+	case 16:
+		platform = stdDOS
+	case 32:
+		platform = stdLinux32
+	case 64:
+		platform = stdLinux64
+	default:
+		return "", false
+	}
+	// The register prefix for the current platform, as in ax, eax or rax
+	prefix := map[int]string{16: "", 32: "e", 64: "r"}[config.PlatformBits]
+	r := strings.NewReplacer(
+		"{bits}", strconv.Itoa(config.PlatformBits),
+		"{bytes}", strconv.Itoa(config.PlatformBits/8),
+		"{a}", prefix+"ax",
+		"{b}", prefix+"bx",
+		"{c}", prefix+"cx",
+		"{d}", prefix+"dx",
+		"{di}", prefix+"di",
+		"{si}", prefix+"si",
+	)
+	return r.Replace(stdCommon) + platform, true
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInterpretStd(t *testing.T) {
+	defer quiet()()
+	tests := []struct {
+		bits   int
+		source string
+	}{
+		{32, `use std
+const nl = "\n"
+var digits 16
+
+fun main
+    eax = 1234
+    edi = digits
+    itoa
+    edx = ecx
+    int(0x80, 4, 1, digits, _)
+    print(nl)
+    esi = digits
+    atoi
+    eax -= 1200
+    quit
+end
+`},
+		{64, `use std
+const nl = "\n"
+var digits 16
+
+fun main
+    rax = 1234
+    rdi = digits
+    itoa
+    rdx = rcx
+    syscall(1, 1, digits, _)
+    print(nl)
+    rsi = digits
+    atoi
+    rax -= 1200
+    quit
+end
+`},
+	}
+	for _, test := range tests {
+		var stdout bytes.Buffer
+		exitCode, err := InterpretProgram(test.source, "std.bts", test.bits, nil, nil, &stdout, nil)
+		if err != nil {
+			t.Errorf("%d-bit: %v", test.bits, err)
+			continue
+		}
+		if stdout.String() != "1234\n" {
+			t.Errorf("%d-bit: expected \"1234\\n\", got %q", test.bits, stdout.String())
+		}
+		// quit exits with the number that atoi read back, minus 1200
+		if exitCode != 34 {
+			t.Errorf("%d-bit: expected exit code 34, got %d", test.bits, exitCode)
+		}
+	}
+}
+
+func TestCompileStd16(t *testing.T) {
+	defer quiet()()
+	source := "use std\nvar digits 8\n\nfun main\n    ax = 1234\n    di = digits\n    itoa\n    ax = cx\n    quit\nend\n"
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, _, _, _, err := config.Compile(source, "std.bts", nil, false, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"itoa:", "quit:", "div bx", "mov ah, 0x4c", "int 0x21"} {
+		if !strings.Contains(asmcode, expected) {
+			t.Errorf("expected %q in the assembly:\n%s", expected, asmcode)
+		}
+	}
+	// Only the functions that are used end up in the program
+	for _, unexpected := range []string{"atoi:", "sleep:", "random_seed"} {
+		if strings.Contains(asmcode, unexpected) {
+			t.Errorf("expected no %q in the assembly:\n%s", unexpected, asmcode)
+		}
+	}
+}
+
+func TestStdNotAvailable(t *testing.T) {
+	defer quiet()()
+	source := "\nuse std\n\nfun main\n    quit\nend\n"
+	for _, bits := range []int{32, 64} {
+		config, err := NewTargetConfig(bits, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, _, _, err = config.Compile(source, "std.bts", nil, false, NewProgramState())
+		compileError, ok := err.(*CompileError)
+		if !ok || (compileError.Message != "The std module is only available for Linux and DOS") {
+			t.Errorf("%d-bit macOS: expected an error for the std module, got %v", bits, err)
+			continue
+		}
+		if (compileError.Filename != "std.bts") || (compileError.Line != 2) {
+			t.Errorf("%d-bit macOS: expected the error at std.bts, line 2, got %s, line %d", bits, compileError.Filename, compileError.Line)
+		}
+	}
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.BootSector = true
+	if _, _, _, _, err := config.Compile(source, "boot.bts", nil, false, NewProgramState()); err == nil {
+		t.Error("expected an error for the std module in a boot sector")
+	}
+}
@@ -0,0 +1,433 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+type (
+	// structType is a struct that is declared with "struct", like: struct Point x u16, y u16 end
+	structType struct {
+		name   string
+		fields []structField
+		size   int // the size of all the fields, in bytes
+	}
+
+	// structField is a field in a struct, at the given offset from the start of the struct
+	structField struct {
+		name   string
+		typ    string
+		offset int
+	}
+
+	// typedVariable is a variable that is declared with a type, like: var pts [100]Point
+	typedVariable struct {
+		typ   string
+		count int // the number of elements, or 0 if it is not an array
+	}
+
+	// element is the memory operand for an element of an array or a field of a struct, like pts[rsi].y
+	element struct {
+		address string // the memory operand, like [pts+rsi*4+2]
+		bits    int    // the size of the element or field
+		signed  bool   // if the type is one of the signed types, like i16
+		float   bool   // if the type is f64
+		before  string // code that calculates the address in a register, if needed
+		after   string // code that restores the register that was used for the address
+		scratch string // the register that is used for calculating the address, if any
+	}
+)
+
+// baseTypes maps the types that fit in a register to their size in bytes
+var baseTypes = map[string]int{"u8": 1, "u16": 2, "u32": 4, "u64": 8, "i8": 1, "i16": 2, "i32": 4, "i64": 8, "f64": 8}
+
+// typeSize returns the size of a base type or a struct, in bytes
+func (ps *ProgramState) typeSize(typ string) (int, bool) {
+	if size, ok := baseTypes[typ]; ok {
+		return size, true
+	}
+	if s, ok := ps.structs[typ]; ok {
+		return s.size, true
+	}
+	return 0, false
+}
+
+// fieldType follows the given field names from the given type, like "y" from "Point".
+// Returns the type of the last field and the offset from the start of the given type.
+func (ps *ProgramState) fieldType(typ string, fields []string, expression string) (string, int) {
+	offset := 0
+	for _, name := range fields {
+		s, ok := ps.structs[typ]
+		if !ok {
+			fatal("Error:", typ, "is not a struct and has no field named", name, "in:", expression)
+		}
+		found := false
+		for _, field := range s.fields {
+			if field.name == name {
+				offset += field.offset
+				typ = field.typ
+				found = true
+				break
+			}
+		}
+		if !found {
+			fatal("Error: The struct", s.name, "has no field named", name, "in:", expression)
+		}
+	}
+	return typ, offset
+}
+
+// parseElement splits an expression like pts[rsi].y into the name, the index and the field names.
+// The index is empty if there is none.
+func parseElement(word string) (string, string, []string, bool) {
+	name, index, rest := word, "", ""
+	if pos := strings.Index(word, "["); pos != -1 {
+		end := strings.Index(word, "]")
+		if end < pos+2 {
+			return "", "", nil, false
+		}
+		name, index, rest = word[:pos], word[pos+1:end], word[end+1:]
+		if !validName(index) && !isValue(index) {
+			return "", "", nil, false
+		}
+	} else if pos := strings.Index(word, "."); pos != -1 {
+		name, rest = word[:pos], word[pos:]
+	} else {
+		return "", "", nil, false
+	}
+	if !validName(name) || has(reserved, name) || has(registers, name) {
+		return "", "", nil, false
+	}
+	var fields []string
+	if rest != "" {
+		if !strings.HasPrefix(rest, ".") {
+			return "", "", nil, false
+		}
+		fields = strings.Split(rest[1:], ".")
+		for _, field := range fields {
+			if !validName(field) {
+				return "", "", nil, false
+			}
+		}
+	}
+	return name, index, fields, true
+}
+
+// elementExpression checks if the given word is an element of an array or a field of a struct, like pts[rsi].y
+func elementExpression(word string) bool {
+	_, _, _, ok := parseElement(word)
+	return ok
+}
+
+// emitStruct starts the declaration of a struct, which may also have the fields and "end" on the same line
+func emitStruct(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inStruct != nil {
+		fatal("Error: Structs can not be declared within the struct", ps.inStruct.name)
+	}
+	name := st[1].Value
+	if has(ps.definedNames, name) {
+		fatal("Error: Can not declare struct, name is already defined: " + name)
+	}
+	if _, ok := ps.typeSize(name); ok {
+		fatal("Error: Can not declare struct, name is a built-in type: " + name)
+	}
+	ps.definedNames = append(ps.definedNames, name)
+	ps.inStruct = &structType{name: name}
+	fields := st[2:]
+	if len(fields) == 0 {
+		return ""
+	}
+	if (fields[len(fields)-1].T != KEYWORD) || (fields[len(fields)-1].Value != "end") || (len(fields)%2 != 1) {
+		fatal("Error: Structs are declared like \"struct Point x u16, y u16 end\", not:", tokensString(st))
+	}
+	for i := 0; i < len(fields)-1; i += 2 {
+		ps.addField(fields[i], fields[i+1])
+	}
+	return config.endStruct(ps)
+}
+
+// emitField adds a field to the struct that is being declared, like: x u16
+func emitField(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if ps.inStruct == nil {
+		fatal("Error: Fields can only be declared within a struct, not:", tokensString(st))
+	}
+	ps.addField(st[0], st[1])
+	return ""
+}
+
+// addField adds a field with the given name and type to the struct that is being declared
+func (ps *ProgramState) addField(name, typ Token) {
+	s := ps.inStruct
+	if (name.T != VALIDNAME) || (typ.T != VALIDNAME) {
+		fatal("Error: Fields in the struct", s.name, "are declared like \"x u16\", not:", name.Value, typ.Value)
+	}
+	size, ok := ps.typeSize(typ.Value)
+	if !ok {
+		fatal("Error: Unknown type", typ.Value, "for the field", name.Value, "in the struct", s.name)
+	}
+	for _, field := range s.fields {
+		if field.name == name.Value {
+			fatal("Error: The struct", s.name, "already has a field named", name.Value)
+		}
+	}
+	s.fields = append(s.fields, structField{name.Value, typ.Value, s.size})
+	s.size += size
+}
+
+// endStruct ends the declaration of a struct, with constants for the offsets and the size
+func (config *TargetConfig) endStruct(ps *ProgramState) string {
+	s := ps.inStruct
+	if len(s.fields) == 0 {
+		fatal("Error: The struct", s.name, "has no fields")
+	}
+	ps.structs[s.name] = s
+	ps.inStruct = nil
+	asmcode := "\t;--- struct " + s.name + " ---\n"
+	for _, field := range s.fields {
+		asmcode += s.name + "." + field.name + " equ " + strconv.Itoa(field.offset) + "\t\t\t; " + field.typ + "\n"
+	}
+	asmcode += "_size_of_" + s.name + " equ " + strconv.Itoa(s.size) + "\t\t; size of struct " + s.name + "\n"
+	return asmcode
+}
+
+// emitTypedVariable reserves memory for a variable of the given type, or for an array, like: var pts [100]Point
+func emitTypedVariable(config *TargetConfig, ps *ProgramState, st Statement) string {
+	name, typ, count := st[1].Value, st[len(st)-1].Value, 0
+	if len(st) == 4 {
+		var err error
+		if count, err = strconv.Atoi(st[2].Value); (err != nil) || (count < 1) {
+			fatal("Error: " + st[2].Value + " is not a valid number of elements for " + name)
+		}
+	}
+	if has(ps.definedNames, name) {
+		fatal("Error: Can not declare variable, name is already defined: " + name)
+	}
+	size, ok := ps.typeSize(typ)
+	if !ok {
+		fatal("Error: Unknown type", typ, "for the variable", name)
+	}
+	ps.definedNames = append(ps.definedNames, name)
+	ps.typedVariables[name] = &typedVariable{typ, count}
+	if count == 0 {
+		bsscode := name + ": resb " + strconv.Itoa(size) + "\t\t\t\t; reserve a " + typ + " as " + name + "\n"
+		bsscode += "_size_of_" + name + " equ " + strconv.Itoa(size) + "\t\t; size of " + name + "\n"
+		return bsscode
+	}
+	bsscode := name + ": resb " + strconv.Itoa(count*size) + "\t\t\t\t; reserve " + st[2].Value + " " + typ + " as " + name + "\n"
+	bsscode += "_length_of_" + name + " equ " + st[2].Value + "\t\t; number of elements\n"
+	bsscode += "_size_of_" + name + " equ " + strconv.Itoa(count*size) + "\t\t; size of " + name + "\n"
+	return bsscode
+}
+
+// sizeOf returns the size in bytes of a type, a struct, a typed variable or an element, for sizeof()
+func (ps *ProgramState) sizeOf(t Token) int {
+	if t.T == ELEMENT {
+		size, _ := ps.typeSize(ps.elementType(t.Value))
+		return size
+	}
+	if size, ok := ps.typeSize(t.Value); ok {
+		return size
+	}
+	if v, ok := ps.typedVariables[t.Value]; ok {
+		size, _ := ps.typeSize(v.typ)
+		if v.count > 0 {
+			return size * v.count
+		}
+		return size
+	}
+	if length, ok := ps.variables[t.Value]; ok {
+		return length
+	}
+	fatal("Error: Can not find the size of", t.Value)
+	return 0
+}
+
+// resolveElement finds the memory operand for an element of an array or a field of a struct, like pts[rsi].y.
+// If the address needs to be calculated in a register, a register from another family than the given ones is used.
+func (config *TargetConfig) resolveElement(ps *ProgramState, expression, avoid string) *element {
+	e, typ := config.elementAddress(ps, expression, avoid)
+	size, ok := baseTypes[typ]
+	if !ok {
+		fatal("Error: Only one field can be used at a time, and", typ, "is a struct, in:", expression)
+	}
+	if (typ != "f64") && (size*8 > config.PlatformBits) {
+		fatal("Error:", expression, "is too large for the registers on a", config.PlatformBits, "bit platform")
+	}
+	e.bits, e.signed, e.float = size*8, strings.HasPrefix(typ, "i"), typ == "f64"
+	return e
+}
+
+// elementType returns the type of an element of an array or a field of a struct, or an empty string
+// if the given expression does not start with a variable that is declared with a type
+func (ps *ProgramState) elementType(expression string) string {
+	name, _, fields, _ := parseElement(expression)
+	v, ok := ps.typedVariables[name]
+	if !ok {
+		return ""
+	}
+	typ, _ := ps.fieldType(v.typ, fields, expression)
+	return typ
+}
+
+// elementAddress finds the memory operand and the type for an element of an array or a field of a struct,
+// which may also be a struct. The avoid string is a space separated list of register families.
+func (config *TargetConfig) elementAddress(ps *ProgramState, expression, avoid string) (*element, string) {
+	name, index, fields, _ := parseElement(expression)
+	v, ok := ps.typedVariables[name]
+	if !ok {
+		fatal("Error:", name, "is not a variable that is declared with a type, in:", expression)
+	}
+	if (index != "") && (v.count == 0) {
+		fatal("Error:", name, "is not an array, in:", expression)
+	} else if (index == "") && (v.count > 0) {
+		fatal("Error:", name, "is an array and needs an index, like "+name+"[0], in:", expression)
+	}
+	elementSize, _ := ps.typeSize(v.typ)
+	typ, offset := ps.fieldType(v.typ, fields, expression)
+	e := &element{}
+	address := name
+	switch {
+	case index == "":
+	case isValue(index):
+		i, err := strconv.ParseInt(index, 0, 64)
+		if (err != nil) || (i < 0) || (i >= int64(v.count)) {
+			fatal("Error: The index is out of range for the", v.count, "elements in:", expression)
+		}
+		offset += int(i) * elementSize
+	case registerBits(index) != config.PlatformBits:
+		fatal("Error: The index must be a number or a", config.PlatformBits, "bit register, in:", expression)
+	case (config.PlatformBits > 16) && (elementSize == 1):
+		address += "+" + index
+	case (config.PlatformBits > 16) && has([]string{"2", "4", "8"}, strconv.Itoa(elementSize)):
+		address += "+" + index + "*" + strconv.Itoa(elementSize)
+	case (config.PlatformBits == 16) && (elementSize == 1) && has([]string{"bx", "si", "di"}, index):
+		address += "+" + index
+	default:
+		// The element size can not be used for scaling, or the index register can not be
+		// used in 16-bit addresses, so calculate the offset in another register
+		for _, family := range []string{"bx", "si", "di"} {
+			if (family != regFamily(index)) && !has(strings.Fields(avoid), family) {
+				e.scratch = config.nativeRegister(family)
+				break
+			}
+		}
+		if e.scratch == "" {
+			fatal("Error: There are no registers left for calculating the address of", expression)
+		}
+		scratch := e.scratch
+		e.before = "\tpush " + scratch + "\t\t\t\t; save " + scratch + "\n"
+		e.before += "\tmov " + scratch + ", " + index + "\t\t\t; the index in " + name + "\n"
+		if n, ok := powerOfTwo(strconv.Itoa(elementSize)); ok {
+			e.before += "\tshl " + scratch + ", " + strconv.Itoa(n) + "\t\t\t; multiply with the size of " + v.typ + "\n"
+		} else if elementSize > 1 {
+			e.before += "\timul " + scratch + ", " + scratch + ", " + strconv.Itoa(elementSize) + "\t\t; multiply with the size of " + v.typ + "\n"
+		}
+		e.after = "\tpop " + scratch + "\t\t\t\t; restore " + scratch + "\n"
+		address += "+" + scratch
+	}
+	if offset > 0 {
+		address += "+" + strconv.Itoa(offset)
+	}
+	e.address = "[" + address + "]"
+	return e, typ
+}
+
+// elementOperand returns a register with the same size as the element, for storing it,
+// or fails if the given register is too small
+func elementOperand(e *element, reg, expression string) string {
+	bits := registerBits(reg)
+	if bits == 0 {
+		fatal("Error:", reg, "can not be used with", expression)
+	}
+	if bits < e.bits {
+		fatal("Error:", expression, "is", e.bits, "bits and does not fit in", reg)
+	}
+	if bits == e.bits {
+		return reg
+	}
+	return sizedRegister(regFamily(reg), e.bits)
+}
+
+// emitStoreElement assigns a register or a value to an element or a field, like: pts[rsi].y = ax
+func emitStoreElement(config *TargetConfig, ps *ProgramState, st Statement) string {
+	if (st[2].T == REGISTER) && config.resolveElement(ps, st[0].Value, "").float {
+		return emitIntegerToFloat(config, ps, st)
+	}
+	return config.elementOperation(ps, "mov", st[0], st[2], tokensString(st))
+}
+
+// emitElementArithmetic changes an element or a field in memory, like: pts[rsi].y += 2
+func emitElementArithmetic(config *TargetConfig, ps *ProgramState, st Statement) string {
+	op := map[TokenType]string{ADDITION: "add", SUBTRACTION: "sub", AND: "and", OR: "or", XOR: "xor"}[st[1].T]
+	return config.elementOperation(ps, op, st[0], st[2], tokensString(st))
+}
+
+// elementOperation returns code for an instruction that has an element or a field as the destination
+func (config *TargetConfig) elementOperation(ps *ProgramState, op string, dest, src Token, comment string) string {
+	avoid := ""
+	if src.T == REGISTER {
+		avoid = regFamily(src.Value)
+	}
+	e := config.resolveElement(ps, dest.Value, avoid)
+	if e.float {
+		fatal("Error:", dest.Value, "is an f64 value and can only be used with xmm registers and other f64 values")
+	}
+	destination, source := sizeQualifier(e.bits)+" "+e.address, src.Value
+	if src.T == REGISTER {
+		destination, source = e.address, elementOperand(e, src.Value, dest.Value)
+	}
+	asmcode := e.before
+	asmcode += "\t" + op + " " + destination + ", " + source + "\t\t; " + comment + "\n"
+	return asmcode + e.after
+}
+
+// emitLoadElement assigns an element or a field to a register, like: ax = pts[rsi].y
+func emitLoadElement(config *TargetConfig, ps *ProgramState, st Statement) string {
+	dest, expression := st[0].Value, st[2].Value
+	e := config.resolveElement(ps, expression, regFamily(dest))
+	if e.float {
+		return emitFloatToInteger(config, ps, st)
+	}
+	bits := registerBits(dest)
+	if bits == 0 {
+		fatal("Error:", dest, "can not be used with", expression)
+	}
+	source := sizeQualifier(e.bits) + " " + e.address
+	comment := "\t\t; " + tokensString(st) + "\n"
+	asmcode := e.before
+	switch {
+	case bits < e.bits:
+		fatal("Error:", expression, "is", e.bits, "bits and does not fit in", dest)
+	case bits == e.bits:
+		asmcode += "\tmov " + dest + ", " + source + comment
+	case e.signed && (e.bits == 32):
+		asmcode += "\tmovsxd " + dest + ", " + source + comment
+	case e.signed:
+		asmcode += "\tmovsx " + dest + ", " + source + comment
+	case e.bits == 32:
+		// Writing to a 32-bit register clears the upper half of the 64-bit register
+		asmcode += "\tmov " + sizedRegister(regFamily(dest), 32) + ", " + source + comment
+	default:
+		asmcode += "\tmovzx " + dest + ", " + source + comment
+	}
+	return asmcode + e.after
+}
+
+// compare returns code for comparing two operands, where either of them may be an element or a field
+func (config *TargetConfig) compare(ps *ProgramState, a, b Token, comment string) string {
+	switch {
+	case config.isFloatOperand(ps, a) || config.isFloatOperand(ps, b):
+		return config.compareFloats(ps, a, b, comment)
+	case (a.T == ELEMENT) && (b.T == REGISTER) && (registerBits(b.Value) != config.resolveElement(ps, a.Value, "").bits):
+		fatal("Error:", a.Value, "and", b.Value, "must have the same size to be compared")
+	case a.T == ELEMENT:
+		return config.elementOperation(ps, "cmp", a, b, comment)
+	case b.T == ELEMENT:
+		e := config.resolveElement(ps, b.Value, regFamily(a.Value))
+		if registerBits(a.Value) != e.bits {
+			fatal("Error:", a.Value, "and", b.Value, "must have the same size to be compared")
+		}
+		return e.before + "\tcmp " + a.Value + ", " + e.address + "\t\t\t; " + comment + "\n" + e.after
+	}
+	return "\tcmp " + a.Value + ", " + b.Value + "\t\t\t; " + comment + "\n"
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestParseElement(t *testing.T) {
+	name, index, fields, ok := parseElement("pts[rsi].pos.y")
+	if !ok || name != "pts" || index != "rsi" || len(fields) != 2 || fields[1] != "y" {
+		t.Errorf("Could not parse pts[rsi].pos.y\n")
+	}
+	for _, word := range []string{"sysparam[1]", "rax.x", "pts[]", "pts[1]x", "pts.", "[di+321]"} {
+		if elementExpression(word) {
+			t.Errorf("%s should not be an element expression\n", word)
+		}
+	}
+}
+
+func TestResolveElement(t *testing.T) {
+	config, err := NewTargetConfig(32, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := NewProgramState()
+	ps.structs["Point"] = &structType{"Point", []structField{{"x", "u16", 0}, {"y", "u16", 2}}, 4}
+	ps.structs["Particle"] = &structType{"Particle", []structField{{"pos", "Point", 0}, {"mass", "u16", 4}}, 6}
+	ps.typedVariables["pts"] = &typedVariable{"Point", 10}
+	ps.typedVariables["ps"] = &typedVariable{"Particle", 10}
+	if e := config.resolveElement(ps, "pts[ecx].y", ""); e.address != "[pts+ecx*4+2]" || e.before != "" || e.bits != 16 {
+		t.Errorf("Wrong address for pts[ecx].y: %s\n", e.address)
+	}
+	if e := config.resolveElement(ps, "pts[3].x", ""); e.address != "[pts+12]" {
+		t.Errorf("Wrong address for pts[3].x: %s\n", e.address)
+	}
+	// 6 can not be used for scaling, so the offset is calculated in a register
+	if e := config.resolveElement(ps, "ps[ebx].pos.y", ""); e.address != "[ps+esi+2]" || e.before == "" || e.after == "" {
+		t.Errorf("Wrong address for ps[ebx].pos.y: %s\n", e.address)
+	}
+}
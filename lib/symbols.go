@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Symbol is a name that is defined in Battlestar source code, like a function or a constant
+type Symbol struct {
+	Name   string
+	Kind   string // "fun", "const", "var", "macro" or "struct"
+	Line   int    // the line of the definition, counting from 0
+	Column int    // where the name starts on that line, counting from 0
+}
+
+// Symbols returns the functions, constants, variables, macros and structs that are defined in the given
+// source code, in the order they are defined. Inline C and the other inline blocks are skipped.
+func Symbols(source string) []Symbol {
+	var (
+		symbols []Symbol
+		lines   = strings.Split(source, "\n")
+		inline  = inlineLines(lines)
+	)
+	for i, line := range lines {
+		words := strings.Fields(removecomments(strings.TrimSpace(line)))
+		if inline[i] || (len(words) < 2) || !has([]string{"fun", "const", "var", "macro", "struct"}, words[0]) {
+			continue
+		}
+		name := words[1]
+		if words[0] == "macro" {
+			// Like "macro putpixel(pos, color)"
+			name = strings.TrimSpace(strings.SplitN(strings.TrimSpace(strings.TrimSpace(line)[len("macro"):]), "(", 2)[0])
+		}
+		if !validName(name) {
+			continue
+		}
+		column := strings.Index(line, words[0]) + len(words[0])
+		column += strings.Index(line[column:], name)
+		symbols = append(symbols, Symbol{name, words[0], i, column})
+	}
+	return symbols
+}
+
+// LanguageWords returns the keywords, built-in functions, registers and reserved names of the language
+func LanguageWords() map[string][]string {
+	allRegisters := append([]string{}, registers...)
+	for _, reg := range floatRegisters {
+		// xmm8 to xmm15 are already among the 64-bit registers
+		if !has(allRegisters, reg) {
+			allRegisters = append(allRegisters, reg)
+		}
+	}
+	return map[string][]string{
+		"keywords":  keywords,
+		"builtins":  builtins,
+		"registers": allRegisters,
+		"reserved":  reserved,
+	}
+}
+
+// AssemblyByLine splits assembly code that was generated with line markers into the code for each source line,
+// counting from 0. The code after a marker for line 0 does not belong to any line.
+func AssemblyByLine(asmcode string) map[int]string {
+	byLine := make(map[int]string)
+	line := -1
+	for _, asmline := range strings.Split(asmcode, "\n") {
+		if strings.HasPrefix(asmline, lineMarker) {
+			if n, err := strconv.Atoi(asmline[len(lineMarker):]); err == nil {
+				line = n - 1
+				continue
+			}
+		}
+		if (line < 0) || (strings.TrimSpace(asmline) == "") {
+			continue
+		}
+		// The code up to the next marker belongs to this line, but not the sections that follow
+		if strings.HasPrefix(asmline, "section ") {
+			line = -1
+			continue
+		}
+		byLine[line] += asmline + "\n"
+	}
+	return byLine
+}
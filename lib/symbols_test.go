@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestSymbols(t *testing.T) {
+	source := "const hi = \"hi\", 10\nvar x u16\n\nmacro putpixel(pos, color)\nend\ninline_c\nconst int y = 2;\nend\nfun main\n    print(hi)\nend\n"
+	expected := []Symbol{{"hi", "const", 0, 6}, {"x", "var", 1, 4}, {"putpixel", "macro", 3, 6}, {"main", "fun", 8, 4}}
+	symbols := Symbols(source)
+	if len(symbols) != len(expected) {
+		t.Fatalf("Expected %v, got %v\n", expected, symbols)
+	}
+	for i, symbol := range symbols {
+		if symbol != expected[i] {
+			t.Errorf("Expected %v, got %v\n", expected[i], symbol)
+		}
+	}
+}
+
+func TestLanguageWords(t *testing.T) {
+	for kind, words := range LanguageWords() {
+		seen := make(map[string]bool)
+		for _, word := range words {
+			if seen[word] {
+				t.Errorf("%s is listed twice among the %s", word, kind)
+			}
+			seen[word] = true
+		}
+	}
+	if n := len(LanguageWords()["registers"]); n != len(registers)+8 {
+		t.Errorf("expected the registers and xmm0 to xmm7, got %d registers", n)
+	}
+}
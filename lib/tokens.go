@@ -2,6 +2,7 @@ package lib
 
 import (
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -38,6 +39,19 @@ const (
 	XCHG           = 28
 	OUT            = 29
 	IN             = 30
+	SIGNEDMUL      = 31  // signed multiplication
+	SIGNEDDIV      = 32  // signed division
+	SAR            = 33  // arithmetic (signed) shift right
+	SIGNEDASSIGN   = 34  // assignment with sign extension
+	MODULO         = 35  // remainder after unsigned division
+	SIGNEDMOD      = 36  // remainder after signed division
+	BINOP          = 37  // an operator on the right hand side of an assignment, like "%" in "a = b % c"
+	FLAG           = 38  // a CPU flag that is used as a condition, like "zero" or "carry"
+	ADDCARRY       = 39  // addition with carry
+	SUBBORROW      = 40  // subtraction with borrow
+	RANGE          = 41  // the ".." in a range, like 1..10
+	ELEMENT        = 42  // an element of an array or a field of a struct, like pts[rsi].y
+	FLOATREG       = 43  // an xmm register, for f64 values
 	SEP            = 127 // statement separator
 	UNKNOWN        = 255
 )
@@ -47,7 +61,7 @@ var (
 	tokenDebug     = false
 	newTokensDebug = true
 
-	tokenToString = TokenDescriptions{REGISTER: "register", ASSIGNMENT: "assignment", VALUE: "value", VALIDNAME: "name", SEP: ";", UNKNOWN: "?", KEYWORD: "keyword", STRING: "string", BUILTIN: "built-in", DISREGARD: "disregard", RESERVED: "reserved", VARIABLE: "variable", ADDITION: "addition", SUBTRACTION: "subtraction", MULTIPLICATION: "multiplication", DIVISION: "division", COMPARISON: "comparison", ARROW: "stack operation", MEMEXP: "address expression", ASMLABEL: "assembly label", AND: "and", XOR: "xor", OR: "or", ROL: "rol", ROR: "ror", CONCAT: "concatenation", SEGOFS: "segment+offset", SHL: "shl", SHR: "shr", QUAL: "qualifier", XCHG: "xchg", OUT: "out", IN: "in"}
+	tokenToString = TokenDescriptions{REGISTER: "register", ASSIGNMENT: "assignment", VALUE: "value", VALIDNAME: "name", SEP: ";", UNKNOWN: "?", KEYWORD: "keyword", STRING: "string", BUILTIN: "built-in", DISREGARD: "disregard", RESERVED: "reserved", VARIABLE: "variable", ADDITION: "addition", SUBTRACTION: "subtraction", MULTIPLICATION: "multiplication", DIVISION: "division", COMPARISON: "comparison", ARROW: "stack operation", MEMEXP: "address expression", ASMLABEL: "assembly label", AND: "and", XOR: "xor", OR: "or", ROL: "rol", ROR: "ror", CONCAT: "concatenation", SEGOFS: "segment+offset", SHL: "shl", SHR: "shr", QUAL: "qualifier", XCHG: "xchg", OUT: "out", IN: "in", SIGNEDMUL: "signed multiplication", SIGNEDDIV: "signed division", SAR: "sar", SIGNEDASSIGN: "signed assignment", MODULO: "modulo", SIGNEDMOD: "signed modulo", BINOP: "binary operator", FLAG: "flag", ADDCARRY: "addition with carry", SUBBORROW: "subtraction with borrow", RANGE: "range", ELEMENT: "element", FLOATREG: "float register"}
 	// see also the top of language.go, when adding tokens
 )
 
@@ -83,7 +97,7 @@ func (tok Token) String() string {
 	} else if haskey(tokenToString, tok.T) {
 		return tokenToString[tok.T] + ":" + tok.Value
 	}
-	log.Fatalln("Error: Unfamiliar token when representing as string: " + tok.Value)
+	fatal("Error: Unfamiliar token when representing as string: " + tok.Value)
 	return "!?"
 }
 
@@ -94,12 +108,26 @@ func (toktyp TokenType) String() string {
 	} else if haskey(tokenToString, toktyp) {
 		return tokenToString[toktyp]
 	}
-	log.Fatalln("Error when serializing: Unfamiliar token type when representing tokentype as string: ", int(toktyp))
+	fatal("Error when serializing: Unfamiliar token type when representing tokentype as string: ", int(toktyp))
 	return "!?"
 }
 
-// Split a string into more tokens and tokenize them
-func (config *TargetConfig) retokenize(word string, sep string) []Token {
+// simpleAddress checks if the word is an address with only a register, a value or a name within the brackets,
+// like "[bx]" or "[0x46c]," and returns what is within the brackets
+func simpleAddress(word string) (string, bool) {
+	word = strings.TrimSuffix(word, ",")
+	if !strings.HasPrefix(word, "[") || !strings.HasSuffix(word, "]") {
+		return "", false
+	}
+	addr := word[1 : len(word)-1]
+	if (addr == "") || !(validName(addr) || strings.Contains("0123456789", string(addr[0]))) {
+		return "", false
+	}
+	return addr, true
+}
+
+// Split a string into more tokens and tokenize them, as tokens on the given line
+func (config *TargetConfig) retokenize(word string, sep string, line uint) []Token {
 	var newtokens []Token
 	words := strings.Split(word, sep)
 	for _, s := range words {
@@ -107,6 +135,7 @@ func (config *TargetConfig) retokenize(word string, sep string) []Token {
 		//log.Println("RETOKEN", tokens)
 		for _, t := range tokens {
 			if t.T != SEP {
+				t.Line = line
 				newtokens = append(newtokens, t)
 			}
 		}
@@ -126,9 +155,27 @@ func lognewtokens(tokens []Token) {
 	}
 }
 
-// Tokenize a string
+// Tokenize a string, after expanding the macros in the same way as ExpandMacros.
+// Errors in the source code cause a panic with a *CompileError, which Compile returns as an error.
 func (config *TargetConfig) Tokenize(program, sep string) []Token {
-	statements := maps(maps(strings.Split(program, "\n"), strings.TrimSpace), removecomments)
+	lines, origins, err := newMacroExpander().expandLines(strings.Split(program, "\n"))
+	if err != nil {
+		fatal("Error:", err)
+	}
+	return config.tokenize(lines, origins, sep)
+}
+
+// tokenize the given lines, where the macros have been expanded.
+// origins are the source lines that the lines come from, which the tokens are marked with.
+func (config *TargetConfig) tokenize(lines []string, origins []int, sep string) []Token {
+	statements := maps(maps(lines, strings.TrimSpace), removecomments)
+	inline := inlineLines(lines) // which lines are inline C, inline assembly or another inline language
+	asmBlocks := make(map[int]InlineBlock)
+	for _, block := range InlineBlocks(lines) {
+		if block.Language == "asm" {
+			asmBlocks[block.Start] = block
+		}
+	}
 	tokens := make([]Token, 0)
 	var (
 		t           Token
@@ -136,57 +183,30 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 		constexpr   = false // Are we in a constant expression?
 		varexpr     = false // Are we in a variable expression?
 		collected   string  // Collected string, until end of line
-		inlineC     = false // Are we in parts of the code that are inline_c ... end ?
-		cBlock      = false // Are we in parts of the code that are void ... } ?
 		statementnr uint
 	)
 	for statementnrInt, statement := range statements {
 		// TODO: Use line number instead of statement number (but statement numbers are better than nothing)
-		statementnr = uint(statementnrInt)
+		statementnr = uint(origins[statementnrInt])
 		words := maps(strings.Split(statement, " "), strings.TrimSpace)
 
 		if len(words) == 0 {
 			continue
 		}
 
-		if words[0] == "void" {
-			if debug {
-				log.Println("Found void, starting C block")
+		if block, ok := asmBlocks[statementnrInt]; ok {
+			// A block of inline assembly, which is output as it is. The code is kept in the keyword token.
+			tokens = append(tokens, Token{KEYWORD, "inline_asm", statementnr, strings.Join(block.Code, "\n") + "\n"})
+			if block.Bits != "" {
+				tokens = append(tokens, Token{VALUE, block.Bits, statementnr, ""})
 			}
-			if (len(words) > 1) && (strings.HasPrefix(words[1], "main(")) {
-				log.Println("External main function detected.", words[1])
-				// Automatically added
-				//log.Println("Remember to add \"extern main\" at the top of the file!")
-			}
-			cBlock = true
-			// Skip the start of this type of inline C, don't include "void" as a token
+			tokens = append(tokens, Token{SEP, ";", statementnr, ""})
 			continue
-		} else if inlineC && (words[0] == "end") {
-			if debug {
-				log.Println("Found the end of inline C block")
-			}
-			// End both types of blocks when "end" is encountered
-			inlineC = false
-			cBlock = false
-			// Skip the end keyword of this type of inline C block, don't include "end" as a token
-			continue
-		} else if cBlock && (words[0] == "}") {
-			if debug {
-				log.Println("Found the } of void C block")
-			}
-			cBlock = false
-			// Skip the } keyword of this type of inline C block, don't include "}" as a token
-			continue
-		} else if words[0] == "inline_c" {
-			if debug {
-				log.Println("Found inline_c, starting inline C block")
+		} else if inline[statementnrInt] {
+			// In a block of inline C or another inline language, skip and don't include as tokens
+			if (len(words) > 1) && strings.HasPrefix(words[1], "main(") {
+				log.Println("External main function detected.", words[1])
 			}
-			inlineC = true
-			// Skip the start of this type of inline C, don't include "inline_c" as a token
-			continue
-		} else if inlineC || cBlock {
-			// In a block of inline code, skip and don't include as tokens
-			// log.Println("Skipping when tokenizing:", words)
 			continue
 		}
 		// If we are defining a constant, ease up on tokenizing the rest of the line recursively
@@ -204,6 +224,10 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 			// TODO: refactor out code that repeats the same thing
 			if instring {
 				collected += word + sep
+			} else if has(floatRegisters, word) {
+				t = Token{FLOATREG, word, statementnr, ""}
+				tokens = append(tokens, t)
+				logtoken(t)
 			} else if has(registers, word) {
 				t = Token{REGISTER, word, statementnr, "?"}
 				tokens = append(tokens, t)
@@ -225,6 +249,24 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 					tokentype = MULTIPLICATION
 				case "/=":
 					tokentype = DIVISION
+				case "*s=":
+					tokentype = SIGNEDMUL
+				case "/s=":
+					tokentype = SIGNEDDIV
+				case "=s":
+					tokentype = SIGNEDASSIGN
+				case "%=":
+					tokentype = MODULO
+				case "%s=":
+					tokentype = SIGNEDMOD
+				case "/", "%", "/s", "%s":
+					tokentype = BINOP
+				case "+c=":
+					tokentype = ADDCARRY
+				case "-c=":
+					tokentype = SUBBORROW
+				case "..":
+					tokentype = RANGE
 				case "&=":
 					tokentype = AND
 				case "|=":
@@ -243,12 +285,14 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 					tokentype = SHL
 				case ">>":
 					tokentype = SHR
+				case ">>s":
+					tokentype = SAR
 				case "->":
 					tokentype = ARROW
 				case "<->":
 					tokentype = XCHG
 				default:
-					log.Fatalln("Error: Unhandled operator:", word)
+					fatal("Error: Unhandled operator:", word)
 				}
 				t = Token{tokentype, word, statementnr, ""}
 				tokens = append(tokens, t)
@@ -257,6 +301,10 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 				t = Token{KEYWORD, word, statementnr, ""}
 				tokens = append(tokens, t)
 				logtoken(t)
+			} else if _, ok := flagJumps[word]; ok {
+				t = Token{FLAG, word, statementnr, ""}
+				tokens = append(tokens, t)
+				logtoken(t)
 			} else if has(builtins, word) {
 				t = Token{BUILTIN, word, statementnr, ""}
 				tokens = append(tokens, t)
@@ -277,7 +325,7 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 				}
 				tokens = append(tokens, t)
 				logtoken(t)
-			} else if isValue(word) {
+			} else if isValue(word) || isFloat(word) {
 				t = Token{VALUE, word, statementnr, ""}
 				tokens = append(tokens, t)
 				logtoken(t)
@@ -287,12 +335,12 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 				logtoken(t)
 			} else if strings.HasSuffix(word, "++") {
 				firstpart := word[:len(word)-2]
-				newtokens := config.retokenize(firstpart+" += 1", " ")
+				newtokens := config.retokenize(firstpart+" += 1", " ", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if strings.HasSuffix(word, "--") {
 				firstpart := word[:len(word)-2]
-				newtokens := config.retokenize(firstpart+" -= 1", " ")
+				newtokens := config.retokenize(firstpart+" -= 1", " ", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if validName(word) {
@@ -303,28 +351,46 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 				t = Token{QUAL, word, statementnr, ""}
 				tokens = append(tokens, t)
 				logtoken(t)
+			} else if elementExpression(word) {
+				t = Token{ELEMENT, word, statementnr, ""}
+				tokens = append(tokens, t)
+				logtoken(t)
+			} else if (!constexpr && !varexpr) && strings.HasSuffix(word, ",") && elementExpression(strings.TrimSuffix(word, ",")) {
+				// An element that is followed by a comma, like the first argument in dot(vs[1], w)
+				t = Token{ELEMENT, strings.TrimSuffix(word, ","), statementnr, ""}
+				tokens = append(tokens, t)
+				logtoken(t)
 			} else if strings.Contains(word, "(") {
-				newtokens := config.retokenize(word, "(")
+				newtokens := config.retokenize(word, "(", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if strings.Contains(word, ")") {
-				newtokens := config.retokenize(word, ")")
+				newtokens := config.retokenize(word, ")", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
+			} else if addr, ok := simpleAddress(word); ok {
+				// An address that is just a register, a value or a name, like [bx] or [0x46c]
+				t = Token{MEMEXP, "[" + addr + "]", statementnr, ""}
+				tokens = append(tokens, t)
+				logtoken(t)
 			} else if strings.Contains(word, "[") {
-				newtokens := config.retokenize(word, "[")
+				newtokens := config.retokenize(word, "[", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if strings.Contains(word, "]") {
-				newtokens := config.retokenize(word, "]")
+				newtokens := config.retokenize(word, "]", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if (!constexpr && !varexpr) && strings.Contains(word, ",") {
-				newtokens := config.retokenize(word, ",")
+				newtokens := config.retokenize(word, ",", statementnr)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if strings.Contains(word, "..") {
-				newtokens := config.retokenize(word, "..")
+				// A range, like 1..10
+				parts := strings.SplitN(word, "..", 2)
+				newtokens := config.retokenize(parts[0], " ", statementnr)
+				newtokens = append(newtokens, Token{RANGE, "..", statementnr, ""})
+				newtokens = append(newtokens, config.retokenize(parts[1], " ", statementnr)...)
 				tokens = append(tokens, newtokens...)
 				lognewtokens(newtokens)
 			} else if strings.Contains(word, "\"") {
@@ -370,11 +436,11 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 					tokens = append(tokens, t)
 					logtoken(t)
 				} else {
-					log.Fatalln("Unrecognized segment:offset token:", word)
+					fatal("Unrecognized segment:offset token:", word)
 				}
 			} else {
 				log.Println("TOKEN", word, "unknown")
-				log.Fatalln("Error: Unrecognized token:", word)
+				fatal("Error: Unrecognized token:", word)
 				return tokens
 			}
 		}
@@ -413,7 +479,7 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				name = st[i+1].Value
 
 				if !has(ps.definedNames, name) {
-					log.Fatalln("Error:", name, "is unfamiliar. Can not find length.")
+					fatal("Error:", name, "is unfamiliar. Can not find length.")
 				}
 
 				// TODO: Create a built-in cap() function too
@@ -433,7 +499,13 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				st = st[:i+1+copy(st[i+1:], st[i+2:])]
 
 				// replace len(name) with _length_of_name, or [_length_of_name] if it's in .bss
-				if _, ok := ps.variables[name]; ok {
+				if v, ok := ps.typedVariables[name]; ok {
+					// The number of elements in an array, or 1
+					st[i] = Token{VALUE, strconv.Itoa(v.count), st[0].Line, ""}
+					if v.count == 0 {
+						st[i].Value = "1"
+					}
+				} else if _, ok := ps.variables[name]; ok {
 					st[i] = Token{tokenType, "[_length_of_" + name + "]", st[0].Line, ""}
 				} else {
 					st[i] = Token{tokenType, "_length_of_" + name, st[0].Line, ""}
@@ -459,8 +531,15 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 			if debug {
 				log.Println("SUCCESSFUL REPLACEMENT WITH", st[i])
 			}
+		} else if (st[i].T == BUILTIN) && (st[i].Value == "sizeof") && ((st[i+1].T == VALIDNAME) || (st[i+1].T == ELEMENT)) {
+			// The built-in sizeof() function, for types, structs, variables and fields
+			size := ps.sizeOf(st[i+1])
+			// remove the element at i+1
+			st = st[:i+1+copy(st[i+1:], st[i+2:])]
+			// replace sizeof(name) with the size in bytes
+			st[i] = Token{VALUE, strconv.Itoa(size), st[0].Line, ""}
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "print") && (st[i+1].T == STRING) {
-			log.Fatalln("Error: print can only print const strings, not immediate strings")
+			fatal("Error: print can only print const strings, not immediate strings")
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "print") && ((st[i+1].T == VALIDNAME) || (st[i+1].T == REGISTER)) {
 			// replace print(msg) with
 			// int(0x80, 4, 1, msg, len(msg)) on 32-bit
@@ -508,7 +587,7 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 			// Replace the current statement with the newly generated tokens
 			st = tokens
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "chr") && (st[i+1].T == VALIDNAME) {
-			log.Fatalln("Error: str of a defined name is to be implemented")
+			fatal("Error: str of a defined name is to be implemented")
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "chr") && (st[i+1].T == REGISTER) {
 			register := st[i+1].Value
 
@@ -526,36 +605,87 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				// replace with the register that contains the address of the string
 				st[i] = Token{REGISTER, "esp", st[0].Line, register} // only a single byte
 			case 16:
-				log.Fatalln("Error: chr() is not implemented for 16-bit platforms")
+				fatal("Error: chr() is not implemented for 16-bit platforms")
 			}
 		}
 	}
 	return st
 }
 
+// nextStatement returns the first statement in the tokens from the given position
+func nextStatement(tokens []Token, pos int) Statement {
+	for (pos < len(tokens)) && (tokens[pos].T == SEP) {
+		pos++
+	}
+	end := pos
+	for (end < len(tokens)) && (tokens[end].T != SEP) {
+		end++
+	}
+	return Statement(tokens[pos:end])
+}
+
+// readsCarry checks if the statement checks the carry flag, or adds or subtracts with carry
+func readsCarry(st Statement) bool {
+	for _, t := range st {
+		if ((t.T == FLAG) && (t.Value == "carry")) || (t.T == ADDCARRY) || (t.T == SUBBORROW) {
+			return true
+		}
+	}
+	return false
+}
+
 // TokensToAssembly outputs assembly code given a compilation target config and a slice of tokens
+// An error in a statement causes a panic with a *CompileError that has the source line of the statement.
 func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2 bool, ps *ProgramState) (string, string) {
 	statement := []Token{}
 	asmcode := ""
 	constants := ""
 	bsscode := ""
-	for _, token := range tokens {
+	line := 0 // the source line of the current statement, counting from 1
+	defer func() {
+		if r := recover(); r != nil {
+			if config.LineMarkers {
+				log.SetPrefix("")
+			}
+			if compileError, ok := r.(*CompileError); ok && (compileError.Line == 0) {
+				compileError.Line = line
+			}
+			panic(r)
+		}
+	}()
+	for i, token := range tokens {
 		if token.T == SEP {
 			if len(statement) > 0 {
+				line = int(statement[0].Line) + 1
+				ps.carryFollows = readsCarry(nextStatement(tokens, i+1))
+				if config.LineMarkers {
+					log.SetPrefix("line " + strconv.Itoa(int(statement[0].Line)+1) + ": ")
+				}
+				inLoop := ps.inLoop
 				asmline := Statement(statement).String(ps, config)
+				if (inLoop != "") && (ps.inLoop == inLoop) {
+					// Keep track of the size of the loop body, to know if the loop instruction can be used
+					ps.loopSize += instructionBytes(asmline)
+				}
+				if config.LineMarkers {
+					asmline = lineMarker + strconv.Itoa(int(statement[0].Line)+1) + "\n" + asmline
+				}
 				if (statement[0].T == KEYWORD) && (statement[0].Value == "const") {
 					if strings.Contains(asmline, ":") {
 						if debug {
 							log.Printf("CONSTANT: \"%s\"\n", strings.Split(asmline, ":")[0])
 						}
 					} else {
-						log.Fatalln("Error: Unfamiliar constant:", asmline)
+						fatal("Error: Unfamiliar constant:", asmline)
 					}
 					constants += asmline + "\n"
 				} else if (statement[0].T == KEYWORD) && (statement[0].Value == "var") {
 					// Variables are gathered for the .bss section
 					bsscode += asmline + "\n"
 				} else {
+					if config.DebugInfo {
+						asmline = lineDirective(int(statement[0].Line)+1, config.debugFilename) + "\n" + asmline
+					}
 					asmcode += asmline + "\n"
 				}
 			}
@@ -564,6 +694,19 @@ func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2
 			statement = append(statement, token)
 		}
 	}
+	if config.LineMarkers {
+		// The code that follows does not belong to any line
+		log.SetPrefix("")
+		asmcode += lineMarker + "0\n"
+	}
+	if ps.heapNeeded && !ps.heapEmitted {
+		asmcode += config.heapRoutines()
+		ps.heapEmitted = true
+	}
+	if ps.floatScratchNeeded && !ps.floatScratchReserved {
+		bsscode += floatScratch + ": resq 3\t\t\t; for moving values between the FPU and registers\n"
+		ps.floatScratchReserved = true
+	}
 	// Add .bss section, if any
 	if bsscode != "" {
 		asmcode += "\nsection .bss\n" + bsscode
@@ -571,6 +714,16 @@ func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2
 	return strings.TrimSpace(constants), asmcode
 }
 
+// lineDirective returns a %line directive for nasm and yasm, that maps the assembly lines that follow to the
+// given source line, until the next directive
+func lineDirective(line int, filename string) string {
+	return "%line " + strconv.Itoa(line) + "+0 " + filename
+}
+
+// lineMarker is placed before the assembly for each statement, followed by the source line number,
+// when line markers are enabled
+const lineMarker = "; line "
+
 // TokenFilter is a function that can check if
 // a given token is one of the allowed types
 type TokenFilter (func(Token) bool)
@@ -0,0 +1,370 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// term is a product of f64 values in memory, like a.y*b.z, that is added to or subtracted from a sum
+type term struct {
+	negative bool
+	factors  []string // memory operands, like [p+8]
+}
+
+// vectorTypes are the built-in vector types, with the number of f64 components
+var vectorTypes = map[string]int{"vec3": 3, "vec4": 4, "quat": 4, "mat4": 16}
+
+// addVectorTypes declares the built-in vector types as structs, so that the components can be used as fields,
+// like v.x or m.w.y. The rows of a mat4 are the vec4 fields x, y, z and w.
+func (ps *ProgramState) addVectorTypes() {
+	component := func(name string, offset int) structField {
+		return structField{name, "f64", offset * 8}
+	}
+	ps.structs["vec3"] = &structType{"vec3", []structField{component("x", 0), component("y", 1), component("z", 2)}, 24}
+	for _, name := range []string{"vec4", "quat"} {
+		ps.structs[name] = &structType{name, []structField{component("x", 0), component("y", 1), component("z", 2), component("w", 3)}, 32}
+	}
+	ps.structs["mat4"] = &structType{"mat4", []structField{{"x", "vec4", 0}, {"y", "vec4", 32}, {"z", "vec4", 64}, {"w", "vec4", 96}}, 128}
+}
+
+// vectorType returns the vector type of a variable or an element, or an empty string if it is not a vector
+func (ps *ProgramState) vectorType(t Token) string {
+	typ := ""
+	switch t.T {
+	case VALIDNAME:
+		if v, ok := ps.typedVariables[t.Value]; ok {
+			typ = v.typ
+		}
+	case ELEMENT:
+		typ = ps.elementType(t.Value)
+	}
+	if _, ok := vectorTypes[typ]; ok {
+		return typ
+	}
+	return ""
+}
+
+// vectorOperands finds the operands for the given vectors and f64 values, together with their types.
+// The addresses may be calculated in registers at the same time, so each operand avoids the registers
+// that are used by the others. The code for calculating the addresses is returned as well.
+func (config *TargetConfig) vectorOperands(ps *ProgramState, tokens ...Token) ([]*floatOperand, []string, string, string) {
+	avoid := ""
+	for _, t := range tokens {
+		if _, index, _, ok := parseElement(t.Value); ok && (t.T == ELEMENT) && (registerBits(index) > 0) {
+			avoid += " " + regFamily(index)
+		}
+	}
+	var (
+		operands      []*floatOperand
+		types         []string
+		before, after string
+	)
+	for _, t := range tokens {
+		var (
+			f   *floatOperand
+			typ string
+		)
+		switch t.T {
+		case FLOATREG:
+			f, typ = config.floatOperand(ps, t, ""), "f64"
+		case VALIDNAME:
+			v, ok := ps.typedVariables[t.Value]
+			if !ok {
+				fatal("Error:", t.Value, "is not a vector or an f64 variable")
+			}
+			if v.count > 0 {
+				fatal("Error:", t.Value, "is an array and needs an index, like "+t.Value+"[0]")
+			}
+			f, typ = &floatOperand{name: t.Value, address: "[" + t.Value + "]"}, v.typ
+		case ELEMENT:
+			if i := indexOfToken(tokens[:len(operands)], t); i != -1 {
+				// The same element is used twice, like in v[rsi] = normalize(v[rsi])
+				f, typ = operands[i], types[i]
+				break
+			}
+			var e *element
+			e, typ = config.elementAddress(ps, t.Value, avoid)
+			f = &floatOperand{t.Value, "", e.address, "", ""}
+			before, after = before+e.before, e.after+after
+			if e.scratch != "" {
+				avoid += " " + regFamily(e.scratch)
+			}
+		default:
+			fatal("Error:", t.Value, "is not a vector or an f64 value")
+		}
+		if _, ok := vectorTypes[typ]; !ok && (typ != "f64") {
+			fatal("Error:", t.Value, "is a", typ, "and not a vector or an f64 value")
+		}
+		operands, types = append(operands, f), append(types, typ)
+	}
+	return operands, types, before, after
+}
+
+// indexOfToken returns the position of the first token with the same type and value as the given one, or -1
+func indexOfToken(tokens []Token, t Token) int {
+	for i, other := range tokens {
+		if (other.T == t.T) && (other.Value == t.Value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// component returns the memory operand for the component of a vector with the given index
+func (f *floatOperand) component(i int) string {
+	if i == 0 {
+		return f.address
+	}
+	return strings.TrimSuffix(f.address, "]") + "+" + strconv.Itoa(i*8) + "]"
+}
+
+// fpuSum returns code that pushes the sum of the given products to the x87 FPU stack
+func fpuSum(terms []term) string {
+	asmcode := ""
+	for i, t := range terms {
+		asmcode += "\tfld qword " + t.factors[0] + "\n"
+		for _, factor := range t.factors[1:] {
+			asmcode += "\tfmul qword " + factor + "\n"
+		}
+		switch {
+		case (i == 0) && t.negative:
+			asmcode += "\tfchs\n"
+		case (i > 0) && t.negative:
+			asmcode += "\tfsubp\n"
+		case i > 0:
+			asmcode += "\tfaddp\n"
+		}
+	}
+	return asmcode
+}
+
+// products returns terms with products of the given components of a and b, like a.y*b.z - a.z*b.y.
+// The signs are given as a string of "+" and "-", and the components as pairs of indices.
+func products(a, b *floatOperand, signs string, indices ...int) []term {
+	var terms []term
+	for i, sign := range signs {
+		terms = append(terms, term{sign == '-', []string{a.component(indices[i*2]), b.component(indices[i*2+1])}})
+	}
+	return terms
+}
+
+// sseDot returns code that calculates the dot product of two vectors with the given number of components,
+// in the lower half of xmm15. The first two components are multiplied with packed SSE2 instructions.
+func sseDot(a, b *floatOperand, n int) string {
+	asmcode := "\tmovupd xmm15, " + a.component(0) + "\n"
+	asmcode += "\tmovupd xmm14, " + b.component(0) + "\n"
+	asmcode += "\tmulpd xmm15, xmm14\n"
+	for i := 2; i < n; i++ {
+		asmcode += "\tmovsd xmm14, " + a.component(i) + "\n"
+		asmcode += "\tmulsd xmm14, " + b.component(i) + "\n"
+		asmcode += "\taddsd xmm15, xmm14\n"
+	}
+	// Add the upper half of xmm15 to the lower half
+	asmcode += "\tmovapd xmm14, xmm15\n"
+	asmcode += "\tunpckhpd xmm14, xmm14\n"
+	return asmcode + "\taddsd xmm15, xmm14\n"
+}
+
+// sseComponents returns code that applies the given SSE2 instruction to each component of a vector, two components
+// at a time, with the value in xmm14 as the source. If load is given, it loads the source for each pair of components.
+func sseComponents(instruction string, dest *floatOperand, n int, load func(i int, move string) string) string {
+	asmcode := ""
+	for i := 0; i < n; i += 2 {
+		move, op := "movupd", strings.TrimSuffix(instruction, "sd")+"pd"
+		if i+1 == n {
+			move, op = "movsd", instruction
+		}
+		if load != nil {
+			asmcode += load(i, move)
+		}
+		if instruction != "movsd" {
+			asmcode += "\t" + move + " xmm15, " + dest.component(i) + "\n"
+			asmcode += "\t" + op + " xmm15, xmm14\n"
+			asmcode += "\t" + move + " " + dest.component(i) + ", xmm15\n"
+		} else {
+			asmcode += "\t" + move + " " + dest.component(i) + ", xmm14\n"
+		}
+	}
+	return asmcode
+}
+
+// emitVectorArithmetic assigns, adds, subtracts or multiplies vectors component by component, like: v += w.
+// Vectors can also be multiplied or divided by an f64 value, and q *= r multiplies two quaternions.
+func emitVectorArithmetic(config *TargetConfig, ps *ProgramState, st Statement) string {
+	operands, types, before, after := config.vectorOperands(ps, st[0], st[2])
+	dest, src := operands[0], operands[1]
+	n := vectorTypes[types[0]]
+	op := st[1].T
+	switch {
+	case (types[1] == "f64") && ((op == MULTIPLICATION) || (op == DIVISION)):
+		return before + config.scaleVector(ps, dest, src, n, op, tokensString(st)) + after
+	case types[0] != types[1]:
+		fatal("Error:", st[0].Value, "is a", types[0], "and", st[2].Value, "is a", types[1]+", in:", tokensString(st))
+	case (op == MULTIPLICATION) && (types[0] == "quat"):
+		return before + multiplyQuaternions(dest, src, tokensString(st)) + after
+	case op == DIVISION:
+		fatal("Error: Vectors can only be divided by f64 values, in:", tokensString(st))
+	}
+	asmcode := before
+	if config.PlatformBits == 64 {
+		instruction := "movsd"
+		if op != ASSIGNMENT {
+			instruction = floatInstructions[op][0]
+		}
+		load := func(i int, move string) string {
+			return "\t" + move + " xmm14, " + src.component(i) + "\n"
+		}
+		asmcode += "\t; " + tokensString(st) + ", with SSE2\n"
+		return asmcode + sseComponents(instruction, dest, n, load) + after
+	}
+	asmcode += "\t; " + tokensString(st) + ", with the FPU\n"
+	for i := 0; i < n; i++ {
+		asmcode += "\tfld qword " + src.component(i) + "\n"
+		if op != ASSIGNMENT {
+			asmcode += "\t" + floatInstructions[op][1] + " qword " + dest.component(i) + "\n"
+		}
+		asmcode += "\tfstp qword " + dest.component(i) + "\n"
+	}
+	return asmcode + after
+}
+
+// scaleVector multiplies or divides each component of a vector with an f64 value
+func (config *TargetConfig) scaleVector(ps *ProgramState, dest, src *floatOperand, n int, op TokenType, comment string) string {
+	if config.PlatformBits == 64 {
+		asmcode := "\t; " + comment + ", with SSE2\n"
+		asmcode += "\tmovsd xmm14, " + src.operand() + "\n"
+		asmcode += "\tunpcklpd xmm14, xmm14\t\t; the value in both halves\n"
+		return asmcode + sseComponents(floatInstructions[op][0], dest, n, nil)
+	}
+	asmcode := "\t; " + comment + ", with the FPU\n" + ps.fpuLoad(src)
+	for i := 0; i < n; i++ {
+		asmcode += "\tfld qword " + dest.component(i) + "\n"
+		asmcode += "\t" + map[TokenType]string{MULTIPLICATION: "fmul", DIVISION: "fdiv"}[op] + " st0, st1\n"
+		asmcode += "\tfstp qword " + dest.component(i) + "\n"
+	}
+	return asmcode + "\tfstp st0\t\t\t\t; pop " + src.name + "\n"
+}
+
+// multiplyQuaternions returns code for q *= r, where q becomes the Hamilton product of q and r.
+// The components are calculated with the FPU and kept on the stack until all of them are ready.
+func multiplyQuaternions(q, r *floatOperand, comment string) string {
+	const x, y, z, w = 0, 1, 2, 3
+	asmcode := "\t; " + comment + ", the quaternion product\n"
+	asmcode += fpuSum(products(q, r, "+++-", w, x, x, w, y, z, z, y))
+	asmcode += fpuSum(products(q, r, "+-++", w, y, x, z, y, w, z, x))
+	asmcode += fpuSum(products(q, r, "++-+", w, z, x, y, y, x, z, w))
+	asmcode += fpuSum(products(q, r, "+---", w, w, x, x, y, y, z, z))
+	for i := w; i >= x; i-- {
+		asmcode += "\tfstp qword " + q.component(i) + "\n"
+	}
+	return asmcode
+}
+
+// emitVectorFunction calculates dot, cross, normalize or rotate, like: xmm0 = dot(v, w) or v = rotate(v, q)
+func emitVectorFunction(config *TargetConfig, ps *ProgramState, st Statement) string {
+	function := st[2].Value
+	operands, types, before, after := config.vectorOperands(ps, append(Statement{st[0]}, st[3:]...)...)
+	dest := operands[0]
+	for i, typ := range types[1:] {
+		if (typ == "mat4") || (typ == "f64") {
+			fatal("Error:", function, "can not be used with", st[3+i].Value, "which is a", typ)
+		}
+	}
+	n := vectorTypes[types[1]]
+	switch function {
+	case "dot":
+		if types[0] != "f64" {
+			fatal("Error: The dot product is an f64 value and can not be assigned to", st[0].Value)
+		}
+		if types[1] != types[2] {
+			fatal("Error:", st[3].Value, "and", st[4].Value, "must be the same vector type, in:", tokensString(st))
+		}
+		a, b := operands[1], operands[2]
+		if config.PlatformBits == 64 {
+			asmcode := before + "\t; " + tokensString(st) + ", with SSE2\n" + sseDot(a, b, n)
+			return asmcode + "\tmovsd " + dest.operand() + ", xmm15\n" + after
+		}
+		var terms []term
+		for i := 0; i < n; i++ {
+			terms = append(terms, term{false, []string{a.component(i), b.component(i)}})
+		}
+		asmcode := before + "\t; " + tokensString(st) + ", with the FPU\n"
+		return asmcode + fpuSum(terms) + ps.fpuStore(dest) + after
+	case "normalize":
+		if types[0] != types[1] {
+			fatal("Error:", st[0].Value, "and", st[3].Value, "must be the same vector type, in:", tokensString(st))
+		}
+		return before + config.normalize(dest, operands[1], n, tokensString(st)) + after
+	case "cross":
+		if (types[0] != "vec3") || (types[1] != "vec3") || (types[2] != "vec3") {
+			fatal("Error: The cross product is only for vec3, in:", tokensString(st))
+		}
+		a, b := operands[1], operands[2]
+		asmcode := before + "\t; " + tokensString(st) + ", with the FPU\n"
+		asmcode += fpuSum(products(a, b, "+-", 1, 2, 2, 1))
+		asmcode += fpuSum(products(a, b, "+-", 2, 0, 0, 2))
+		asmcode += fpuSum(products(a, b, "+-", 0, 1, 1, 0))
+		for i := 2; i >= 0; i-- {
+			asmcode += "\tfstp qword " + dest.component(i) + "\n"
+		}
+		return asmcode + after
+	}
+	// rotate
+	if (types[0] != "vec3") || (types[1] != "vec3") || (types[2] != "quat") {
+		fatal("Error: A vec3 can be rotated by a quat, like \"v = rotate(v, q)\", not:", tokensString(st))
+	}
+	return before + ps.rotate(dest, operands[1], operands[2], tokensString(st)) + after
+}
+
+// normalize returns code that divides each component of a vector with the length of the vector
+func (config *TargetConfig) normalize(dest, src *floatOperand, n int, comment string) string {
+	if config.PlatformBits == 64 {
+		asmcode := "\t; " + comment + ", with SSE2\n" + sseDot(src, src, n)
+		asmcode += "\tsqrtsd xmm15, xmm15\t\t; the length\n"
+		asmcode += "\tunpcklpd xmm15, xmm15\t\t; the length in both halves\n"
+		for i := 0; i < n; i += 2 {
+			move, op := "movupd", "divpd"
+			if i+1 == n {
+				move, op = "movsd", "divsd"
+			}
+			asmcode += "\t" + move + " xmm14, " + src.component(i) + "\n"
+			asmcode += "\t" + op + " xmm14, xmm15\n"
+			asmcode += "\t" + move + " " + dest.component(i) + ", xmm14\n"
+		}
+		return asmcode
+	}
+	var terms []term
+	for i := 0; i < n; i++ {
+		terms = append(terms, term{false, []string{src.component(i), src.component(i)}})
+	}
+	asmcode := "\t; " + comment + ", with the FPU\n" + fpuSum(terms)
+	asmcode += "\tfsqrt\t\t\t\t; the length\n"
+	for i := 0; i < n; i++ {
+		asmcode += "\tfld qword " + src.component(i) + "\n"
+		asmcode += "\tfdiv st0, st1\n"
+		asmcode += "\tfstp qword " + dest.component(i) + "\n"
+	}
+	return asmcode + "\tfstp st0\t\t\t\t; pop the length\n"
+}
+
+// rotate returns code that rotates the vector v by the unit quaternion q, with the FPU:
+// t = 2 * cross(q.xyz, v), then dest = v + q.w * t + cross(q.xyz, t)
+func (ps *ProgramState) rotate(dest, v, q *floatOperand, comment string) string {
+	const x, y, z, w = 0, 1, 2, 3
+	ps.floatScratchNeeded = true
+	t := &floatOperand{name: floatScratch, address: "[" + floatScratch + "]"}
+	asmcode := "\t; " + comment + ", with the FPU\n"
+	for i, indices := range [][]int{{y, z, z, y}, {z, x, x, z}, {x, y, y, x}} {
+		asmcode += fpuSum(products(q, v, "+-", indices...))
+		asmcode += "\tfadd st0, st0\n"
+		asmcode += "\tfstp qword " + t.component(i) + "\n"
+	}
+	for i, indices := range [][]int{{y, z, z, y}, {z, x, x, z}, {x, y, y, x}} {
+		terms := []term{{false, []string{v.component(i)}}, {false, []string{q.component(w), t.component(i)}}}
+		asmcode += fpuSum(append(terms, products(q, t, "+-", indices...)...))
+	}
+	for i := z; i >= x; i-- {
+		asmcode += "\tfstp qword " + dest.component(i) + "\n"
+	}
+	return asmcode
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVectorArithmetic(t *testing.T) {
+	st := Statement{{VALIDNAME, "p", 0, ""}, {ADDITION, "+=", 0, ""}, {VALIDNAME, "n", 0, ""}}
+	for bits, expected := range map[int][]string{64: {"addpd xmm15, xmm14", "movsd [p+16], xmm15"}, 32: {"fadd qword [p+16]", "fstp qword [p+16]"}} {
+		config, err := NewTargetConfig(bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := NewProgramState()
+		ps.typedVariables["p"] = &typedVariable{"vec3", 0}
+		ps.typedVariables["n"] = &typedVariable{"vec3", 0}
+		asmcode := emitVectorArithmetic(config, ps, st)
+		for _, s := range expected {
+			if !strings.Contains(asmcode, s) {
+				t.Errorf("Expected %s in the %d-bit code for p += n:\n%s\n", s, bits, asmcode)
+			}
+		}
+	}
+}
@@ -2,53 +2,40 @@ package lib
 
 import (
 	"log"
+	"strconv"
 	"strings"
 )
 
-// ExtractInlineC retrieves the C code between:
+// ExtractInlineC retrieves the C code of the blocks that CBlocks finds, which are the C code between:
 //   inline_c...end
-// or
-//   void...}
+// and C functions, like:
+//   int add(int a, int b) {...}
 func ExtractInlineC(code string, debug bool) string {
-	var (
-		clines       string
-		inBlockType1 bool
-		inBlockType2 bool
-		whitespace   = -1 // Where to strip whitespace
-	)
-	for _, line := range strings.Split(code, "\n") {
-		firstword := strings.TrimSpace(removecomments(line))
-		if pos := strings.Index(firstword, " "); pos != -1 {
-			firstword = firstword[:pos]
-		}
-		//log.Println("firstword: "+ firstword)
-		if !inBlockType2 && !inBlockType1 && (firstword == "inline_c") {
-			log.Println("found", firstword, "starting inline_c block")
-			inBlockType1 = true
-			// Don't include "inline_c" in the inline C code
-			continue
-		} else if !inBlockType1 && !inBlockType2 && (firstword == "void") {
-			log.Println("found", firstword, "starting inBlockType2 block")
-			inBlockType2 = true
-			// Include "void" in the inline C code
-		} else if !inBlockType2 && inBlockType1 && (firstword == "end") {
-			log.Println("found", firstword, "ending inline_c block")
-			inBlockType1 = false
-			// Don't include "end" in the inline C code
-			continue
-		} else if !inBlockType1 && inBlockType2 && (firstword == "}") {
-			log.Println("found", firstword, "ending inBlockType2 block")
-			inBlockType2 = false
-			// Include "}" in the inline C code
-		}
+	return extractInlineC(code, "", 1)
+}
 
-		if !inBlockType1 && !inBlockType2 && (firstword != "}") {
-			// Skip lines that are not in an "inline_c ... end" or "void ... }" block.
-			//log.Println("not C, skipping:", line)
-			continue
+// extractInlineC is like ExtractInlineC, but if a filename is given, each block of C code starts with a #line
+// directive, so that the C compiler and debuggers refer to the lines in the Battlestar source file.
+// firstLine is the line number of the first line of the code.
+func extractInlineC(code, filename string, firstLine int) string {
+	var clines string
+	for _, block := range CBlocks(strings.Split(code, "\n")) {
+		log.Println("found a block of inline C at line", firstLine+block.Start)
+		if (filename != "") && (len(block.Code) > 0) {
+			clines += "#line " + strconv.Itoa(firstLine+block.CodeStart) + " " + strconv.Quote(filename) + "\n"
 		}
+		clines += unindent(block.Code)
+	}
+	return clines
+}
 
-		// Detect whitespace, once and only for some variations
+// unindent returns the given lines of a block of inline code, with the indentation of the first line
+// stripped from each line, if it is only whitespace
+func unindent(lines []string) string {
+	var code string
+	whitespace := -1 // Where to strip whitespace
+	for _, line := range lines {
+		// Detect whitespace, once for each block and only for some variations
 		if whitespace == -1 {
 			if strings.HasPrefix(line, "    ") {
 				whitespace = 4
@@ -62,12 +49,12 @@ func ExtractInlineC(code string, debug bool) string {
 		}
 		// Strip whitespace, and check that only whitespace has been stripped
 		if (len(line) >= whitespace) && (strings.TrimSpace(line) == strings.TrimSpace(line[whitespace:])) {
-			clines += line[whitespace:] + "\n"
+			code += line[whitespace:] + "\n"
 		} else {
-			clines += line + "\n"
+			code += line + "\n"
 		}
 	}
-	return clines
+	return code
 }
 
 // AddExternMainIfMissing will add "extern main" at the top if
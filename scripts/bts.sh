@@ -62,6 +62,9 @@ function usage {
   echo ' bts build --bits=32 [FILE]    - build native 32-bit executable'
   echo ' bts build --bits=16 [FILE]    - build native 16-bit executable'
   echo '                                 also create dosbox launcher script'
+  echo ' bts build bootsector [FILE]   - build a 512-byte boot sector disk image'
+  echo '                                 also create qemu launcher script'
+  echo ' bts build -g                  - build with debug information, for gdb'
   echo ' bts compile [FILE]            - build object file'
   echo ' bts clean                     - remove stray files'
   echo ' bts size                      - analyze log files after building'
@@ -51,6 +51,10 @@ function build {
     params="$params -osx=$osx"
   fi
 
+  if [[ $bootsector = true ]]; then
+    params="$params -target=bootsector"
+  fi
+
   if [[ $other_compiler = true ]]; then
     params="$params -c"
   fi
@@ -58,10 +62,10 @@ function build {
   # Don't output the log if "fail" is in the filename
   if [[ $n != *fail* ]]; then
     #rm -f "$n.asm" "$n.c" "$n.log" "$n.com" "$n.sh"
-    battlestarc $params -f "$f" -o "$n.asm" -oc "$n.c" 2> "$n.log" || (cat "$n.log"; rm -f "$n.asm"; echo "$n.log" >> "$n.log"; echo "$n failed to build!"; return 1; )
+    battlestarc $params -f "$f" -o "$n.asm" -oc "$n.c" -of "$n.flags" 2> "$n.log" || (cat "$n.log"; rm -f "$n.asm"; echo "$n.log" >> "$n.log"; echo "$n failed to build!"; return 1; )
   else
     #rm -f "$n.asm" "$n.c" "$n.log" "$n.com" "$n.sh"
-    battlestarc $params -f "$f" -o "$n.asm" -oc "$n.c" 2> "$n.log" || (rm -f "$n.asm"; echo "$n.log" >> "$n.log"; echo "$n failed to build (correct)"; return 2; )
+    battlestarc $params -f "$f" -o "$n.asm" -oc "$n.c" -of "$n.flags" 2> "$n.log" || (rm -f "$n.asm"; echo "$n.log" >> "$n.log"; echo "$n failed to build (correct)"; return 2; )
   fi
 
   # Only return with an error code of the build failed and was not meant to fail
@@ -75,6 +79,24 @@ function build {
     return $retval
   fi
 
+  # Compile and link against the C libraries that are pulled in with "use", if any
+  local cccmd=$cccmd
+  local ldcmd=$ldcmd
+  local ldlibs=""
+  local skipstrip=$skipstrip
+  local BTS_CFLAGS="" BTS_LIBS="" BTS_INLINE="" BTS_OBJECTS=""
+  if [[ -f $n.flags ]]; then
+    source "$n.flags"
+  fi
+  if [[ -n $BTS_CFLAGS$BTS_LIBS ]]; then
+    echo "Using C libraries: $BTS_LIBS"
+    cccmd="$cccmd $BTS_CFLAGS"
+    ldcmd="gcc -no-pie -nostdlib -m$bits"
+    ldlibs="$BTS_LIBS"
+    # Stripping sections may break dynamically linked executables
+    skipstrip=true
+  fi
+
   if [[ $pic = "true" ]]; then
     # Add "default rel" to the top of the assembly file
     sed -i 's,bits 64,bits 64\ndefault rel,g' $n.asm
@@ -103,9 +125,23 @@ function build {
   else
     echo "WARNING: Can't compile inline C for 64-bit executables on a 32-bit system."
   fi
+  # Compile the code of the other inline languages, like Zig, with the commands from the .flags file
+  if [[ -n $BTS_INLINE ]]; then
+    echo "$BTS_INLINE"
+    eval "$BTS_INLINE" || abort "$n failed to compile"
+  fi
   asmok=true
   [ -e $n.asm ] && echo $asmcmd -o "$n.o" "$n.asm"
-  [ -e $n.asm ] && ($asmcmd -o "$n.o" "$n.asm" || asmok=false)
+  if [[ $bootsector = true ]] && [ -e $n.asm ]; then
+    # If the boot sector is too large, the padding at the end is negative, which the assembler stops at
+    asmout=`$asmcmd -o "$n.o" "$n.asm" 2>&1` || asmok=false
+    [[ -n $asmout ]] && echo "$asmout"
+    if [[ $asmok = false ]] && [[ ${asmout,,} = *negative* ]]; then
+      echo "$n does not fit in a boot sector, the code and the constants must be at most 510 bytes."
+    fi
+  else
+    [ -e $n.asm ] && ($asmcmd -o "$n.o" "$n.asm" || asmok=false)
+  fi
   if [[ $asmok = false ]]; then
     [ -e $n.asm ] && echo "Failed to assemble: $n."
   else
@@ -134,10 +170,21 @@ function build {
   echo -e "\n$n $n.asm $n.c $n.o ${n}_c.o $n $n.log" >> "$n.log"
   return 0
       else
-        $ldcmd "${n}_c.o" "$n.o" -o "$n" || echo "$n failed to link"
+        $ldcmd "${n}_c.o" "$n.o" $BTS_OBJECTS -o "$n" $ldlibs || echo "$n failed to link"
       fi
     elif [ -e $n.o ]; then
-      if [[ $bits = 16 ]]; then
+      if [[ $bootsector = true ]]; then
+        # The output file is a disk image with just the boot sector
+        mv "$n.o" "$n.img"
+        [[ `wc -c < "$n.img"` == 512 ]] || abort "$n.img is not 512 bytes"
+  # Create a script for booting it with qemu
+  echo '#!/bin/sh' > "$n.sh"
+  echo "qemu-system-i386 -drive format=raw,file=$n.img" >> "$n.sh"
+  chmod +x "$n.sh"
+  # Save the filenames for later cleaning
+  echo -e "\n$n.asm $n.img $n.log $n.sh" >> "$n.log"
+  return 0
+      elif [[ $bits = 16 ]]; then
       	# The output file is a .com file
         mv "$n.o" "$n.com"
   # Create a script for running it with dosbox
@@ -159,7 +206,7 @@ function build {
       return 0
     fi
   else
-          $ldcmd "$n.o" -o "$n" || echo "$n failed to link"
+          $ldcmd "$n.o" $BTS_OBJECTS -o "$n" $ldlibs || echo "$n failed to link"
   fi
       fi
     fi
@@ -171,7 +218,7 @@ function build {
     require sstrip 2 && (sstrip "$n" 2>/dev/null)
   fi
   # Save the filenames for later cleaning
-  echo -e "\n$n $n.asm $n.c $n.o ${n}_c.o $n $n.log" >> "$n.log"
+  echo -e "\n$n $n.asm $n.c $n.o ${n}_c.o $BTS_OBJECTS $n $n.flags $n.log" >> "$n.log"
 
   # Check if an executable has been generated and return a value accordingly
   [ -e $n ] && return 0 || return 1
@@ -296,12 +343,33 @@ if [[ $1 == bootable ]]; then
   skipstrip=true
 fi
 
+bootsector=false
+if [[ $1 == bootsector ]]; then
+  shift
+
+  echo "Building a boot sector (16-bits)."
+  echo
+
+  bootsector=true
+  bits=16
+  asmcmd="$asm -f bin"
+  skipstrip=true
+fi
+
 if [[ $osx = true ]]; then
   asmcmd="$asm -f macho"
   ldcmd='ld -macosx_version_min 10.8 -lSystem'
   bits=32
 fi
 
+# Debug builds, with -g, keep the line information for gdb (there is no debug format for .com files)
+if [[ " $@ " = *' -g '* ]] && [[ $bits != 16 ]]; then
+  [[ $asm = yasm ]] && asmcmd="$asmcmd -g dwarf2" || asmcmd="$asmcmd -g -F dwarf"
+  cccmd="${cccmd/-Os/-O1 -g}"
+  ldcmd="${ldcmd/ -s / }"
+  skipstrip=true
+fi
+
 # Build one file or *.bts
 if [[ -f $2 ]]; then
   # For when -c is given